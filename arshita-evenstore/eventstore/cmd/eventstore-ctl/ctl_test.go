@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"eventstore/internal/store"
+)
+
+// run calls a subcommand and returns what it printed.
+func run(t *testing.T, cmd func([]string) error, args ...string) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	err = cmd(args)
+	os.Stdout = stdout
+	w.Close()
+	return <-out, err
+}
+
+// dataDir makes a stopped store with three segments; closing flushed the
+// last event.
+func dataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := store.NewLSMStore(store.Options{DataDir: dir, MemtableMaxItems: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	put := func(key string, ts int64) {
+		if err := s.Put(ctx, store.Event{Key: key, TS: ts, Value: []byte(`{"k":"` + key + `"}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a", 1)
+	put("b", 2)
+	s.Flush(ctx)
+	put("a", 3)
+	s.Flush(ctx)
+	put("c", 4)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestDumpCommands(t *testing.T) {
+	dir := dataDir(t)
+	m, err := store.ReadManifest(dir)
+	if err != nil || len(m.Segments) != 3 {
+		t.Fatalf("manifest: %+v %v", m, err)
+	}
+	seg := store.SegmentPath(dir, m.Segments[0].Name)
+	if out, err := run(t, sstDump, seg); err != nil || strings.Count(out, "\n") != 2 {
+		t.Errorf("sst dump: %v %q", err, out)
+	}
+	if out, err := run(t, sstGet, seg, "b"); err != nil || !strings.Contains(out, `"value":{"k":"b"}`) {
+		t.Errorf("sst get: %v %q", err, out)
+	}
+	if _, err := run(t, sstGet, seg, "zz"); err == nil {
+		t.Error("sst get of a missing key succeeded")
+	}
+	if out, err := run(t, manifestShow, "-data", dir); err != nil || !strings.Contains(out, m.Segments[1].Name) {
+		t.Errorf("manifest show: %v %q", err, out)
+	}
+}
+
+func TestWALDump(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewLSMStore(store.Options{DataDir: dir, MemtableMaxItems: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.PutBatch(context.Background(), []store.Event{{Key: "a", TS: 1, Value: []byte(`{}`)}, {Key: "b", TS: 2, Value: []byte(`{}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(store.WALPath(dir), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("torn\n")
+	f.Close()
+	out, err := run(t, walDump, "-data", dir)
+	if err != nil || strings.Count(out, `"batch":true`) != 2 || !strings.Contains(out, `"seq":2`) || !strings.Contains(out, `"error"`) {
+		t.Errorf("wal dump: %v %q", err, out)
+	}
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	dir := dataDir(t)
+	if out, err := run(t, verify, "-data", dir); err != nil {
+		t.Fatalf("fresh dir: %v\n%s", err, out)
+	}
+	m, _ := store.ReadManifest(dir)
+	seg := store.SegmentPath(dir, m.Segments[0].Name)
+	if err := os.WriteFile(seg+".index.json", []byte(`{"a":999}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(dir, "sst", "999999.sst")
+	if err := os.WriteFile(orphan, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run(t, verify, "-data", dir)
+	if err == nil || !strings.Contains(out, "rebuild-index") || !strings.Contains(out, "orphaned file sst/999999.sst") {
+		t.Fatalf("damaged dir: %v\n%s", err, out)
+	}
+
+	if _, err := run(t, rebuildIndex, "-data", dir); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := run(t, gcOrphans, "-data", dir, "-dry-run"); !strings.Contains(out, "would remove sst/999999.sst") {
+		t.Errorf("dry run: %q", out)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatal("dry run removed the orphan")
+	}
+	if _, err := run(t, gcOrphans, "-data", dir); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := run(t, verify, "-data", dir); err != nil {
+		t.Fatalf("after repair: %v\n%s", err, out)
+	}
+}
+
+func TestOfflineCompact(t *testing.T) {
+	dir := dataDir(t)
+	out, err := run(t, compact, "-data", dir)
+	if err != nil || !strings.Contains(out, "compacted 3 segments into 1") {
+		t.Fatalf("compact: %v %q", err, out)
+	}
+	m, _ := store.ReadManifest(dir)
+	if len(m.Segments) != 1 {
+		t.Fatalf("segments after compact: %+v", m.Segments)
+	}
+	if out, err := run(t, sstDump, store.SegmentPath(dir, m.Segments[0].Name)); err != nil || strings.Count(out, "\n") != 3 {
+		t.Errorf("compacted segment: %v %q", err, out)
+	}
+	if _, err := run(t, compact, "-data", filepath.Join(dir, "missing")); err == nil {
+		t.Error("compact created a store in an empty directory")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"eventstore/internal/store"
+)
+
+func walDump(args []string) error {
+	fs, data := flags("wal dump")
+	fs.Parse(args)
+	path := store.WALPath(*data)
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	return store.ScanWAL(path, func(rec store.WALRecord) error {
+		if rec.Err != nil {
+			return printJSON(map[string]any{"line": rec.Line, "error": rec.Err.Error()})
+		}
+		for _, e := range rec.Events {
+			out := map[string]any{"line": rec.Line, "key": e.Key, "ts": e.TS, "seq": e.Seq, "value": value(e.Value)}
+			if rec.Batch {
+				out["batch"] = true
+			}
+			if err := printJSON(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func sstDump(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: sst dump <file>")
+	}
+	return store.ScanSegment(args[0], func(rec store.SegmentRecord) error {
+		if rec.Err != nil {
+			return printJSON(map[string]any{"offset": rec.Offset, "error": rec.Err.Error()})
+		}
+		e := rec.Event
+		return printJSON(map[string]any{"offset": rec.Offset, "key": e.Key, "ts": e.TS, "seq": e.Seq, "value": value(e.Value)})
+	})
+}
+
+func sstGet(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: sst get <file> <key>")
+	}
+	e, ok, err := store.SegmentGet(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("key %q not in %s", args[1], args[0])
+	}
+	return printJSON(map[string]any{"key": e.Key, "ts": e.TS, "seq": e.Seq, "value": value(e.Value)})
+}
+
+func manifestShow(args []string) error {
+	fs, data := flags("manifest show")
+	fs.Parse(args)
+	m, err := store.ReadManifest(*data)
+	if err != nil {
+		return err
+	}
+	return printJSON(m)
+}
@@ -0,0 +1,90 @@
+// Command eventstore-ctl inspects and repairs a data directory while the
+// server is stopped.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `usage: eventstore-ctl <command> [flags] [args]
+
+commands:
+  wal dump [-data dir] [file]          print WAL records as NDJSON
+  sst dump <file>                      print a segment's events as NDJSON
+  sst get <file> <key>                 look a key up through the segment index
+  manifest show [-data dir]            print the manifest with segment sizes
+  verify [-data dir]                   check segments, indexes, manifest and WAL
+  rebuild-index [-data dir] [file...]  regenerate .index.json sidecars
+  gc-orphans [-data dir] [-dry-run]    delete segment files not in the manifest
+  compact [-data dir] [-retention d]   flush the WAL and merge all segments
+
+-data defaults to $DATA_DIR, then ./data. Do not run repairs against a
+directory a server has open.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	if (cmd == "wal" || cmd == "sst" || cmd == "manifest") && len(args) > 0 {
+		cmd, args = cmd+" "+args[0], args[1:]
+	}
+	var err error
+	switch cmd {
+	case "wal dump":
+		err = walDump(args)
+	case "sst dump":
+		err = sstDump(args)
+	case "sst get":
+		err = sstGet(args)
+	case "manifest show":
+		err = manifestShow(args)
+	case "verify":
+		err = verify(args)
+	case "rebuild-index":
+		err = rebuildIndex(args)
+	case "gc-orphans":
+		err = gcOrphans(args)
+	case "compact":
+		err = compact(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "eventstore-ctl:", err)
+		os.Exit(1)
+	}
+}
+
+// flags is a subcommand's flag set with the shared -data flag.
+func flags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	def := os.Getenv("DATA_DIR")
+	if def == "" {
+		def = "./data"
+	}
+	return fs, fs.String("data", def, "data directory")
+}
+
+// printJSON writes v as one line of JSON to stdout.
+func printJSON(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// value keeps valid JSON as is and quotes anything else, so a corrupt value
+// does not break the NDJSON output.
+func value(b []byte) any {
+	if json.Valid(b) {
+		return json.RawMessage(b)
+	}
+	return string(b)
+}
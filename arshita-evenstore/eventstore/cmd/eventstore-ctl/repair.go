@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"eventstore/internal/store"
+)
+
+// rebuildIndex regenerates the key index of the given segment files, or of
+// every manifest segment when none are named.
+func rebuildIndex(args []string) error {
+	fs, data := flags("rebuild-index")
+	fs.Parse(args)
+	paths := fs.Args()
+	if len(paths) == 0 {
+		m, err := store.ReadManifest(*data)
+		if err != nil {
+			return err
+		}
+		for _, seg := range m.Segments {
+			paths = append(paths, store.SegmentPath(*data, seg.Name))
+		}
+	}
+	for _, p := range paths {
+		n, err := store.RebuildKeyIndex(p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: indexed %d keys\n", p, n)
+	}
+	return nil
+}
+
+func gcOrphans(args []string) error {
+	fs, data := flags("gc-orphans")
+	dry := fs.Bool("dry-run", false, "list orphans without deleting them")
+	fs.Parse(args)
+	m, err := store.ReadManifest(*data)
+	if err != nil {
+		return err
+	}
+	orphans, err := orphanFiles(*data, m)
+	if err != nil {
+		return err
+	}
+	for _, o := range orphans {
+		if *dry {
+			fmt.Println("would remove sst/" + o)
+			continue
+		}
+		if err := os.Remove(filepath.Join(*data, "sst", o)); err != nil {
+			return err
+		}
+		fmt.Println("removed sst/" + o)
+	}
+	return nil
+}
+
+// compact opens the store, which replays the WAL, flushes it and merges
+// all segments into one.
+func compact(args []string) error {
+	fs, data := flags("compact")
+	retention := fs.Duration("retention", 0, "also drop events older than this")
+	fs.Parse(args)
+	if _, err := store.ReadManifest(*data); err != nil {
+		return err // refuse to create a store where there is none
+	}
+	s, err := store.NewLSMStore(store.Options{DataDir: *data, Retention: *retention})
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	st, err := flushAndCompact(s)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("compacted %d segments into %d: %d events in, %d out, %s\n",
+		st.SegmentsBefore, st.SegmentsAfter, st.EventsIn, st.EventsOut, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func flushAndCompact(s *store.LSMStore) (store.CompactStats, error) {
+	ctx := context.Background()
+	if err := s.Flush(ctx); err != nil {
+		return store.CompactStats{}, err
+	}
+	return s.Compact(ctx)
+}
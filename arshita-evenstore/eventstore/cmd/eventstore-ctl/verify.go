@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"eventstore/internal/store"
+)
+
+// verify checks every manifest segment against its data file and key
+// index, looks for orphaned files and reads the WAL. It fails if anything
+// is wrong.
+func verify(args []string) error {
+	fs, data := flags("verify")
+	fs.Parse(args)
+	m, err := store.ReadManifest(*data)
+	if err != nil {
+		return err
+	}
+	problems := 0
+	report := func(format string, a ...any) {
+		problems++
+		fmt.Printf("problem: "+format+"\n", a...)
+	}
+
+	for _, seg := range m.Segments {
+		before := problems
+		path := store.SegmentPath(*data, seg.Name)
+		offsets := map[string]int64{}
+		minTS, maxTS := int64(math.MaxInt64), int64(math.MinInt64)
+		var maxSeq uint64
+		bad := 0
+		err := store.ScanSegment(path, func(rec store.SegmentRecord) error {
+			if rec.Err != nil {
+				bad++
+				return nil
+			}
+			e := rec.Event
+			if _, dup := offsets[e.Key]; dup {
+				report("%s: key %q appears more than once", seg.Name, e.Key)
+			}
+			offsets[e.Key] = rec.Offset
+			minTS, maxTS = min(minTS, e.TS), max(maxTS, e.TS)
+			maxSeq = max(maxSeq, e.Seq)
+			return nil
+		})
+		if err != nil {
+			report("%s: %v", seg.Name, err)
+			continue
+		}
+		if bad > 0 {
+			report("%s: %d unreadable lines", seg.Name, bad)
+		}
+		full := seg.MinTS == math.MinInt64 && seg.MaxTS == math.MaxInt64
+		if len(offsets) > 0 && !full && (minTS < seg.MinTS || maxTS > seg.MaxTS) {
+			report("%s: events span ts %d..%d but the manifest records %d..%d", seg.Name, minTS, maxTS, seg.MinTS, seg.MaxTS)
+		}
+		if maxSeq > m.LastSeq {
+			report("%s: holds seq %d past the manifest's last_seq %d", seg.Name, maxSeq, m.LastSeq)
+		}
+		idx, err := store.ReadKeyIndex(path)
+		if err != nil {
+			report("%s: key index: %v (run rebuild-index)", seg.Name, err)
+			continue
+		}
+		stale := len(idx) != len(offsets)
+		for k, off := range offsets {
+			if idx[k] != off {
+				stale = true
+				break
+			}
+		}
+		if stale {
+			report("%s: key index does not match the data file (run rebuild-index)", seg.Name)
+		}
+		if problems == before {
+			fmt.Printf("ok: %s %d events\n", seg.Name, len(offsets))
+		}
+	}
+
+	orphans, err := orphanFiles(*data, m)
+	if err != nil {
+		return err
+	}
+	for _, o := range orphans {
+		report("orphaned file sst/%s (run gc-orphans)", o)
+	}
+
+	walEvents, walBad := 0, 0
+	err = store.ScanWAL(store.WALPath(*data), func(rec store.WALRecord) error {
+		if rec.Err != nil {
+			walBad++
+		}
+		walEvents += len(rec.Events)
+		return nil
+	})
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		report("wal: %v", err)
+	case walBad > 0:
+		report("wal: %d unreadable records (skipped on recovery)", walBad)
+	default:
+		fmt.Printf("ok: wal %d events\n", walEvents)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	fmt.Println("ok: no problems found")
+	return nil
+}
+
+// orphanFiles lists files in sst/ that belong to no manifest segment:
+// segments a crashed flush or compaction left behind, and their sidecars.
+func orphanFiles(dataDir string, m store.ManifestInfo) ([]string, error) {
+	live := map[string]bool{}
+	for _, seg := range m.Segments {
+		live[seg.Name] = true
+	}
+	entries, err := os.ReadDir(filepath.Join(dataDir, "sst"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		i := strings.Index(name, ".sst")
+		if e.IsDir() || i < 0 {
+			continue // not ours
+		}
+		if !live[name[:i+len(".sst")]] {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"eventstore/internal/config"
+	"eventstore/internal/mw"
+	"eventstore/internal/tlsconf"
+)
+
+// authRules gives every route its scope; the first match wins and anything
+// unmatched needs admin. Paths are as seen by the event API, so the same
+// table serves the default keyspace and each /ns/{ns}/ handler.
+var authRules = []mw.Rule{
+	{Prefix: "/healthz", Public: true},
+	{Method: http.MethodGet, Prefix: "/livez", Public: true},
+	{Method: http.MethodGet, Prefix: "/readyz", Public: true},
+	{Prefix: "/ns/"}, // any valid credential; the namespace's own handler checks the route
+	{Method: http.MethodGet, Prefix: "/events", Scope: mw.ScopeRead},
+	{Method: http.MethodPost, Prefix: "/events", Scope: mw.ScopeWrite},
+	{Method: http.MethodGet, Prefix: "/projections", Scope: mw.ScopeRead},
+	{Method: http.MethodGet, Prefix: "/indexes", Scope: mw.ScopeRead},
+	{Method: http.MethodGet, Prefix: "/schemas", Scope: mw.ScopeRead},
+	{Method: http.MethodGet, Prefix: "/deadletters", Scope: mw.ScopeRead},
+	{Method: http.MethodGet, Prefix: "/namespaces", Scope: mw.ScopeRead},
+	{Method: http.MethodGet, Prefix: "/metrics", Scope: mw.ScopeRead},
+}
+
+// newAuth builds the auth middleware from the keys file and JWT secret.
+// With neither set authentication is off and nil is returned. Keys reload
+// when the file changes and on SIGHUP.
+func newAuth(c config.Auth) (*mw.Auth, error) {
+	if c.KeysFile == "" && c.JWTSecret == "" {
+		slog.Warn("auth disabled: set auth.keys_file and/or auth.jwt_secret to require credentials")
+		return nil, nil
+	}
+	a, err := mw.NewAuth(c.KeysFile, []byte(c.JWTSecret), authRules)
+	if err != nil {
+		return nil, err
+	}
+	go a.Watch(context.Background(), time.Duration(c.ReloadInterval))
+	return a, nil
+}
+
+// protect wraps h with a, if authentication is enabled.
+func protect(a *mw.Auth, h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return a.Wrap(h)
+}
+
+// newTLS loads the server certificate, plus a client CA for client
+// certificates (required when client_auth is "require"). Without a
+// certificate the server stays on plain HTTP and nil is returned.
+func newTLS(c config.TLS) (*tlsconf.Reloader, error) {
+	if c.CertFile == "" {
+		return nil, nil
+	}
+	r, err := tlsconf.New(tlsconf.Options{
+		CertFile:     c.CertFile,
+		KeyFile:      c.KeyFile,
+		ClientCAFile: c.ClientCAFile,
+		RequireCert:  c.ClientAuth == "require",
+	})
+	if err != nil {
+		return nil, err
+	}
+	go r.Watch(context.Background(), time.Duration(c.ReloadInterval))
+	return r, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"eventstore/internal/config"
+	"eventstore/internal/mw"
+)
+
+// loadConfig reads the config file named by -config (or CONFIG_FILE) with
+// env and flags layered on top, exiting with the validation errors if it
+// is invalid. overrides re-applies the flags on reload.
+func loadConfig() (cfg config.Config, file string, overrides func(*config.Config)) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&file, "config", os.Getenv("CONFIG_FILE"), "JSON config file")
+	addr := fs.String("http-addr", "", "listen address (overrides http_addr)")
+	dataDir := fs.String("data-dir", "", "data directory (overrides data_dir)")
+	logLevel := fs.String("log-level", "", "debug, info, warn or error (overrides log.level)")
+	logFormat := fs.String("log-format", "", "json or text (overrides log.format)")
+	fs.Parse(os.Args[1:])
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	overrides = func(c *config.Config) {
+		if set["http-addr"] {
+			c.HTTPAddr = *addr
+		}
+		if set["data-dir"] {
+			c.DataDir = *dataDir
+		}
+		if set["log-level"] {
+			c.Log.Level = *logLevel
+		}
+		if set["log-format"] {
+			c.Log.Format = *logFormat
+		}
+	}
+	cfg, err := config.Load(file, overrides)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return cfg, file, overrides
+}
+
+// liveConfig is the configuration in effect, which reloads may change.
+type liveConfig struct {
+	mu  sync.Mutex
+	cfg config.Config
+}
+
+func newLiveConfig(c config.Config) *liveConfig { return &liveConfig{cfg: c} }
+
+func (l *liveConfig) get() config.Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+// apply takes the reloadable settings from next and returns the new config
+// in effect, or nil when none of them changed. Other changes are reported
+// as needing a restart.
+func (l *liveConfig) apply(next config.Config) *config.Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	applied := l.cfg.WithReloadable(next)
+	if restart := applied.Diff(next); len(restart) > 0 {
+		slog.Warn("config changes need a restart to take effect", "settings", restart)
+	}
+	changed := l.cfg.Diff(applied)
+	if len(changed) == 0 {
+		slog.Info("config reloaded, nothing to apply")
+		return nil
+	}
+	l.cfg = applied
+	slog.Info("config reloaded", "changed", changed)
+	return &applied
+}
+
+// reloadOnSIGHUP calls reload for every SIGHUP.
+func reloadOnSIGHUP(reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		reload()
+	}
+}
+
+func breakerSettings(c config.Breaker) mw.BreakerSettings {
+	return mw.BreakerSettings{
+		MinRequests:      c.MinRequests,
+		FailureRatio:     c.FailureRatio,
+		Interval:         time.Duration(c.Interval),
+		OpenTimeout:      time.Duration(c.OpenTimeout),
+		HalfOpenRequests: c.HalfOpenRequests,
+	}
+}
+
+func rateLimitSettings(c config.RateLimit) mw.RateLimitSettings {
+	return mw.RateLimitSettings{
+		ReadRPS:    c.RPS,
+		ReadBurst:  c.Burst,
+		WriteRPS:   c.WriteRPS,
+		WriteBurst: c.WriteBurst,
+		Costs:      c.RouteCosts,
+		MaxClients: c.MaxClients,
+	}
+}
+
+// rateLimitKey picks the client key for rate_limit.key, which Validate has
+// already checked.
+func rateLimitKey(key string) mw.KeyFunc {
+	if name, ok := strings.CutPrefix(key, "header:"); ok {
+		return mw.ByHeader(name)
+	}
+	if key == "principal" {
+		return mw.ByPrincipal
+	}
+	return mw.ClientIP
+}
+
+func concurrencySettings(c config.Concurrency) mw.ConcurrencySettings {
+	return mw.ConcurrencySettings{
+		Initial:       c.Initial,
+		Min:           c.Min,
+		Max:           c.Max,
+		TargetLatency: time.Duration(c.TargetLatency),
+		Backoff:       c.Backoff,
+		Priority:      c.Priority,
+		Reserve:       c.Reserve,
+	}
+}
+
+// unshed exempts probes, metrics, streams and admin calls from the
+// concurrency limiter: streams would hold a slot for as long as they are
+// open, and a compaction's latency says nothing about load.
+func unshed(r *http.Request) bool {
+	p := r.URL.Path
+	if rest, ok := strings.CutPrefix(p, "/ns/"); ok {
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			p = rest[i:]
+		}
+	}
+	switch p {
+	case "/livez", "/readyz", "/metrics", "/events/stream", "/events/ws":
+		return true
+	}
+	return strings.HasPrefix(p, "/admin/")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"eventstore/internal/kafka"
+	"eventstore/internal/mw"
+	"eventstore/internal/store"
+
+	"github.com/sony/gobreaker"
+)
+
+// startupGate answers 503 for everything but the probes until started is set.
+func startupGate(started *atomic.Bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !started.Load() && r.URL.Path != "/livez" && r.URL.Path != "/readyz" {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// storeCheck fails when the store's WAL cannot take writes.
+func storeCheck(s *store.LSMStore) func(context.Context) error {
+	return func(context.Context) error { return s.Check() }
+}
+
+// breakerCheck fails while the producer circuit breaker is open.
+func breakerCheck(cb *mw.Breaker) func(context.Context) error {
+	return func(context.Context) error {
+		if cb.State() == gobreaker.StateOpen {
+			return errors.New("circuit breaker open")
+		}
+		return nil
+	}
+}
+
+// consumerCheck fails while the consumer cannot reach its brokers.
+func consumerCheck(kc *kafka.Consumer) func(context.Context) error {
+	return func(context.Context) error { return kc.Check() }
+}
+
+// relayCheck fails while messages wait in the outbox spool or committed
+// events wait behind a failure to publish or spool them.
+func relayCheck(r *relay) func(context.Context) error {
+	return func(context.Context) error { return r.Check() }
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"eventstore/internal/kafka"
+	"eventstore/internal/schema"
+	"eventstore/internal/store"
+)
+
+// kafkaIngest stores events consumed from topic. Events failing their schema
+// are dead-lettered rather than retried.
+func kafkaIngest(lsm *store.LSMStore, schemas *schema.Registry) func(context.Context, store.Event) error {
+	return func(ctx context.Context, e store.Event) error {
+		if err := schemas.Validate(e); err != nil {
+			return kafka.Permanent(err)
+		}
+		return lsm.Put(ctx, e)
+	}
+}
+
+// kafkaReject records the messages the consumer of topic dead-letters in
+// the local dead-letter log.
+func kafkaReject(dl *schema.DeadLetters, topic string) func([]byte, error) {
+	return func(payload []byte, err error) {
+		entry := schema.DeadLetter{Source: "kafka:" + topic, Error: err.Error(), Payload: payload}
+		var rejected *schema.RejectedError
+		if errors.As(err, &rejected) {
+			entry.Details = rejected.Errors
+		}
+		if aerr := dl.Append(entry); aerr != nil {
+			slog.Error("dead-letter append failed", "topic", topic, "err", aerr)
+		}
+	}
+}
@@ -2,121 +2,328 @@ package main
 
 import (
 	"context"
+	"errors"
 	"eventstore/internal/api"
+	"eventstore/internal/audit"
+	"eventstore/internal/config"
+	"eventstore/internal/dedupe"
+	"eventstore/internal/health"
 	"eventstore/internal/kafka"
+	"eventstore/internal/logging"
+	"eventstore/internal/metrics"
 	"eventstore/internal/mw"
+	"eventstore/internal/ns"
+	"eventstore/internal/outbox"
+	"eventstore/internal/schema"
 	"eventstore/internal/store"
+	"eventstore/internal/trace"
 	"fmt"
-	"log"
+	"github.com/sony/gobreaker"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
 func main() {
-	// Config via env (with sane defaults)
-	addr := env("HTTP_ADDR", ":8080")
-	dataDir := env("DATA_DIR", "./data")
-	memLimit := envInt("MEMTABLE_MAX_ITEMS", 50000)
-	kafkaBrokers := env("KAFKA_BROKERS", "localhost:9092")
-	kafkaTopic := env("KAFKA_TOPIC", "events")
-	consumeTopic := env("KAFKA_CONSUME_TOPIC", "events-in") // separate input topic
-	kafkaEnabled := env("KAFKA_ENABLED", "true") == "true"
+	cfg, cfgFile, overrides := loadConfig()
+
+	// Structured logs; the level follows config reloads
+	logLevel := new(slog.LevelVar)
+	lv, _ := logging.ParseLevel(cfg.Log.Level) // validated by loadConfig
+	logLevel.Set(lv)
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	if cfgFile != "" {
+		slog.Info("config loaded", "file", cfgFile)
+	}
 
 	// Ensure data dir
-	if err := os.MkdirAll(dataDir, 0o755); err != nil {
-		log.Fatalf("mkdir data: %v", err)
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		fatal("create data dir failed", err)
+	}
+
+	// Span export (off unless trace.exporter is set)
+	if err := setupTracing(cfg.Trace); err != nil {
+		fatal("tracing setup failed", err)
+	}
+	defer trace.Shutdown()
+
+	// API keys / JWT auth (off unless configured)
+	auth, err := newAuth(cfg.Auth)
+	if err != nil {
+		fatal("auth setup failed", err)
+	}
+
+	// Start listening before recovery so probes can answer while the WAL
+	// replays; until startup completes everything else gets 503.
+	hc := health.New()
+	var started atomic.Bool
+	hc.Register("startup", health.Ready, func(context.Context) error {
+		if !started.Load() {
+			return errors.New("starting")
+		}
+		return nil
+	})
+	hc.Register("disk", health.Ready, health.MinFreeDisk(cfg.DataDir, uint64(cfg.Health.MinFreeMB)<<20))
+	mux := http.NewServeMux()
+	mux.Handle("GET /livez", hc.LiveHandler())
+	mux.Handle("GET /readyz", hc.ReadyHandler())
+
+	// Requests in flight, across all keyspaces, are bounded by a limit that
+	// adapts to latency so a stalled store sheds load instead of queueing.
+	app := startupGate(&started, mux)
+	var shed *mw.ConcurrencyLimiter
+	if cfg.Concurrency.Enabled {
+		shed = mw.NewConcurrencyLimiter(concurrencySettings(cfg.Concurrency), unshed)
+		app = shed.Wrap(app)
 	}
 
+	// every request gets an ID and one access log line
+	srv := &http.Server{Addr: cfg.HTTPAddr, Handler: mw.RequestID(mw.AccessLog(app))}
+	certs, err := newTLS(cfg.TLS)
+	if err != nil {
+		fatal("tls setup failed", err)
+	}
+	go func() {
+		var err error
+		if certs != nil {
+			srv.TLSConfig = certs.Config()
+			slog.Info("listening", "addr", cfg.HTTPAddr, "tls", true)
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("listening", "addr", cfg.HTTPAddr, "tls", false)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("http server failed", err)
+		}
+	}()
+
 	// Create store (LSM-ish)
 	lsm, err := store.NewLSMStore(store.Options{
-		DataDir:          dataDir,
-		MemtableMaxItems: memLimit,
+		DataDir:          cfg.DataDir,
+		MemtableMaxItems: cfg.MemtableMaxItems,
+		Retention:        time.Duration(cfg.Retention),
 	})
 	if err != nil {
-		log.Fatalf("store init: %v", err)
+		fatal("store init failed", err)
 	}
 	defer lsm.Close()
+	hc.Register("store", health.Ready, storeCheck(lsm))
+
+	if err := registerProjections(lsm); err != nil {
+		fatal("projections failed", err)
+	}
+
+	// Schema registry and dead-letter log live next to the data they guard.
+	schemas, err := schema.Open(cfg.DataDir)
+	if err != nil {
+		fatal("schema registry failed", err)
+	}
+	deadLetters := schema.OpenDeadLetters(cfg.DataDir)
 
-	// Kafka (optional but enabled by default)
+	// Kafka (optional but enabled by default). Committed events are
+	// published by a relay tailing the WAL, not by the handlers.
 	var kp *kafka.Producer
-	var kc *kafka.Consumer
-	if kafkaEnabled {
+	cb := mw.NewBreaker("kafka-producer", breakerSettings(cfg.Breaker))
+	if cfg.Kafka.Enabled {
 		kp, err = kafka.NewProducer(kafka.ProducerConfig{
-			BrokersCSV: kafkaBrokers,
-			Topic:      kafkaTopic,
+			BrokersCSV: cfg.Kafka.Brokers,
+			Topic:      cfg.Kafka.Topic,
 		})
 		if err != nil {
-			log.Printf("kafka producer init failed (will continue without publish): %v", err)
+			slog.Warn("kafka producer init failed, continuing without publish", "topic", cfg.Kafka.Topic, "err", err)
 		}
+		hc.Register("kafka_producer", health.Degraded, breakerCheck(cb))
+	}
+	rel, err := startRelay(lsm, cfg.Kafka.Topic, cb, kp)
+	if err != nil {
+		fatal("outbox relay failed", err)
+	}
+	if rel != nil {
+		hc.Register("kafka_outbox", health.Degraded, relayCheck(rel))
+	}
 
-		// Circuit breaker wraps producer Publish()
-		cb := mw.NewBreaker("kafka-producer")
-		publish := func(ctx context.Context, b []byte) error {
-			if kp == nil {
-				return nil
-			}
-			_, err := cb.Execute(func() (any, error) {
-				return nil, kp.Publish(ctx, b)
-			})
-			return err
-		}
+	// Idempotency-Key / event id dedupe window, shared by HTTP and Kafka ingest
+	dedupeWindow := time.Duration(cfg.Idempotency.Window)
+	idem, err := dedupe.Open(filepath.Join(cfg.DataDir, "idempotency.log"), cfg.Idempotency.MaxKeys, dedupeWindow)
+	if err != nil {
+		fatal("idempotency index failed", err)
+	}
+	defer idem.Close()
+
+	// Namespaces: each gets its own store, rate limiter and Kafka topics.
+	nsrt := &namespaceRuntime{
+		auth:          auth,
+		health:        hc,
+		kafkaEnabled:  cfg.Kafka.Enabled,
+		brokers:       cfg.Kafka.Brokers,
+		maxAttempts:   cfg.Kafka.MaxAttempts,
+		retryBackoff:  time.Duration(cfg.Kafka.RetryBackoff),
+		fallback:      kp,
+		fallbackTopic: cfg.Kafka.Topic,
+		dedupeMax:     cfg.Idempotency.MaxKeys,
+		dedupeWindow:  dedupeWindow,
+		limitKey:      rateLimitKey(cfg.RateLimit.Key),
+		limits:        cfg.RateLimit,
+		breakers:      cfg.Breaker,
+		origins:       cfg.WebSocket.AllowedOrigins,
+		spaces:        map[string]*nsResources{},
+	}
+	var retention string
+	if cfg.Retention > 0 {
+		retention = time.Duration(cfg.Retention).String()
+	}
+	reg, err := ns.Open(cfg.DataDir, ns.Settings{
+		MemtableMaxItems: cfg.MemtableMaxItems,
+		Retention:        retention,
+		RateLimitRPS:     cfg.RateLimit.RPS,
+		RateLimitBurst:   cfg.RateLimit.Burst,
+	}, ns.Hooks{Opened: nsrt.opened, Closing: nsrt.closing})
+	if err != nil {
+		fatal("namespaces failed", err)
+	}
+	defer reg.Close()
 
-		// Attach HTTP with publish hook
-		handler := api.NewHTTP(lsm, publish)
+	current := newLiveConfig(cfg)
 
-		// Rate limiter (100 rps, burst 200)
-		rl := mw.NewRateLimiter(100, 200)
-		mux := rl.Wrap(handler)
+	// Attach HTTP
+	handler := api.NewHTTP(lsm).
+		WithSchemas(schemas, deadLetters).
+		WithDedupe(idem).
+		WithAdmin(audit.Open(cfg.DataDir), func() any { return current.get().Redacted() }).
+		WithOrigins(cfg.WebSocket.AllowedOrigins)
 
-		srv := &http.Server{Addr: addr, Handler: mux}
-		go func() {
-			log.Printf("HTTP listening on %s", addr)
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("http: %v", err)
+	// Rate limiter for the default keyspace, per client; namespaces are
+	// limited by their own settings.
+	rl := mw.NewRateLimiter(rateLimitSettings(cfg.RateLimit), rateLimitKey(cfg.RateLimit.Key), handler.Route)
+
+	// SIGHUP reloads the config file (safe settings only) and auth keys
+	go reloadOnSIGHUP(func() {
+		next, err := config.Load(cfgFile, overrides)
+		if err != nil {
+			slog.Error("config reload failed, keeping the running config", "err", err)
+		} else if applied := current.apply(next); applied != nil {
+			lv, _ := logging.ParseLevel(applied.Log.Level)
+			logLevel.Set(lv)
+			rl.Update(rateLimitSettings(applied.RateLimit))
+			nsrt.setRateLimit(applied.RateLimit)
+			cb.Update(breakerSettings(applied.Breaker))
+			nsrt.setBreaker(applied.Breaker)
+			if shed != nil {
+				shed.Update(concurrencySettings(applied.Concurrency))
 			}
-		}()
+			lsm.SetRetention(time.Duration(applied.Retention))
+		}
+		if auth != nil {
+			if err := auth.Reload(); err != nil {
+				slog.Error("auth reload failed", "err", err)
+			}
+		}
+	})
+
+	nsHTTP := api.NewNamespaces(reg, nsrt.handler)
+	mux.Handle("/ns/", protect(auth, nsHTTP)) // observed per namespace
+	mux.Handle("/namespaces", observe(protect(auth, nsHTTP), nsHTTP.Route))
+	mux.Handle("/namespaces/", observe(protect(auth, nsHTTP), nsHTTP.Route))
+	mux.Handle("GET /metrics", protect(auth, metrics.Handler()))
+	mux.Handle("/", observe(protect(auth, rl.Wrap(handler)), handler.Route))
 
-		// Kafka consumer to ingest external events
+	// Kafka consumer to ingest external events
+	var kc *kafka.Consumer
+	if cfg.Kafka.Enabled {
 		kc, err = kafka.NewConsumer(kafka.ConsumerConfig{
-			BrokersCSV: kafkaBrokers,
-			Topic:      consumeTopic,
-			GroupID:    "eventstore-consumers",
+			BrokersCSV:      cfg.Kafka.Brokers,
+			Topic:           cfg.Kafka.ConsumeTopic,
+			GroupID:         "eventstore-consumers",
+			Reject:          kafkaReject(deadLetters, cfg.Kafka.ConsumeTopic),
+			DeadLetterTopic: cfg.Kafka.DeadLetterTopic,
+			MaxAttempts:     cfg.Kafka.MaxAttempts,
+			Backoff:         time.Duration(cfg.Kafka.RetryBackoff),
+			Dedupe:          idem,
 		})
 		if err != nil {
-			log.Printf("kafka consumer init failed (continuing): %v", err)
+			slog.Warn("kafka consumer init failed, continuing", "topic", cfg.Kafka.ConsumeTopic, "err", err)
 		} else {
+			hc.Register("kafka_consumer", health.Degraded, consumerCheck(kc))
 			go func() {
-				log.Printf("Kafka consumer started: topic=%s", consumeTopic)
-				kc.Consume(func(e store.Event) error {
-					// idempotent: Put is upsert by (key, ts)
-					return lsm.Put(context.Background(), e)
-				})
+				slog.Info("kafka consumer started", "topic", cfg.Kafka.ConsumeTopic)
+				// redeliveries carrying an id or Idempotency-Key header are dropped by idem
+				kc.Consume(kafkaIngest(lsm, schemas))
 			}()
 		}
-
-		// graceful shutdown
-		waitForShutdown(srv, kp, kc, lsm)
-		return
 	}
+	started.Store(true)
+	slog.Info("ready")
 
-	// If Kafka disabled, just run HTTP with rate limiter and no publish hook.
-	handler := api.NewHTTP(lsm, nil)
-	rl := mw.NewRateLimiter(100, 200)
-	mux := rl.Wrap(handler)
+	// graceful shutdown
+	waitForShutdown(srv, rel, kp, kc, lsm)
+}
 
-	srv := &http.Server{Addr: addr, Handler: mux}
-	go func() {
-		log.Printf("HTTP listening on %s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("http: %v", err)
+// startRelay publishes s's committed events through kp, guarded by cb,
+// until the returned relay is stopped. What cannot be sent is spooled to the
+// outbox in s's data dir and relayed once the breaker closes. Without a
+// producer nothing is relayed and the store stops keeping its WAL for it.
+func startRelay(s *store.LSMStore, topic string, cb *mw.Breaker, kp *kafka.Producer) (*relay, error) {
+	if kp == nil {
+		return nil, s.ReleaseWAL()
+	}
+	send := func(ctx context.Context, recs []outbox.Record) error {
+		msgs := make([]kafka.Message, len(recs))
+		for i, r := range recs {
+			msgs[i] = kafka.Message{Value: r.Payload, Traceparent: r.Trace}
 		}
+		_, err := cb.Execute(func() (any, error) {
+			return nil, kp.PublishBatch(ctx, msgs)
+		})
+		return err
+	}
+	ob, err := outbox.Open(s.DataDir(), topic)
+	if err != nil {
+		return nil, err
+	}
+	r, err := outbox.New(s, ob, func() bool { return cb.State() != gobreaker.StateOpen }, send)
+	if err != nil {
+		ob.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
 	}()
-	waitForShutdown(srv, nil, nil, lsm)
+	return &relay{Relay: r, outbox: ob, cancel: cancel, done: done}, nil
+}
+
+// relay is a running outbox.Relay and its spool.
+type relay struct {
+	*outbox.Relay
+	outbox *outbox.Outbox
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
-func waitForShutdown(srv *http.Server, kp *kafka.Producer, kc *kafka.Consumer, lsm *store.LSMStore) {
+// stop ends the relay, waits for it and closes the spool; a nil relay is a
+// no-op.
+func (r *relay) stop() {
+	if r == nil {
+		return
+	}
+	r.cancel()
+	<-r.done
+	_ = r.outbox.Close()
+}
+
+func waitForShutdown(srv *http.Server, rel *relay, kp *kafka.Producer, kc *kafka.Consumer, lsm *store.LSMStore) {
 	// OS signals
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
@@ -128,42 +335,43 @@ func waitForShutdown(srv *http.Server, kp *kafka.Producer, kc *kafka.Consumer, l
 	if kc != nil {
 		_ = kc.Close()
 	}
+	rel.stop()
 	if kp != nil {
 		_ = kp.Close()
 	}
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("shutdown http: %v", err)
+		slog.Error("http shutdown failed", "err", err)
 	}
 	_ = lsm.Close()
-	log.Println("bye")
+	slog.Info("bye")
 }
 
-func env(k, def string) string {
-	if v := os.Getenv(k); v != "" {
-		return v
-	}
-	return def
+// fatal logs a startup failure and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
-func envInt(k string, def int) int {
-	if v := os.Getenv(k); v != "" {
-		var n int
-		_, err := fmtSscanf(v, "%d", &n)
-		if err == nil {
-			return n
-		}
-	}
-	return def
+// observe adds metrics and a server span to h; route names the matched pattern.
+func observe(h http.Handler, route func(*http.Request) string) http.Handler {
+	return mw.Instrument(mw.Trace(h, route), route)
 }
 
-// tiny wrapper avoiding importing fmt into main for just Sscanf
-func fmtSscanf(s, format string, a ...any) (int, error) {
-	return fmt_sscanf(s, format, a...)
-}
-
-// --- minimal sscanf helper ---
-
-func fmt_sscanf(s, format string, a ...any) (int, error) {
-	return fmt.Sscanf(s, format, a...)
+// setupTracing picks the span exporter: stdout, or otlp-file writing
+// OTLP/JSON to t.File.
+func setupTracing(t config.Trace) error {
+	switch t.Exporter {
+	case "":
+		return nil
+	case "stdout":
+		trace.SetExporter(trace.NewStdoutExporter(os.Stdout))
+	case "otlp-file":
+		e, err := trace.NewOTLPFileExporter(t.File, t.ServiceName)
+		if err != nil {
+			return err
+		}
+		trace.SetExporter(e)
+	}
+	return nil
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"eventstore/internal/api"
+	"eventstore/internal/audit"
+	"eventstore/internal/config"
+	"eventstore/internal/dedupe"
+	"eventstore/internal/health"
+	"eventstore/internal/kafka"
+	"eventstore/internal/mw"
+	"eventstore/internal/ns"
+	"eventstore/internal/schema"
+)
+
+// nsResources is everything a namespace owns besides its store.
+type nsResources struct {
+	producer    *kafka.Producer
+	consumer    *kafka.Consumer
+	schemas     *schema.Registry
+	deadLetters *schema.DeadLetters
+	dedupe      *dedupe.Index
+	relay       *relay      // nil without Kafka
+	breaker     *mw.Breaker // guards this namespace's publishes only
+	limiter     *mw.RateLimiter
+	settings    ns.Settings
+}
+
+// namespaceRuntime attaches per-namespace resources (Kafka producer and
+// consumer, schema registry, dedupe index) as namespaces open and close, and
+// builds the per-namespace HTTP handlers.
+type namespaceRuntime struct {
+	auth          *mw.Auth // may be nil (auth disabled)
+	health        *health.Registry
+	kafkaEnabled  bool
+	brokers       string
+	maxAttempts   int             // per consumed message
+	retryBackoff  time.Duration   // between attempts, doubled each time
+	fallback      *kafka.Producer // default topic, used when a namespace has no produce topic
+	fallbackTopic string
+	dedupeMax     int
+	dedupeWindow  time.Duration
+	limitKey      mw.KeyFunc
+	origins       []string // WebSocket origins
+
+	mu       sync.Mutex
+	limits   config.RateLimit // costs and client cap; rates come from the namespace
+	breakers config.Breaker   // settings for each namespace's own breaker
+	spaces   map[string]*nsResources
+}
+
+// opened sets up a namespace's resources. Its health checks and its entry
+// in rt.spaces are added last, once nothing can fail, so a namespace that
+// fails to open leaves no trace.
+func (rt *namespaceRuntime) opened(n *ns.Namespace) error {
+	if err := registerProjections(n.Store); err != nil {
+		return err
+	}
+	name, dir := n.Settings.Name, n.Store.DataDir()
+	schemas, err := schema.Open(dir)
+	if err != nil {
+		return err
+	}
+	idx, err := dedupe.Open(filepath.Join(dir, "idempotency.log"), rt.dedupeMax, rt.dedupeWindow)
+	if err != nil {
+		return err
+	}
+	res := &nsResources{schemas: schemas, deadLetters: schema.OpenDeadLetters(dir), dedupe: idx}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if !rt.kafkaEnabled {
+		if err := n.Store.ReleaseWAL(); err != nil {
+			_ = idx.Close()
+			return err
+		}
+		rt.addLocked(n, res)
+		return nil
+	}
+	kp, topic := rt.fallback, rt.fallbackTopic
+	if t := n.Settings.ProduceTopic; t != "" {
+		p, err := kafka.NewProducer(kafka.ProducerConfig{BrokersCSV: rt.brokers, Topic: t})
+		if err != nil {
+			slog.Warn("kafka producer init failed, continuing without publish", "namespace", name, "topic", t, "err", err)
+		}
+		kp, topic, res.producer = p, t, p
+	}
+	// A namespace whose topic keeps failing must not open the breaker for
+	// the others, even those sharing the default producer.
+	res.breaker = mw.NewBreaker("kafka-producer/"+name, breakerSettings(rt.breakers))
+	rel, err := startRelay(n.Store, topic, res.breaker, kp)
+	if err != nil {
+		slog.Warn("outbox relay failed, continuing without publish", "namespace", name, "topic", topic, "err", err)
+	}
+	res.relay = rel
+	if t := n.Settings.ConsumeTopic; t != "" {
+		kc, err := kafka.NewConsumer(kafka.ConsumerConfig{
+			BrokersCSV:      rt.brokers,
+			Topic:           t,
+			GroupID:         "eventstore-consumers-" + name,
+			Reject:          kafkaReject(res.deadLetters, t),
+			DeadLetterTopic: n.Settings.DeadLetterTopic,
+			MaxAttempts:     rt.maxAttempts,
+			Backoff:         rt.retryBackoff,
+			Dedupe:          idx,
+		})
+		if err != nil {
+			slog.Warn("kafka consumer init failed, continuing", "namespace", name, "topic", t, "err", err)
+		} else {
+			res.consumer = kc
+			go func() {
+				slog.Info("kafka consumer started", "namespace", name, "topic", t)
+				kc.Consume(kafkaIngest(n.Store, schemas))
+			}()
+		}
+	}
+	rt.addLocked(n, res)
+	return nil
+}
+
+// addLocked publishes an opened namespace's resources and health checks.
+// rt.mu must be held.
+func (rt *namespaceRuntime) addLocked(n *ns.Namespace, res *nsResources) {
+	name := n.Settings.Name
+	rt.spaces[name] = res
+	rt.health.Register("ns/"+name+"/store", health.Ready, storeCheck(n.Store))
+	if res.relay != nil {
+		rt.health.Register("ns/"+name+"/kafka_producer", health.Degraded, breakerCheck(res.breaker))
+		rt.health.Register("ns/"+name+"/kafka_outbox", health.Degraded, relayCheck(res.relay))
+	}
+	if res.consumer != nil {
+		rt.health.Register("ns/"+name+"/kafka_consumer", health.Degraded, consumerCheck(res.consumer))
+	}
+}
+
+func (rt *namespaceRuntime) closing(n *ns.Namespace) {
+	rt.mu.Lock()
+	res := rt.spaces[n.Settings.Name]
+	delete(rt.spaces, n.Settings.Name)
+	rt.mu.Unlock()
+	rt.health.Remove("ns/" + n.Settings.Name + "/store")
+	rt.health.Remove("ns/" + n.Settings.Name + "/kafka_consumer")
+	rt.health.Remove("ns/" + n.Settings.Name + "/kafka_producer")
+	rt.health.Remove("ns/" + n.Settings.Name + "/kafka_outbox")
+	if res == nil {
+		return
+	}
+	if res.consumer != nil {
+		_ = res.consumer.Close()
+	}
+	res.relay.stop()
+	if res.producer != nil {
+		_ = res.producer.Close()
+	}
+	_ = res.dedupe.Close()
+}
+
+// handler is the namespace's event API behind auth and its own per-client
+// rate limiter.
+func (rt *namespaceRuntime) handler(n *ns.Namespace) http.Handler {
+	rt.mu.Lock()
+	res := rt.spaces[n.Settings.Name]
+	rt.mu.Unlock()
+
+	h := api.NewHTTP(n.Store).
+		WithSchemas(res.schemas, res.deadLetters).
+		WithDedupe(res.dedupe).
+		WithAdmin(audit.Open(n.Store.DataDir()), func() any { return n.Settings }).
+		WithOrigins(rt.origins)
+
+	rt.mu.Lock()
+	res.settings = n.Settings
+	res.limiter = mw.NewRateLimiter(rt.limitSettings(n.Settings), rt.limitKey, h.Route)
+	rt.mu.Unlock()
+	return observe(protect(rt.auth, res.limiter.Wrap(h)), h.Route)
+}
+
+// limitSettings is the namespace's rate limit, applied to reads and writes
+// alike, with the server's route costs and client cap. rt.mu must be held.
+func (rt *namespaceRuntime) limitSettings(s ns.Settings) mw.RateLimitSettings {
+	return mw.RateLimitSettings{
+		ReadRPS:    s.RateLimitRPS,
+		ReadBurst:  s.RateLimitBurst,
+		Costs:      rt.limits.RouteCosts,
+		MaxClients: rt.limits.MaxClients,
+	}
+}
+
+// setRateLimit applies reloaded route costs and client cap to every open
+// namespace.
+func (rt *namespaceRuntime) setRateLimit(c config.RateLimit) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.limits = c
+	for _, res := range rt.spaces {
+		if res.limiter != nil {
+			res.limiter.Update(rt.limitSettings(res.settings))
+		}
+	}
+}
+
+// setBreaker applies reloaded breaker settings to every open namespace's
+// breaker.
+func (rt *namespaceRuntime) setBreaker(c config.Breaker) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.breakers = c
+	for _, res := range rt.spaces {
+		if res.breaker != nil {
+			res.breaker.Update(breakerSettings(c))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"eventstore/internal/health"
+	"eventstore/internal/ns"
+)
+
+// newTestRuntime opens a namespace registry in a temp dir wired to a runtime
+// publishing to brokers nobody listens on.
+func newTestRuntime(t *testing.T, dir string) (*namespaceRuntime, *ns.Registry) {
+	t.Helper()
+	rt := &namespaceRuntime{
+		health:       health.New(),
+		kafkaEnabled: true,
+		brokers:      "127.0.0.1:1",
+		spaces:       map[string]*nsResources{},
+	}
+	reg, err := ns.Open(dir, ns.Settings{MemtableMaxItems: 100}, ns.Hooks{Opened: rt.opened, Closing: rt.closing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reg.Close() })
+	return rt, reg
+}
+
+func checks(rt *namespaceRuntime) map[string]bool {
+	out := map[string]bool{}
+	for name := range rt.health.Run(context.Background(), health.Degraded).Checks {
+		out[name] = true
+	}
+	return out
+}
+
+func TestEachNamespaceHasItsOwnBreaker(t *testing.T) {
+	rt, reg := newTestRuntime(t, t.TempDir())
+	for _, st := range []ns.Settings{{Name: "a", ProduceTopic: "a-events"}, {Name: "b", ProduceTopic: "b-events"}} {
+		if _, err := reg.Create(st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rt.mu.Lock()
+	a, b := rt.spaces["a"].breaker, rt.spaces["b"].breaker
+	rt.mu.Unlock()
+	if a == nil || b == nil || a == b {
+		t.Fatalf("breakers a=%p b=%p", a, b)
+	}
+	if c := checks(rt); !c["ns/a/kafka_producer"] || !c["ns/b/kafka_producer"] {
+		t.Fatalf("checks %v", c)
+	}
+
+	if err := reg.Drop("a"); err != nil {
+		t.Fatal(err)
+	}
+	if c := checks(rt); c["ns/a/kafka_producer"] || !c["ns/b/kafka_producer"] {
+		t.Fatalf("checks after drop %v", c)
+	}
+}
+
+// A namespace that fails to open must leave no health checks or resources
+// behind, and open cleanly once the cause is gone.
+func TestFailedOpenLeavesNoTrace(t *testing.T) {
+	dir := t.TempDir()
+	rt, reg := newTestRuntime(t, dir)
+	blocker := filepath.Join(dir, "ns", "a", "idempotency.log", "x")
+	if err := os.MkdirAll(blocker, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Create(ns.Settings{Name: "a", ProduceTopic: "a-events"}); err == nil {
+		t.Fatal("opened with an unreadable dedupe log")
+	}
+	rt.mu.Lock()
+	_, ok := rt.spaces["a"]
+	rt.mu.Unlock()
+	if c := checks(rt); ok || len(c) != 0 {
+		t.Fatalf("left behind: space %v, checks %v", ok, c)
+	}
+
+	if err := os.RemoveAll(filepath.Dir(blocker)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Create(ns.Settings{Name: "a", ProduceTopic: "a-events"}); err != nil {
+		t.Fatal(err)
+	}
+	if c := checks(rt); !c["ns/a/store"] || !c["ns/a/kafka_outbox"] {
+		t.Fatalf("checks %v", c)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+
+	"eventstore/internal/store"
+)
+
+// registerProjections installs the built-in read models served under /projections.
+func registerProjections(lsm *store.LSMStore) error {
+	// event_types: number of events per value "type" field
+	return lsm.RegisterProjection(store.Projection{
+		Name:    "event_types",
+		Initial: json.RawMessage(`{}`),
+		Fold: func(state json.RawMessage, e store.Event) (json.RawMessage, error) {
+			counts := map[string]int64{}
+			if err := json.Unmarshal(state, &counts); err != nil {
+				return nil, err
+			}
+			var v struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(e.Value, &v)
+			if v.Type == "" {
+				v.Type = "unknown"
+			}
+			counts[v.Type]++
+			return json.Marshal(counts)
+		},
+	})
+}
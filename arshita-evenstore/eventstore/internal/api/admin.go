@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"eventstore/internal/audit"
+	"eventstore/internal/logging"
+	"eventstore/internal/mw"
+)
+
+// WithAdmin mounts the operator endpoints for this handler's store. Every
+// call except reading the trail itself is recorded in trail.
+//
+//	POST /admin/flush
+//	POST /admin/compact
+//	GET  /admin/segments
+//	GET  /admin/stats           memtable, WAL and segment counts
+//	GET  /admin/readonly
+//	PUT  /admin/readonly        {"read_only": true}
+//	GET  /admin/config          config(), the effective runtime settings
+//	GET  /admin/audit?limit=
+func (h *HTTP) WithAdmin(trail *audit.Log, config func() any) *HTTP {
+	h.audit = trail
+	h.config = config
+	h.mux.HandleFunc("POST /admin/flush", h.adminFlush)
+	h.mux.HandleFunc("POST /admin/compact", h.adminCompact)
+	h.mux.HandleFunc("GET /admin/segments", h.adminSegments)
+	h.mux.HandleFunc("GET /admin/stats", h.adminStats)
+	h.mux.HandleFunc("GET /admin/readonly", h.adminReadOnly)
+	h.mux.HandleFunc("PUT /admin/readonly", h.adminSetReadOnly)
+	h.mux.HandleFunc("GET /admin/config", h.adminConfig)
+	h.mux.HandleFunc("GET /admin/audit", h.adminAudit)
+	return h
+}
+
+// record appends an admin action to the audit trail. A trail that cannot
+// be written is logged but does not fail the action, which already happened.
+func (h *HTTP) record(r *http.Request, action string, params, result any, err error) {
+	e := audit.Entry{Action: action, Params: params, Result: result, RequestID: logging.RequestID(r.Context())}
+	if p, ok := mw.PrincipalFrom(r.Context()); ok {
+		e.Principal = p.Name
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if aerr := h.audit.Append(e); aerr != nil {
+		slog.ErrorContext(r.Context(), "admin audit append failed", "action", action, "err", aerr)
+	}
+}
+
+func (h *HTTP) adminFlush(w http.ResponseWriter, r *http.Request) {
+	err := h.store.Flush(r.Context())
+	h.record(r, "flush", nil, nil, err)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.store.Stats())
+}
+
+func (h *HTTP) adminCompact(w http.ResponseWriter, r *http.Request) {
+	st, err := h.store.Compact(r.Context())
+	h.record(r, "compact", nil, st, err)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *HTTP) adminSegments(w http.ResponseWriter, r *http.Request) {
+	segs := h.store.Segments()
+	h.record(r, "segments", nil, nil, nil)
+	writeJSON(w, http.StatusOK, map[string]any{"segments": segs})
+}
+
+func (h *HTTP) adminStats(w http.ResponseWriter, r *http.Request) {
+	h.record(r, "stats", nil, nil, nil)
+	writeJSON(w, http.StatusOK, h.store.Stats())
+}
+
+func (h *HTTP) adminReadOnly(w http.ResponseWriter, r *http.Request) {
+	h.record(r, "readonly.get", nil, nil, nil)
+	writeJSON(w, http.StatusOK, map[string]bool{"read_only": h.store.ReadOnly()})
+}
+
+func (h *HTTP) adminSetReadOnly(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var in struct {
+		ReadOnly *bool `json:"read_only"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.ReadOnly == nil {
+		http.Error(w, `body must be {"read_only": true|false}`, 400)
+		return
+	}
+	h.store.SetReadOnly(*in.ReadOnly)
+	h.record(r, "readonly.set", map[string]bool{"read_only": *in.ReadOnly}, nil, nil)
+	writeJSON(w, http.StatusOK, map[string]bool{"read_only": *in.ReadOnly})
+}
+
+func (h *HTTP) adminConfig(w http.ResponseWriter, r *http.Request) {
+	h.record(r, "config", nil, nil, nil)
+	writeJSON(w, http.StatusOK, h.config())
+}
+
+func (h *HTTP) adminAudit(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	entries, err := h.audit.Tail(limit)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"entries": entries})
+}
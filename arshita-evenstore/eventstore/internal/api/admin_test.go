@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eventstore/internal/audit"
+	"eventstore/internal/logging"
+	"eventstore/internal/mw"
+	"eventstore/internal/store"
+)
+
+func adminCall(t *testing.T, h *HTTP, method, path, body string, out any) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	ctx := mw.WithPrincipal(req.Context(), &mw.Principal{Name: "ops", Scopes: []string{mw.ScopeAdmin}})
+	req = req.WithContext(logging.WithRequestID(ctx, "req-"+path))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %q: %v", method, path, rec.Body, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminOperations(t *testing.T) {
+	h, s := newTestHTTP(t, 1000)
+	h.WithAdmin(audit.Open(s.DataDir()), func() any { return map[string]string{"log_level": "info"} })
+	putEvent(t, s, "a", 1)
+	putEvent(t, s, "b", 2)
+
+	var st store.Stats
+	if code := adminCall(t, h, "POST", "/admin/flush", "", &st); code != 200 || st.Segments != 1 || st.MemtableItems != 0 {
+		t.Fatalf("flush: %d %+v", code, st)
+	}
+	putEvent(t, s, "a", 3)
+	adminCall(t, h, "POST", "/admin/flush", "", nil)
+	var cs store.CompactStats
+	if code := adminCall(t, h, "POST", "/admin/compact", "", &cs); code != 200 || cs.SegmentsBefore != 2 || cs.SegmentsAfter != 1 || cs.EventsOut != 2 {
+		t.Fatalf("compact: %d %+v", code, cs)
+	}
+	var segs struct{ Segments []store.SegmentInfo }
+	if adminCall(t, h, "GET", "/admin/segments", "", &segs); len(segs.Segments) != 1 || segs.Segments[0].MinTS != 2 || segs.Segments[0].MaxTS != 3 {
+		t.Fatalf("segments: %+v", segs)
+	}
+
+	if code := adminCall(t, h, "PUT", "/admin/readonly", `{}`, nil); code != 400 {
+		t.Errorf("readonly without a value: %d", code)
+	}
+	var ro map[string]bool
+	if adminCall(t, h, "PUT", "/admin/readonly", `{"read_only":true}`, &ro); !ro["read_only"] || !s.ReadOnly() {
+		t.Fatalf("readonly: %v", ro)
+	}
+	if err := s.Put(context.Background(), store.Event{Key: "c", TS: 4, Value: []byte(`{}`)}); err == nil {
+		t.Error("write accepted while read-only")
+	}
+	var cfg map[string]string
+	if adminCall(t, h, "GET", "/admin/config", "", &cfg); cfg["log_level"] != "info" {
+		t.Errorf("config: %v", cfg)
+	}
+
+	var trail struct{ Entries []audit.Entry }
+	adminCall(t, h, "GET", "/admin/audit?limit=3", "", &trail)
+	var actions []string
+	for _, e := range trail.Entries {
+		actions = append(actions, e.Action)
+		if e.Principal != "ops" || !strings.HasPrefix(e.RequestID, "req-/admin/") {
+			t.Errorf("entry %+v lacks who and which request", e)
+		}
+	}
+	if strings.Join(actions, " ") != "segments readonly.set config" {
+		t.Errorf("last actions: %v", actions)
+	}
+}
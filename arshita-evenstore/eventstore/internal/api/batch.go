@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"eventstore/internal/dedupe"
+	"eventstore/internal/schema"
+	"eventstore/internal/store"
+)
+
+const (
+	maxBatchItems = 10000
+	maxBatchBytes = 32 << 20
+)
+
+type batchResult struct {
+	Index     int    `json:"index"`
+	OK        bool   `json:"ok"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+// postBatch accepts a JSON array or NDJSON of events, validates each one and
+// stores the valid ones with a single WAL write. Responds 201 when every item
+// was stored, 207 when some were rejected and 400 when none were. A batch
+// repeating an id is rejected whole (400), as is one over the size limit
+// (413). A failed write, including the flush after it, is a 5xx as for
+// POST /events.
+func (h *HTTP) postBatch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	raw, err := readBatch(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("batch larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if len(raw) == 0 || len(raw) > maxBatchItems {
+		http.Error(w, "batch must hold 1..10000 events", 400)
+		return
+	}
+
+	results := make([]batchResult, len(raw))
+	ins := make([]eventDTO, len(raw))
+	seenIDs := map[string]int{}
+	for i, b := range raw {
+		results[i].Index = i
+		if err := json.Unmarshal(b, &ins[i]); err != nil {
+			results[i].Error = "invalid json"
+			continue
+		}
+		if id := ins[i].ID; id != "" {
+			if j, dup := seenIDs[id]; dup {
+				http.Error(w, fmt.Sprintf("id %q repeated at items %d and %d", id, j, i), 400)
+				return
+			}
+			seenIDs[id] = i
+		}
+	}
+
+	var (
+		pending []store.Event
+		slots   []int    // results index of each pending event
+		ids     []string // reserved dedupe keys, parallel to pending
+		fps     []string // their payload fingerprints
+	)
+	for i, in := range ins {
+		if results[i].Error != "" {
+			continue
+		}
+		if in.Key == "" || in.TS == 0 || len(in.Value) == 0 {
+			results[i].Error = "key, ts, value required"
+			continue
+		}
+		ev := store.Event{Key: in.Key, TS: in.TS, Value: []byte(in.Value)}
+		if h.schemas != nil {
+			if err := h.schemas.Validate(ev); err != nil {
+				results[i].Error = err.Error()
+				var rejected *schema.RejectedError
+				if errors.As(err, &rejected) {
+					results[i].Details = rejected.Errors
+				}
+				continue
+			}
+		}
+		fp := ""
+		if h.dedupe != nil && in.ID != "" {
+			fp = fingerprint(in)
+			rec, seen, err := h.dedupe.Begin(in.ID)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			if seen {
+				if rec.Fingerprint != "" && rec.Fingerprint != fp {
+					results[i].Error = "id reused with a different payload"
+					continue
+				}
+				results[i].OK, results[i].Duplicate = true, true
+				continue
+			}
+		}
+		pending = append(pending, ev)
+		slots = append(slots, i)
+		ids = append(ids, in.ID)
+		fps = append(fps, fp)
+	}
+
+	var itemErrs []error
+	if len(pending) > 0 {
+		itemErrs, err = h.store.PutBatch(r.Context(), pending)
+		if err != nil {
+			for _, id := range ids {
+				if h.dedupe != nil && id != "" {
+					h.dedupe.Abort(id)
+				}
+			}
+			http.Error(w, err.Error(), putStatus(err))
+			return
+		}
+	}
+
+	for j, slot := range slots {
+		id := ids[j]
+		if itemErrs[j] != nil {
+			results[slot].Error = itemErrs[j].Error()
+			if h.dedupe != nil && id != "" {
+				h.dedupe.Abort(id)
+			}
+			continue
+		}
+		results[slot].OK = true
+		if h.dedupe != nil && id != "" {
+			rec := dedupe.Record{Key: id, Fingerprint: fps[j], Status: http.StatusCreated, Header: map[string]string{"Content-Type": "application/json"}, Body: `{"ok":true}`}
+			if err := h.dedupe.Finish(rec); err != nil {
+				slog.ErrorContext(r.Context(), "idempotency record failed", "idempotency_key", id, "err", err)
+			}
+		}
+	}
+
+	accepted := 0
+	for _, res := range results {
+		if res.OK {
+			accepted++
+		}
+	}
+	status := http.StatusCreated
+	switch {
+	case accepted == 0:
+		status = http.StatusBadRequest
+	case accepted < len(results):
+		status = http.StatusMultiStatus
+	}
+	writeJSON(w, status, map[string]any{
+		"accepted": accepted,
+		"rejected": len(results) - accepted,
+		"results":  results,
+	})
+}
+
+// readBatch splits a JSON array or NDJSON body into raw items.
+func readBatch(r io.Reader) ([]json.RawMessage, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.Peek(1)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c[0] == ' ' || c[0] == '\t' || c[0] == '\r' || c[0] == '\n' {
+			br.ReadByte()
+			continue
+		}
+		if c[0] == '[' {
+			var items []json.RawMessage
+			if err := json.NewDecoder(br).Decode(&items); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					return nil, err
+				}
+				return nil, errors.New("invalid json array")
+			}
+			return items, nil
+		}
+		break
+	}
+
+	// NDJSON: one event per line; a bad line only fails that item
+	var items []json.RawMessage
+	sc := bufio.NewScanner(br)
+	sc.Buffer(make([]byte, 0, 1<<20), maxBatchBytes+1) // let the body limit trip first
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, append(json.RawMessage(nil), line...))
+	}
+	return items, sc.Err()
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func postBatchBody(t *testing.T, h *HTTP, body string) (int, batchResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events:batch", strings.NewReader(body)))
+	var out batchResponse
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, out
+}
+
+type batchResponse struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Results  []batchResult `json:"results"`
+}
+
+func TestBatchPartialAndReplay(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	body := `{"id":"a","key":"k1","ts":1,"value":{"n":1}}
+{"key":"k2","ts":0,"value":{"n":2}}
+not json
+{"id":"b","key":"k3","ts":3,"value":{"n":3}}`
+	code, out := postBatchBody(t, h, body)
+	if code != http.StatusMultiStatus || out.Accepted != 2 || out.Rejected != 2 {
+		t.Fatalf("first batch: %d %+v", code, out)
+	}
+
+	// the same ids again are duplicates; a changed payload under an id is not
+	code, out = postBatchBody(t, h, `[{"id":"a","key":"k1","ts":1,"value":{"n":1}},{"id":"b","key":"k3","ts":3,"value":{"n":30}}]`)
+	if code != http.StatusMultiStatus || !out.Results[0].Duplicate || out.Results[1].OK {
+		t.Fatalf("replayed batch: %d %+v", code, out)
+	}
+}
+
+func TestBatchRejectsWholeBatch(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	code, _ := postBatchBody(t, h, `[{"id":"a","key":"k1","ts":1,"value":{}},{"id":"a","key":"k2","ts":2,"value":{}}]`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("repeated id: %d", code)
+	}
+
+	big := `{"key":"k","ts":1,"value":{"pad":"` + strings.Repeat("x", maxBatchBytes) + `"}}`
+	for _, body := range []string{big, "[" + big + "]"} {
+		if code, _ := postBatchBody(t, h, body); code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("oversized %.1s: %d", body, code)
+		}
+	}
+}
+
+// A flush failing after the WAL write is reported, not dropped, and the ids
+// can be retried.
+func TestBatchFlushError(t *testing.T) {
+	h, s := newTestHTTP(t, 2)
+	sst := filepath.Join(s.DataDir(), "sst")
+	if err := os.RemoveAll(sst); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sst, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	body := `[{"id":"a","key":"k1","ts":1,"value":{}},{"id":"b","key":"k2","ts":2,"value":{}}]`
+	if code, _ := postBatchBody(t, h, body); code != http.StatusInternalServerError {
+		t.Fatalf("flush failure: %d", code)
+	}
+
+	os.Remove(sst)
+	os.Mkdir(sst, 0o755)
+	if code, out := postBatchBody(t, h, body); code != http.StatusCreated || out.Results[0].Duplicate {
+		t.Fatalf("retry: %d %+v", code, out)
+	}
+}
+
+func TestBatchRejectsEmptyAndInvalid(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	for _, body := range []string{``, `[]`, `[{"key":"k"}]`, `not json`} {
+		if code, _ := postBatchBody(t, h, body); code != http.StatusBadRequest {
+			t.Errorf("%q: %d", body, code)
+		}
+	}
+}
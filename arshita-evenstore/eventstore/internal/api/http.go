@@ -1,36 +1,57 @@
 package api
 
 import (
-	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"eventstore/internal/audit"
+	"eventstore/internal/dedupe"
+	"eventstore/internal/schema"
 	"eventstore/internal/store"
 )
 
-type Publisher func(ctx context.Context, payload []byte) error
-
+// HTTP serves the event API for one store. Kafka publication is not done
+// here but by the outbox relay, from the store's WAL.
 type HTTP struct {
-	store     *store.LSMStore
-	publishFn Publisher // may be nil
-	mux       *http.ServeMux
+	store       *store.LSMStore
+	schemas     *schema.Registry    // may be nil (no validation)
+	deadLetters *schema.DeadLetters // may be nil
+	dedupe      *dedupe.Index       // may be nil (no idempotency)
+	audit       *audit.Log          // set by WithAdmin
+	config      func() any          // set by WithAdmin
+	origins     []string            // extra origins allowed to open WebSockets
+	mux         *http.ServeMux
 }
 
-func NewHTTP(s *store.LSMStore, p Publisher) *HTTP {
-	h := &HTTP{store: s, publishFn: p, mux: http.NewServeMux()}
+func NewHTTP(s *store.LSMStore) *HTTP {
+	h := &HTTP{store: s, mux: http.NewServeMux()}
 	h.routes()
 	return h
 }
 
 func (h *HTTP) routes() {
 	h.mux.HandleFunc("POST /events", h.postEvent)
-	h.mux.HandleFunc("GET /events/", h.getByKey) // /events/{key}
-	h.mux.HandleFunc("GET /events", h.replay)    // /events?from=&to=
+	h.mux.HandleFunc("POST /events:batch", h.postBatch) // JSON array or NDJSON
+	h.mux.HandleFunc("GET /events/stream", h.stream)    // SSE live tail
+	h.mux.HandleFunc("GET /events/ws", h.websocket)     // multiplexed subscriptions
+	h.mux.HandleFunc("GET /events/", h.getByKey)        // /events/{key}
+	h.mux.HandleFunc("GET /events", h.replay)           // /events?from=&to=[&prefix=&key=&filter=&order=&limit=&cursor=]
+	h.mux.HandleFunc("GET /projections", h.listProjections)
+	h.mux.HandleFunc("GET /projections/{name}", h.getProjection)
+	h.mux.HandleFunc("POST /projections/{name}/rebuild", h.rebuildProjection)
+	h.mux.HandleFunc("GET /indexes", h.listIndexes)
+	h.mux.HandleFunc("POST /indexes", h.createIndex)
+	h.mux.HandleFunc("POST /indexes/{name}/build", h.buildIndex)
+	h.mux.HandleFunc("DELETE /indexes/{name}", h.dropIndex)
 	h.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
 		io.WriteString(w, "ok")
 	})
@@ -38,10 +59,23 @@ func (h *HTTP) routes() {
 
 func (h *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) { h.mux.ServeHTTP(w, r) }
 
+// Route is the path pattern r is served by, or "" if none matches.
+func (h *HTTP) Route(r *http.Request) string { return route(h.mux, r) }
+
+func route(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if _, p, ok := strings.Cut(pattern, " "); ok {
+		return p // drop the method; it is a label of its own
+	}
+	return pattern
+}
+
 type eventDTO struct {
+	ID    string          `json:"id,omitempty"` // optional; doubles as the idempotency key
 	Key   string          `json:"key"`
 	TS    int64           `json:"ts"`
 	Value json.RawMessage `json:"value"`
+	Seq   uint64          `json:"seq,omitempty"` // set on streamed events only
 }
 
 func (h *HTTP) postEvent(w http.ResponseWriter, r *http.Request) {
@@ -56,24 +90,39 @@ func (h *HTTP) postEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ev := store.Event{Key: in.Key, TS: in.TS, Value: []byte(in.Value)}
-	if err := h.store.Put(r.Context(), ev); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = in.ID
 	}
+	h.idempotent(w, r, key, in, func(w http.ResponseWriter) { h.storeEvent(w, r, in) })
+}
 
-	if h.publishFn != nil {
-		b, _ := json.Marshal(in)
-		if err := h.publishFn(r.Context(), b); err != nil {
-			w.Header().Set("X-Publish-Error", err.Error())
+func (h *HTTP) storeEvent(w http.ResponseWriter, r *http.Request, in eventDTO) {
+	ev := store.Event{Key: in.Key, TS: in.TS, Value: []byte(in.Value)}
+	if h.schemas != nil {
+		if err := h.schemas.Validate(ev); err != nil {
+			writeRejected(w, err)
+			return
 		}
 	}
+	if err := h.store.Put(r.Context(), ev); err != nil {
+		http.Error(w, err.Error(), putStatus(err))
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	io.WriteString(w, `{"ok":true}`)
 }
 
+// putStatus is the HTTP status for a failed store write.
+func putStatus(err error) int {
+	if errors.Is(err, store.ErrReadOnly) {
+		return http.StatusServiceUnavailable
+	}
+	return 500
+}
+
 func (h *HTTP) getByKey(w http.ResponseWriter, r *http.Request) {
 	key := strings.TrimPrefix(r.URL.Path, "/events/")
 	if key == "" {
@@ -96,24 +145,223 @@ func (h *HTTP) getByKey(w http.ResponseWriter, r *http.Request) {
 
 func (h *HTTP) replay(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
-	from, to, err := parseRange(q.Get("from"), q.Get("to"))
+	fs, ts := q.Get("from"), q.Get("to")
+	if q.Get("order") == "desc" {
+		// tail queries may leave the window open on either side
+		if fs == "" {
+			fs = strconv.FormatInt(math.MinInt64, 10)
+		}
+		if ts == "" {
+			ts = strconv.FormatInt(math.MaxInt64, 10)
+		}
+	}
+	from, to, err := parseRange(fs, ts)
 	if err != nil {
 		http.Error(w, "invalid from/to", 400)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/x-ndjson")
-	ch, err := h.store.Replay(r.Context(), from, to)
+	opts := store.ReplayOptions{Filter: parseFilter(q)}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", 400)
+			return
+		}
+		opts.Limit = limit + 1 // one extra tells whether another page exists
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		http.Error(w, "order must be asc or desc", 400)
+		return
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			http.Error(w, "invalid cursor", 400)
+			return
+		}
+		opts.After = &c
+	}
+
+	var ch <-chan store.Event
+	if where := q.Get("where"); where != "" {
+		// where=<field>:<value>, served from the secondary index on field
+		field, value, ok := strings.Cut(where, ":")
+		if !ok {
+			http.Error(w, "where must be field:value", 400)
+			return
+		}
+		def, ok := h.store.FindIndex(field)
+		if !ok {
+			http.Error(w, "no index on "+field, 400)
+			return
+		}
+		ch, err = h.store.Lookup(r.Context(), def.Name, value, from, to, opts)
+	} else {
+		ch, err = h.store.Replay(r.Context(), from, to, opts)
+	}
+	if errors.Is(err, store.ErrInvalidFilter) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if errors.Is(err, store.ErrIndexNotReady) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if limit > 0 {
+		// buffer the page so the next cursor can go out as a header
+		page := make([]store.Event, 0, limit)
+		more := false
+		for ev := range ch {
+			if len(page) == limit {
+				more = true
+				continue
+			}
+			page = append(page, ev)
+		}
+		if more {
+			w.Header().Set("X-Next-Cursor", encodeCursor(page[len(page)-1].Cursor()))
+		}
+		enc := json.NewEncoder(w)
+		for _, ev := range page {
+			_ = enc.Encode(eventDTO{Key: ev.Key, TS: ev.TS, Value: ev.Value})
+		}
+		return
+	}
 	enc := json.NewEncoder(w)
 	for ev := range ch {
 		_ = enc.Encode(eventDTO{Key: ev.Key, TS: ev.TS, Value: ev.Value})
 	}
 }
 
+// Cursors are opaque to clients: base64 of "ts:seq:key".
+func encodeCursor(c store.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%s", c.TS, c.Seq, c.Key)))
+}
+
+func decodeCursor(s string) (store.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return store.Cursor{}, err
+	}
+	parts := strings.SplitN(string(b), ":", 3)
+	if len(parts) != 3 {
+		return store.Cursor{}, errors.New("malformed cursor")
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return store.Cursor{}, err
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return store.Cursor{}, err
+	}
+	return store.Cursor{TS: ts, Key: parts[2], Seq: seq}, nil
+}
+
+func (h *HTTP) listProjections(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"projections": h.store.Projections()})
+}
+
+func (h *HTTP) getProjection(w http.ResponseWriter, r *http.Request) {
+	st, ok := h.store.Projection(r.PathValue("name"))
+	if !ok {
+		http.Error(w, "not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func (h *HTTP) rebuildProjection(w http.ResponseWriter, r *http.Request) {
+	st, err := h.store.RebuildProjection(r.PathValue("name"))
+	if errors.Is(err, store.ErrUnknownProjection) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func (h *HTTP) listIndexes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"indexes": h.store.Indexes()})
+}
+
+func (h *HTTP) createIndex(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var in struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	def, err := h.store.CreateIndex(in.Name, in.Path)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(def)
+}
+
+func (h *HTTP) buildIndex(w http.ResponseWriter, r *http.Request) {
+	def, err := h.store.BuildIndex(r.Context(), r.PathValue("name"))
+	if errors.Is(err, store.ErrUnknownIndex) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(def)
+}
+
+func (h *HTTP) dropIndex(w http.ResponseWriter, r *http.Request) {
+	err := h.store.DropIndex(r.PathValue("name"))
+	if errors.Is(err, store.ErrUnknownIndex) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseFilter reads the replay filter parameters:
+//
+//	prefix=order-            key prefix
+//	key=order-*              key glob
+//	filter=$.type==Placed    value predicate (repeatable; ==, !=, >, >=, <, <=, or a bare path for exists)
+func parseFilter(q url.Values) store.Filter {
+	f := store.Filter{KeyPrefix: q.Get("prefix"), KeyGlob: q.Get("key")}
+	for _, s := range q["filter"] {
+		f.Where = append(f.Where, store.ParsePredicate(s))
+	}
+	return f
+}
+
 func parseRange(fs, ts string) (int64, int64, error) {
 	if fs == "" || ts == "" {
 		return 0, 0, errors.New("missing")
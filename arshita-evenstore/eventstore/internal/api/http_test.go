@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eventstore/internal/dedupe"
+	"eventstore/internal/store"
+)
+
+func newTestHTTP(t *testing.T, maxItems int) (*HTTP, *store.LSMStore) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := store.NewLSMStore(store.Options{DataDir: dir, MemtableMaxItems: maxItems})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	idx, err := dedupe.Open(filepath.Join(dir, "idempotency.log"), 1000, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	return NewHTTP(s).WithDedupe(idx), s
+}
+
+// getEvents runs GET /events?query and returns the keys and X-Next-Cursor.
+func getEvents(t *testing.T, h *HTTP, query string) (int, []string, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events?"+query, nil))
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil, ""
+	}
+	var keys []string
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		var ev eventDTO
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("%s: %q: %v", query, sc.Text(), err)
+		}
+		keys = append(keys, ev.Key)
+	}
+	return rec.Code, keys, rec.Header().Get("X-Next-Cursor")
+}
+
+func TestReplayCursorPaging(t *testing.T) {
+	h, s := newTestHTTP(t, 1000)
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		putEvent(t, s, k, int64(i/2+1)) // b and c, d and e share a ts
+	}
+	var got []string
+	query := "from=0&to=10&limit=2"
+	for {
+		code, keys, next := getEvents(t, h, query)
+		if code != http.StatusOK {
+			t.Fatalf("%s: %d", query, code)
+		}
+		got = append(got, keys...)
+		if next == "" {
+			break
+		}
+		if len(got) > 5 {
+			t.Fatalf("paging does not end: %v", got)
+		}
+		query = "from=0&to=10&limit=2&cursor=" + next
+	}
+	if fmt.Sprint(got) != "[a b c d e]" {
+		t.Fatalf("pages: %v", got)
+	}
+	if code, _, _ := getEvents(t, h, "from=0&to=10&limit=2&cursor=!!"); code != http.StatusBadRequest {
+		t.Errorf("bad cursor: %d", code)
+	}
+	if code, _, _ := getEvents(t, h, "from=0&to=10&limit=0"); code != http.StatusBadRequest {
+		t.Errorf("limit=0: %d", code)
+	}
+}
+
+func TestReplayRejectsBadFilters(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	for _, f := range []string{"$.a=b", "$.a=~b", "$.a>ten", "$..a"} {
+		if code, _, _ := getEvents(t, h, "from=0&to=10&filter="+url.QueryEscape(f)); code != http.StatusBadRequest {
+			t.Errorf("filter %s: %d", f, code)
+		}
+	}
+}
+
+func TestReplayLatestN(t *testing.T) {
+	h, s := newTestHTTP(t, 4)
+	for i, k := range []string{"a", "b", "c", "d", "e"} {
+		putEvent(t, s, k, int64(i+1)) // the first four are flushed
+	}
+
+	code, keys, next := getEvents(t, h, "order=desc&limit=2")
+	if code != http.StatusOK || fmt.Sprint(keys) != "[e d]" || next == "" {
+		t.Fatalf("latest 2: %d %v %q", code, keys, next)
+	}
+	_, keys, next = getEvents(t, h, "order=desc&limit=2&cursor="+next)
+	if fmt.Sprint(keys) != "[c b]" {
+		t.Fatalf("next page: %v", keys)
+	}
+	_, keys, next = getEvents(t, h, "order=desc&limit=2&cursor="+next)
+	if fmt.Sprint(keys) != "[a]" || next != "" {
+		t.Fatalf("last page: %v %q", keys, next)
+	}
+	if code, _, _ := getEvents(t, h, "order=sideways"); code != http.StatusBadRequest {
+		t.Errorf("bad order: %d", code)
+	}
+}
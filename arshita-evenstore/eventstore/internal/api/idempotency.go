@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"eventstore/internal/dedupe"
+)
+
+// WithDedupe makes POST /events idempotent: a repeated Idempotency-Key header
+// (or event "id" field) gets the original response back instead of a second
+// write.
+func (h *HTTP) WithDedupe(idx *dedupe.Index) *HTTP {
+	h.dedupe = idx
+	return h
+}
+
+// idempotent runs write at most once per key within the dedupe window.
+// Only successful (2xx) outcomes are remembered, so failed requests can be
+// retried with the same key.
+func (h *HTTP) idempotent(w http.ResponseWriter, r *http.Request, key string, in eventDTO, write func(http.ResponseWriter)) {
+	if h.dedupe == nil || key == "" {
+		write(w)
+		return
+	}
+	fp := fingerprint(in)
+	rec, seen, err := h.dedupe.Begin(key)
+	if errors.Is(err, dedupe.ErrInFlight) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if seen {
+		if rec.Fingerprint != "" && rec.Fingerprint != fp {
+			http.Error(w, "Idempotency-Key reused with a different payload", http.StatusUnprocessableEntity)
+			return
+		}
+		for k, v := range rec.Header {
+			w.Header().Set(k, v)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(rec.Status)
+		io.WriteString(w, rec.Body)
+		return
+	}
+
+	cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+	write(cw)
+	if cw.status/100 != 2 {
+		h.dedupe.Abort(key)
+		return
+	}
+	hdr := map[string]string{}
+	for _, k := range []string{"Content-Type"} {
+		if v := w.Header().Get(k); v != "" {
+			hdr[k] = v
+		}
+	}
+	if err := h.dedupe.Finish(dedupe.Record{Key: key, Fingerprint: fp, Status: cw.status, Header: hdr, Body: cw.body.String()}); err != nil {
+		slog.ErrorContext(r.Context(), "idempotency record failed", "idempotency_key", key, "err", err)
+	}
+}
+
+func fingerprint(in eventDTO) string { return dedupe.Fingerprint(in.ID, in.Key, in.TS, in.Value) }
+
+// captureWriter tees the response so it can be stored for replays.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *captureWriter) WriteHeader(code int) {
+	c.status = code
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eventstore/internal/store"
+)
+
+func postEventBody(h *HTTP, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotentPostReplays(t *testing.T) {
+	h, s := newTestHTTP(t, 1000)
+	body := `{"key":"k","ts":1,"value":{"n":1}}`
+	first := postEventBody(h, "req-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first post: %d %s", first.Code, first.Body)
+	}
+	// overwritten since; a replay must not write again
+	if err := s.Put(context.Background(), store.Event{Key: "k", TS: 1, Value: []byte(`{"n":9}`)}); err != nil {
+		t.Fatal(err)
+	}
+	again := postEventBody(h, "req-1", `{"key":"k","ts":1,"value":{"n": 1}}`)
+	if again.Code != first.Code || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay: %d %q %v", again.Code, again.Body, again.Header())
+	}
+	if ct := again.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("replayed Content-Type %q", ct)
+	}
+	if ev, _, _ := s.Get(context.Background(), "k"); string(ev.Value) != `{"n":9}` {
+		t.Errorf("replay wrote again: %s", ev.Value)
+	}
+	if rec := postEventBody(h, "req-1", `{"key":"k","ts":1,"value":{"n":2}}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another payload: %d", rec.Code)
+	}
+}
+
+func TestIdempotentPostUsesEventID(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	body := `{"id":"e1","key":"k","ts":1,"value":{}}`
+	postEventBody(h, "", body)
+	if rec := postEventBody(h, "", body); rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("id field not used as the key: %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestIdempotentPostForgetsFailures(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	if rec := postEventBody(h, "req-1", `{"key":"k","value":{}}`); rec.Code/100 == 2 {
+		t.Fatalf("post without ts: %d", rec.Code)
+	}
+	if rec := postEventBody(h, "req-1", `{"key":"k","ts":1,"value":{}}`); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("retry after failure: %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestIdempotentPostInFlight(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	if _, _, err := h.dedupe.Begin("req-1"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := postEventBody(h, "req-1", `{"key":"k","ts":1,"value":{}}`); rec.Code != http.StatusConflict {
+		t.Fatalf("in-flight key: %d", rec.Code)
+	}
+}
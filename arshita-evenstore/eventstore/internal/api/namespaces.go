@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"eventstore/internal/ns"
+)
+
+// Namespaces serves the namespace admin endpoints and routes /ns/{ns}/... to
+// a per-namespace handler (its own store and rate limiter).
+type Namespaces struct {
+	reg   *ns.Registry
+	build func(*ns.Namespace) http.Handler
+	mux   *http.ServeMux
+
+	mu       sync.Mutex
+	handlers map[string]nsHandler
+}
+
+// nsHandler is a cached handler and the namespace it was built for; a
+// namespace dropped and created again under the same name gets a new one.
+type nsHandler struct {
+	space *ns.Namespace
+	h     http.Handler
+}
+
+func NewNamespaces(reg *ns.Registry, build func(*ns.Namespace) http.Handler) *Namespaces {
+	n := &Namespaces{reg: reg, build: build, mux: http.NewServeMux(), handlers: map[string]nsHandler{}}
+	n.mux.HandleFunc("GET /namespaces", n.list)
+	n.mux.HandleFunc("POST /namespaces", n.create)
+	n.mux.HandleFunc("DELETE /namespaces/{ns}", n.drop)
+	n.mux.HandleFunc("/ns/{ns}/", n.forward)
+	return n
+}
+
+func (n *Namespaces) ServeHTTP(w http.ResponseWriter, r *http.Request) { n.mux.ServeHTTP(w, r) }
+
+// Route is the path pattern r is served by, or "" if none matches.
+func (n *Namespaces) Route(r *http.Request) string { return route(n.mux, r) }
+
+func (n *Namespaces) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"namespaces": n.reg.List()})
+}
+
+func (n *Namespaces) create(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var in ns.Settings
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	created, err := n.reg.Create(in)
+	if errors.Is(err, ns.ErrExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created.Settings)
+}
+
+// drop forgets the namespace handler first so no new request reaches it;
+// Drop then waits for the requests in flight before closing the store.
+func (n *Namespaces) drop(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("ns")
+	n.mu.Lock()
+	delete(n.handlers, name)
+	n.mu.Unlock()
+	err := n.reg.Drop(name)
+	n.mu.Lock()
+	if c, ok := n.handlers[name]; ok && c.space.Context().Err() != nil {
+		delete(n.handlers, name) // rebuilt by a request that raced the drop
+	}
+	n.mu.Unlock()
+	if errors.Is(err, ns.ErrNotFound) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forward strips /ns/{ns} and hands the request to the namespace handler.
+// The request holds a reference to the namespace while it runs and its
+// context is cancelled if the namespace is dropped meanwhile.
+func (n *Namespaces) forward(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("ns")
+	space, release, ok := n.reg.Acquire(name)
+	if !ok {
+		http.Error(w, "unknown namespace", 404)
+		return
+	}
+	defer release()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(space.Context(), cancel)
+	defer stop()
+	http.StripPrefix("/ns/"+name, n.handler(space)).ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (n *Namespaces) handler(space *ns.Namespace) http.Handler {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	name := space.Settings.Name
+	if c, ok := n.handlers[name]; ok && c.space == space {
+		return c.h
+	}
+	h := n.build(space)
+	n.handlers[name] = nsHandler{space: space, h: h}
+	return h
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eventstore/internal/ns"
+)
+
+func TestDropNamespaceEndsStreams(t *testing.T) {
+	reg, err := ns.Open(t.TempDir(), ns.Settings{MemtableMaxItems: 100}, ns.Hooks{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	if _, err := reg.Create(ns.Settings{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewNamespaces(reg, func(n *ns.Namespace) http.Handler { return NewHTTP(n.Store) }))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/ns/a/events/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	if line, _ := br.ReadString('\n'); !strings.HasPrefix(line, "retry:") {
+		t.Fatalf("stream did not start: %q", line)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/namespaces/a", nil)
+	dropped := make(chan *http.Response, 1)
+	go func() {
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		dropped <- r
+	}()
+
+	ended := make(chan struct{})
+	go func() {
+		for {
+			if _, err := br.ReadString('\n'); err != nil {
+				close(ended)
+				return
+			}
+		}
+	}()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after drop")
+	}
+	if r := <-dropped; r == nil || r.StatusCode != http.StatusNoContent {
+		t.Fatalf("drop: %v", r)
+	}
+
+	r, err := http.Get(srv.URL + "/ns/a/events?from=0&to=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusNotFound {
+		t.Fatalf("request after drop: %d", r.StatusCode)
+	}
+}
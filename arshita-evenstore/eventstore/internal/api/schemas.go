@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"eventstore/internal/schema"
+)
+
+// WithSchemas turns on schema validation for POST /events and mounts the
+// registry endpoints:
+//
+//	GET    /schemas
+//	GET    /schemas/{subject}
+//	GET    /schemas/{subject}/versions/{version}
+//	POST   /schemas/{subject}      register a new version
+//	DELETE /schemas/{subject}
+//	GET    /deadletters?limit=
+func (h *HTTP) WithSchemas(reg *schema.Registry, dl *schema.DeadLetters) *HTTP {
+	h.schemas = reg
+	h.deadLetters = dl
+	h.mux.HandleFunc("GET /schemas", h.listSchemas)
+	h.mux.HandleFunc("GET /schemas/{subject}", h.getSchema)
+	h.mux.HandleFunc("GET /schemas/{subject}/versions/{version}", h.getSchemaVersion)
+	h.mux.HandleFunc("POST /schemas/{subject}", h.registerSchema)
+	h.mux.HandleFunc("DELETE /schemas/{subject}", h.deleteSchema)
+	h.mux.HandleFunc("GET /deadletters", h.listDeadLetters)
+	return h
+}
+
+func (h *HTTP) listSchemas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"subjects": h.schemas.Subjects()})
+}
+
+func (h *HTTP) getSchema(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.schemas.Subject(r.PathValue("subject"))
+	if !ok {
+		http.Error(w, "not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func (h *HTTP) getSchemaVersion(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.schemas.Subject(r.PathValue("subject"))
+	v, err := strconv.Atoi(r.PathValue("version"))
+	if !ok || err != nil || v < 1 || v > len(s.Versions) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Versions[v-1])
+}
+
+func (h *HTTP) registerSchema(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var in schema.Registration
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "invalid json", 400)
+		return
+	}
+	v, err := h.schemas.Register(r.PathValue("subject"), in)
+	var incompatible *schema.IncompatibleError
+	switch {
+	case errors.As(err, &incompatible):
+		writeJSON(w, http.StatusConflict, map[string]any{"error": "incompatible schema", "reasons": incompatible.Reasons})
+		return
+	case errors.Is(err, schema.ErrTypeTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, schema.ErrInvalid):
+		http.Error(w, err.Error(), 400)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusCreated, v)
+}
+
+func (h *HTTP) deleteSchema(w http.ResponseWriter, r *http.Request) {
+	err := h.schemas.Delete(r.PathValue("subject"))
+	if errors.Is(err, schema.ErrNotFound) {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTP) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	dls, err := h.deadLetters.Tail(limit)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deadletters": dls})
+}
+
+// writeRejected answers an ingest that failed schema validation with a 422
+// listing every violation.
+func writeRejected(w http.ResponseWriter, err error) {
+	var rejected *schema.RejectedError
+	if !errors.As(err, &rejected) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+		"error":   "schema validation failed",
+		"subject": rejected.Subject,
+		"version": rejected.Version,
+		"errors":  rejected.Errors,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eventstore/internal/store"
+)
+
+const (
+	sseHeartbeat = 15 * time.Second
+	sseBuffer    = 1024 // events a client may lag behind before it is dropped
+	catchUpPage  = 512  // stored events read at a time during catch-up
+)
+
+// stream serves GET /events/stream as Server-Sent Events.
+//
+//	from=<ts>          catch up on stored events with TS >= from, then go live
+//	from_seq=<seq>     catch up on events after seq (Last-Event-ID wins if sent)
+//	prefix=, key=, filter=  same filters as GET /events
+//
+// Catch-up is sent in seq order and live delivery continues from the seq the
+// subscription started at, so nothing is skipped or repeated. Events are
+// plain SSE messages (EventSource.onmessage) whose id is their seq. A client
+// that falls too far behind gets an "error" event and should reconnect with
+// Last-Event-ID.
+func (h *HTTP) stream(w http.ResponseWriter, r *http.Request) {
+	fl, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", 500)
+		return
+	}
+	q := r.URL.Query()
+	f := parseFilter(q)
+
+	var (
+		after   uint64
+		fromTS  int64 = math.MinInt64
+		catchUp bool
+		err     error
+	)
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		after, err = strconv.ParseUint(v, 10, 64)
+		catchUp = true
+	} else if v := q.Get("from_seq"); v != "" {
+		after, err = strconv.ParseUint(v, 10, 64)
+		catchUp = true
+	}
+	if err != nil {
+		http.Error(w, "invalid Last-Event-ID/from_seq", 400)
+		return
+	}
+	if v := q.Get("from"); v != "" {
+		if fromTS, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "invalid from", 400)
+			return
+		}
+		catchUp = true
+	}
+
+	// subscribe before reading history so nothing committed in between is lost
+	sub, err := h.store.Subscribe(f, sseBuffer)
+	if errors.Is(err, store.ErrInvalidFilter) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 2000\n\n")
+	fl.Flush()
+
+	last := after
+	if catchUp {
+		err := h.catchUp(r.Context(), f, fromTS, after, sub.Start, func(e store.Event) error { return writeEvent(w, e) })
+		if err != nil {
+			writeSSE(w, "error", "", []byte(strconv.Quote(err.Error())))
+			return
+		}
+		fl.Flush()
+	}
+	if sub.Start > last {
+		last = sub.Start
+	}
+
+	hb := time.NewTicker(sseHeartbeat)
+	defer hb.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				if err := sub.Err(); err != nil {
+					writeSSE(w, "error", "", []byte(strconv.Quote(err.Error())))
+					fl.Flush()
+				}
+				return
+			}
+			if e.Seq <= last {
+				continue
+			}
+			last = e.Seq
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			fl.Flush()
+		case <-hb.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			fl.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// catchUp sends the stored events accepted by f with TS >= fromTS and
+// after < seq <= until, in seq order: what a live subscription that started
+// at seq until has not seen. The store is read a page at a time; an error
+// from send stops it and is returned.
+func (h *HTTP) catchUp(ctx context.Context, f store.Filter, fromTS int64, after, until uint64, send func(store.Event) error) error {
+	r, err := h.store.ChangesMatching(after, f)
+	if err != nil {
+		return err
+	}
+	for {
+		page, err := r.Next(catchUpPage)
+		if err != nil || len(page) == 0 {
+			return err
+		}
+		for _, e := range page {
+			if e.Seq > until {
+				return nil
+			}
+			if e.TS < fromTS {
+				continue
+			}
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e store.Event) error {
+	b, _ := json.Marshal(eventDTO{Key: e.Key, TS: e.TS, Value: e.Value, Seq: e.Seq})
+	return writeSSE(w, "", strconv.FormatUint(e.Seq, 10), b)
+}
+
+// writeSSE writes one SSE message; an empty event name leaves it a default
+// "message" event.
+func writeSSE(w http.ResponseWriter, event, id string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if event != "" {
+		if _, err := fmt.Fprintf(w, "event: %s\n", event); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
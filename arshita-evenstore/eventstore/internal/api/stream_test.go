@@ -0,0 +1,112 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eventstore/internal/store"
+)
+
+func putEvent(t *testing.T, s *store.LSMStore, key string, ts int64) {
+	t.Helper()
+	if err := s.Put(context.Background(), store.Event{Key: key, TS: ts, Value: []byte(`{"n":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readSSE reads n messages and returns their ids; any named event fails.
+func readSSE(t *testing.T, br *bufio.Reader, n int) []string {
+	t.Helper()
+	var ids []string
+	for len(ids) < n {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("after %v: %v", ids, err)
+		}
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			ids = append(ids, strings.TrimSpace(line[4:]))
+		case strings.HasPrefix(line, "event: "):
+			t.Fatalf("named event %q; onmessage would not see it", line)
+		}
+	}
+	return ids
+}
+
+func openStream(t *testing.T, url string, header ...string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream: %s", resp.Status)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// Resuming sends every event after Last-Event-ID, including ones a later
+// write to the same key replaced in the memtable, then goes live.
+func TestStreamResumesWithoutGaps(t *testing.T) {
+	h, s := newTestHTTP(t, 1000)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close) // after the stream bodies are closed
+
+	putEvent(t, s, "a", 1) // seq 1
+	putEvent(t, s, "b", 2)
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	putEvent(t, s, "a", 3) // replaced by seq 5 in the memtable
+	putEvent(t, s, "c", 4)
+	putEvent(t, s, "a", 5)
+
+	br := openStream(t, srv.URL+"/events/stream", "Last-Event-ID", "1")
+	if got := fmt.Sprint(readSSE(t, br, 4)); got != "[2 3 4 5]" {
+		t.Fatalf("catch-up: %s", got)
+	}
+	putEvent(t, s, "d", 6)
+	if got := fmt.Sprint(readSSE(t, br, 1)); got != "[6]" {
+		t.Fatalf("live: %s", got)
+	}
+}
+
+func TestStreamCatchUpFilters(t *testing.T) {
+	h, s := newTestHTTP(t, 1000)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close) // after the stream bodies are closed
+	for i, key := range []string{"order-1", "user-1", "order-2", "order-3"} {
+		putEvent(t, s, key, int64(10*(i+1)))
+	}
+
+	br := openStream(t, srv.URL+"/events/stream?prefix=order-&from=25")
+	if got := fmt.Sprint(readSSE(t, br, 2)); got != "[3 4]" {
+		t.Fatalf("catch-up: %s", got)
+	}
+	putEvent(t, s, "user-2", 50)
+	putEvent(t, s, "order-4", 60)
+	if got := fmt.Sprint(readSSE(t, br, 1)); got != "[6]" {
+		t.Fatalf("live: %s", got)
+	}
+}
+
+func TestStreamRejectsBadResumePoints(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	for _, url := range []string{"/events/stream?from_seq=x", "/events/stream?from=x", "/events/stream?filter=$.a>x", "/events/stream?filter=$.a=b"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d", url, rec.Code)
+		}
+	}
+}
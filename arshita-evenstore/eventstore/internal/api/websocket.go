@@ -0,0 +1,418 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"eventstore/internal/store"
+	"eventstore/internal/ws"
+)
+
+const (
+	wsWindow       = 256   // default unacked events per subscription
+	wsMaxWindow    = 10000 // largest window a client may ask for
+	wsMaxSubs      = 64    // subscriptions per connection
+	wsWriteTimeout = 10 * time.Second
+	wsPing         = 30 * time.Second
+)
+
+// errWSStopped ends a catch-up whose delivery was cut short.
+var errWSStopped = errors.New("delivery stopped")
+
+// wsRequest is a client message on GET /events/ws.
+//
+//	{"op":"subscribe","id":"s1","prefix":"user-","filter":["type==signup"],"from":0,"window":100}
+//	{"op":"subscribe","id":"s1","resume":"<token>"}   resume after a previously received event
+//	{"op":"update","id":"s1","prefix":"order-"}       swap the filter, keep the position
+//	{"op":"ack","id":"s1","token":"<token>"}          acknowledge everything up to token
+//	{"op":"unsubscribe","id":"s1"}
+type wsRequest struct {
+	Op     string   `json:"op"`
+	ID     string   `json:"id"`
+	Prefix string   `json:"prefix,omitempty"`
+	Key    string   `json:"key,omitempty"` // glob
+	Filter []string `json:"filter,omitempty"`
+	From   *int64   `json:"from,omitempty"` // catch up on stored events with TS >= from
+	Resume string   `json:"resume,omitempty"`
+	Window int      `json:"window,omitempty"`
+	Token  string   `json:"token,omitempty"`
+}
+
+// wsMessage is a server message: subscribed, event, caught_up, updated,
+// unsubscribed or error.
+type wsMessage struct {
+	Type  string    `json:"type"`
+	ID    string    `json:"id,omitempty"`
+	Token string    `json:"token,omitempty"`
+	Event *eventDTO `json:"event,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// wsSession is one WebSocket connection multiplexing named subscriptions.
+type wsSession struct {
+	h    *HTTP
+	conn *ws.Conn
+	ctx  context.Context
+
+	mu   sync.Mutex
+	subs map[string]*wsSub
+}
+
+// wsSub is one subscription. Its credit channel holds one token per event
+// the client may still receive without acking; delivery blocks when it is
+// empty, and a live subscription that falls behind meanwhile is rebuilt
+// from its position by catch-up, so backpressure never loses events.
+type wsSub struct {
+	id     string
+	filter store.Filter
+	fromTS int64
+	credit chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	pos      uint64   // last seq delivered (or live start)
+	inflight []uint64 // delivered, not yet acked
+}
+
+// WithOrigins allows browser pages from these origins ("*" for any) to open
+// WebSockets, besides pages served from the API's own host.
+func (h *HTTP) WithOrigins(origins []string) *HTTP {
+	h.origins = origins
+	return h
+}
+
+// originAllowed guards against cross-site WebSocket hijacking: a browser
+// sends the page's Origin, and a page from another site must not ride on the
+// user's credentials. Clients that send no Origin are not browsers.
+func (h *HTTP) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range h.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// websocket serves GET /events/ws; see wsRequest for the protocol. Every
+// event carries a resume token; subscribing with it continues right after
+// that event.
+func (h *HTTP) websocket(w http.ResponseWriter, r *http.Request) {
+	if !h.originAllowed(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	c, err := ws.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	// the request context outlives the hijack; it is cancelled when the
+	// server (or a namespace drop) wants the connection gone
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &wsSession{h: h, conn: c, ctx: ctx, subs: map[string]*wsSub{}}
+	stop := context.AfterFunc(r.Context(), func() { c.Close(ws.CloseGoingAway, "going away") })
+	defer stop()
+	defer func() {
+		cancel()
+		s.mu.Lock()
+		for _, sub := range s.subs {
+			<-sub.done
+		}
+		s.mu.Unlock()
+		c.Close(ws.CloseNormal, "")
+	}()
+	go s.pinger()
+
+	for {
+		b, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			s.send(wsMessage{Type: "error", Error: "invalid json"})
+			continue
+		}
+		if err := s.handle(req); err != nil {
+			s.send(wsMessage{Type: "error", ID: req.ID, Error: err.Error()})
+		}
+	}
+}
+
+func (s *wsSession) handle(req wsRequest) error {
+	if req.ID == "" {
+		return errors.New("id required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := s.subs[req.ID]
+
+	switch req.Op {
+	case "subscribe":
+		if sub != nil {
+			return errors.New("subscription id in use")
+		}
+		if len(s.subs) >= wsMaxSubs {
+			return errors.New("too many subscriptions")
+		}
+		window := req.Window
+		if window <= 0 {
+			window = wsWindow
+		}
+		if window > wsMaxWindow {
+			window = wsMaxWindow
+		}
+		sub = &wsSub{id: req.ID, filter: wsFilter(req), fromTS: math.MinInt64, credit: make(chan struct{}, window)}
+		for i := 0; i < window; i++ {
+			sub.credit <- struct{}{}
+		}
+		catchUp := false
+		if req.Resume != "" {
+			seq, err := decodeToken(req.Resume)
+			if err != nil {
+				return err
+			}
+			sub.pos, catchUp = seq, true
+		}
+		if req.From != nil {
+			sub.fromTS, catchUp = *req.From, true
+		}
+		if err := s.start(sub, catchUp, "subscribed"); err != nil {
+			return err
+		}
+		s.subs[req.ID] = sub
+		return nil
+
+	case "update":
+		if sub == nil {
+			return errors.New("unknown subscription")
+		}
+		sub.stop()
+		old := sub.filter
+		sub.filter = wsFilter(req)
+		// continue from the current position under the new filter
+		if err := s.start(sub, true, "updated"); err != nil {
+			sub.filter = old
+			if err2 := s.start(sub, true, "updated"); err2 != nil {
+				delete(s.subs, req.ID)
+			}
+			return err
+		}
+		return nil
+
+	case "unsubscribe":
+		if sub == nil {
+			return errors.New("unknown subscription")
+		}
+		sub.stop()
+		delete(s.subs, req.ID)
+		s.send(wsMessage{Type: "unsubscribed", ID: req.ID})
+		return nil
+
+	case "ack":
+		if sub == nil {
+			return errors.New("unknown subscription")
+		}
+		seq, err := decodeToken(req.Token)
+		if err != nil {
+			return err
+		}
+		sub.ack(seq)
+		return nil
+	}
+	return errors.New("unknown op")
+}
+
+func wsFilter(req wsRequest) store.Filter {
+	f := store.Filter{KeyPrefix: req.Prefix, KeyGlob: req.Key}
+	for _, p := range req.Filter {
+		f.Where = append(f.Where, store.ParsePredicate(p))
+	}
+	return f
+}
+
+// start subscribes to the store (so filter errors surface synchronously),
+// confirms with a msg message and runs delivery for sub in the background.
+func (s *wsSession) start(sub *wsSub, catchUp bool, msg string) error {
+	ss, err := s.h.store.Subscribe(sub.filter, sseBuffer)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sub.cancel, sub.done = cancel, make(chan struct{})
+	if !catchUp {
+		sub.mu.Lock()
+		sub.pos = ss.Start
+		sub.mu.Unlock()
+	}
+	s.send(wsMessage{Type: msg, ID: sub.id, Token: encodeToken(sub.position())})
+	go s.run(ctx, sub, ss, catchUp)
+	return nil
+}
+
+func (s *wsSession) run(ctx context.Context, sub *wsSub, ss *store.Subscription, catchUp bool) {
+	defer close(sub.done)
+	for {
+		if catchUp {
+			err := s.h.catchUp(ctx, sub.filter, sub.fromTS, sub.position(), ss.Start, func(e store.Event) error {
+				if !s.deliver(ctx, sub, e) {
+					return errWSStopped
+				}
+				return nil
+			})
+			if err != nil {
+				ss.Close()
+				if ctx.Err() == nil && err != errWSStopped {
+					s.send(wsMessage{Type: "error", ID: sub.id, Error: err.Error()})
+				}
+				return
+			}
+			s.send(wsMessage{Type: "caught_up", ID: sub.id, Token: encodeToken(sub.position())})
+		}
+		sub.advance(ss.Start)
+
+	live:
+		for {
+			select {
+			case e, ok := <-ss.C:
+				if !ok {
+					break live
+				}
+				if e.Seq <= sub.position() {
+					continue
+				}
+				if !s.deliver(ctx, sub, e) {
+					ss.Close()
+					return
+				}
+			case <-ctx.Done():
+				ss.Close()
+				return
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if !errors.Is(ss.Err(), store.ErrSlowConsumer) {
+			// the store is shutting down
+			s.conn.Close(ws.CloseGoingAway, "server shutting down")
+			return
+		}
+		// fell behind: resubscribe and catch up from the last delivered event
+		var err error
+		if ss, err = s.h.store.Subscribe(sub.filter, sseBuffer); err != nil {
+			s.send(wsMessage{Type: "error", ID: sub.id, Error: err.Error()})
+			return
+		}
+		catchUp = true
+	}
+}
+
+// deliver waits for window credit and sends e; false means stop.
+func (s *wsSession) deliver(ctx context.Context, sub *wsSub, e store.Event) bool {
+	select {
+	case <-sub.credit:
+	case <-ctx.Done():
+		return false
+	}
+	sub.mu.Lock()
+	sub.pos = e.Seq
+	sub.inflight = append(sub.inflight, e.Seq)
+	sub.mu.Unlock()
+	return s.send(wsMessage{
+		Type:  "event",
+		ID:    sub.id,
+		Token: encodeToken(e.Seq),
+		Event: &eventDTO{Key: e.Key, TS: e.TS, Value: e.Value, Seq: e.Seq},
+	})
+}
+
+// send writes one message; a client too slow to take it is disconnected.
+func (s *wsSession) send(m wsMessage) bool {
+	b, _ := json.Marshal(m)
+	if err := s.conn.WriteText(b, wsWriteTimeout); err != nil {
+		s.conn.Close(ws.CloseGoingAway, "write timeout")
+		return false
+	}
+	return true
+}
+
+func (s *wsSession) pinger() {
+	t := time.NewTicker(wsPing)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.conn.Ping(wsWriteTimeout); err != nil {
+				s.conn.Close(ws.CloseGoingAway, "")
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (sub *wsSub) stop() {
+	sub.cancel()
+	<-sub.done
+}
+
+func (sub *wsSub) position() uint64 {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.pos
+}
+
+func (sub *wsSub) advance(seq uint64) {
+	sub.mu.Lock()
+	if seq > sub.pos {
+		sub.pos = seq
+	}
+	sub.mu.Unlock()
+}
+
+// ack returns window credit for every delivered event up to seq.
+func (sub *wsSub) ack(seq uint64) {
+	sub.mu.Lock()
+	n := 0
+	for n < len(sub.inflight) && sub.inflight[n] <= seq {
+		n++
+	}
+	sub.inflight = sub.inflight[n:]
+	sub.mu.Unlock()
+	for i := 0; i < n; i++ {
+		sub.credit <- struct{}{}
+	}
+}
+
+// Resume tokens are opaque to clients; today they wrap the event seq.
+func encodeToken(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString(binary.BigEndian.AppendUint64(nil, seq))
+}
+
+func decodeToken(s string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != 8 {
+		return 0, errors.New("invalid token")
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type wsClient struct {
+	nc net.Conn
+	br *bufio.Reader
+}
+
+// dialWS opens /events/ws with the given Origin ("" for none) and returns
+// the client, or nil and the handshake status when it was refused.
+func dialWS(t *testing.T, srv *httptest.Server, origin string) (*wsClient, int) {
+	t.Helper()
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET /events/ws HTTP/1.1\r\nHost: " + srv.Listener.Addr().String() + "\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	io.WriteString(nc, req+"\r\n")
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp.StatusCode
+	}
+	return &wsClient{nc: nc, br: br}, resp.StatusCode
+}
+
+func (c *wsClient) send(t *testing.T, v any) {
+	t.Helper()
+	b, _ := json.Marshal(v)
+	if len(b) > 125 {
+		t.Fatal("test message too long")
+	}
+	mask := []byte{9, 8, 7, 6}
+	frame := append([]byte{0x81, 0x80 | byte(len(b))}, mask...)
+	for i, x := range b {
+		frame = append(frame, x^mask[i%4])
+	}
+	if _, err := c.nc.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recv returns the next server message, skipping pings.
+func (c *wsClient) recv(t *testing.T) wsMessage {
+	t.Helper()
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(c.br, h[:]); err != nil {
+			t.Fatal(err)
+		}
+		n := int(h[1] & 0x7F)
+		switch n {
+		case 126:
+			var b [2]byte
+			io.ReadFull(c.br, b[:])
+			n = int(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			io.ReadFull(c.br, b[:])
+			n = int(binary.BigEndian.Uint64(b[:]))
+		}
+		p := make([]byte, n)
+		if _, err := io.ReadFull(c.br, p); err != nil {
+			t.Fatal(err)
+		}
+		if h[0]&0x0F != 0x1 {
+			continue
+		}
+		var m wsMessage
+		if err := json.Unmarshal(p, &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+}
+
+// events reads n event messages and returns their seqs and last token.
+func (c *wsClient) events(t *testing.T, n int) ([]uint64, string) {
+	t.Helper()
+	var seqs []uint64
+	var token string
+	for len(seqs) < n {
+		m := c.recv(t)
+		if m.Type == "error" {
+			t.Fatalf("error: %s", m.Error)
+		}
+		if m.Type == "event" {
+			seqs, token = append(seqs, m.Event.Seq), m.Token
+		}
+	}
+	return seqs, token
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	h, _ := newTestHTTP(t, 1000)
+	srv := httptest.NewServer(h.WithOrigins([]string{"https://app.example.com"}))
+	t.Cleanup(srv.Close)
+
+	for _, c := range []struct {
+		origin string
+		want   int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://" + srv.Listener.Addr().String(), http.StatusSwitchingProtocols},
+		{"https://app.example.com", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	} {
+		if _, got := dialWS(t, srv, c.origin); got != c.want {
+			t.Errorf("origin %q: %d, want %d", c.origin, got, c.want)
+		}
+	}
+}
+
+// Resuming replays everything after the token in seq order, then goes live,
+// and delivery stops at the window until the client acks.
+func TestWebSocketResumeAndWindow(t *testing.T) {
+	h, s := newTestHTTP(t, 1000)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	putEvent(t, s, "a", 1)
+	putEvent(t, s, "b", 2)
+	c, _ := dialWS(t, srv, "")
+	c.send(t, wsRequest{Op: "subscribe", ID: "s", From: new(int64)})
+	if m := c.recv(t); m.Type != "subscribed" {
+		t.Fatalf("subscribe: %+v", m)
+	}
+	_, token := c.events(t, 1) // seq 1
+
+	c2, _ := dialWS(t, srv, "")
+	putEvent(t, s, "a", 3) // replaces seq 1 in the memtable
+	putEvent(t, s, "c", 4)
+	c2.send(t, wsRequest{Op: "subscribe", ID: "s", Resume: token, Window: 2})
+	if m := c2.recv(t); m.Type != "subscribed" {
+		t.Fatalf("resume: %+v", m)
+	}
+	got, last := c2.events(t, 2)
+	if fmt.Sprint(got) != "[2 3]" {
+		t.Fatalf("resumed: %v", got)
+	}
+
+	// window full: seq 4 waits for an ack
+	c2.nc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	var h2 [1]byte
+	if _, err := c2.br.Read(h2[:]); err == nil {
+		t.Fatal("delivered past the window")
+	}
+	c2.nc.SetDeadline(time.Now().Add(5 * time.Second))
+	c2.send(t, wsRequest{Op: "ack", ID: "s", Token: last})
+	if got, _ := c2.events(t, 1); fmt.Sprint(got) != "[4]" {
+		t.Fatalf("after ack: %v", got)
+	}
+}
@@ -0,0 +1,65 @@
+// Package audit records operator actions in an append-only NDJSON trail.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry is one recorded action.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Action    string    `json:"action"` // e.g. "flush", "compact", "readonly"
+	Params    any       `json:"params,omitempty"`
+	Result    any       `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Log is <DATA_DIR>/admin-audit.log.
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+func Open(dataDir string) *Log { return &Log{path: filepath.Join(dataDir, "admin-audit.log")} }
+
+func (l *Log) Append(e Entry) error {
+	if e.Time.IsZero() { e.Time = time.Now().UTC() }
+	b, err := json.Marshal(e)
+	if err != nil { return err }
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil { return err }
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// Tail returns up to limit of the most recent entries, oldest first.
+func (l *Log) Tail(limit int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) { return []Entry{}, nil }
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	out := []Entry{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1<<20), 1<<25)
+	for sc.Scan() {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil { continue }
+		out = append(out, e)
+		if limit > 0 && len(out) > limit { out = out[1:] }
+	}
+	return out, sc.Err()
+}
@@ -0,0 +1,358 @@
+// Package config loads the server configuration: built-in defaults, then a
+// JSON file, then environment variables, then command-line flags, each
+// layer overriding the one before. The result is validated as a whole.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"eventstore/internal/logging"
+)
+
+// Duration is a time.Duration written as a Go duration string ("10s").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil { return fmt.Errorf("want a duration string like \"10s\"") }
+	v, err := parseDuration(s)
+	if err != nil { return err }
+	*d = Duration(v)
+	return nil
+}
+
+// parseDuration treats "" as zero.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" { return 0, nil }
+	return time.ParseDuration(s)
+}
+
+type Config struct {
+	HTTPAddr         string   `json:"http_addr"`
+	DataDir          string   `json:"data_dir"`
+	MemtableMaxItems int      `json:"memtable_max_items"`
+	Retention        Duration `json:"retention"` // 0 keeps everything; reloadable
+
+	Log         Log         `json:"log"`
+	RateLimit   RateLimit   `json:"rate_limit"`  // reloadable
+	Concurrency Concurrency `json:"concurrency"` // reloadable but for enabled
+	Breaker     Breaker     `json:"breaker"`     // reloadable
+	Kafka       Kafka       `json:"kafka"`
+	Idempotency Idempotency `json:"idempotency"`
+	Health      Health      `json:"health"`
+	Auth        Auth        `json:"auth"`
+	TLS         TLS         `json:"tls"`
+	Trace       Trace       `json:"trace"`
+	WebSocket   WebSocket   `json:"websocket"`
+}
+
+type Log struct {
+	Format string `json:"format"` // json or text
+	Level  string `json:"level"`  // debug, info, warn, error; reloadable
+}
+
+// RateLimit applies per client to the default keyspace; RPS and Burst are
+// the default for new namespaces. Reads (GET) and writes draw from separate
+// buckets; the write bucket copies the read one when WriteRPS is 0.
+type RateLimit struct {
+	RPS        float64        `json:"rps"`
+	Burst      int            `json:"burst"`
+	WriteRPS   float64        `json:"write_rps"`
+	WriteBurst int            `json:"write_burst"`
+	Key        string         `json:"key"`         // ip, principal or header:<name>; needs a restart
+	MaxClients int            `json:"max_clients"` // limiters kept before the least recently used is dropped
+	RouteCosts map[string]int `json:"route_costs"` // tokens per request by "METHOD /route"; default 1
+}
+
+// Concurrency bounds requests in flight across all keyspaces with a limit
+// that adapts to latency, shedding the excess with 503s.
+type Concurrency struct {
+	Enabled       bool     `json:"enabled"`
+	Initial       int      `json:"initial"`
+	Min           int      `json:"min"`
+	Max           int      `json:"max"`
+	TargetLatency Duration `json:"target_latency"` // slower requests shrink the limit
+	Backoff       float64  `json:"backoff"`        // factor applied on each shrink
+	Priority      string   `json:"priority"`       // "", reads or writes
+	Reserve       float64  `json:"reserve"`        // share of the limit only the priority class may use
+}
+
+// Breaker guards the Kafka producer.
+type Breaker struct {
+	MinRequests      uint32   `json:"min_requests"`
+	FailureRatio     float64  `json:"failure_ratio"`
+	Interval         Duration `json:"interval"`
+	OpenTimeout      Duration `json:"open_timeout"`
+	HalfOpenRequests uint32   `json:"half_open_requests"`
+}
+
+type Kafka struct {
+	Enabled         bool     `json:"enabled"`
+	Brokers         string   `json:"brokers"` // comma-separated
+	Topic           string   `json:"topic"`
+	ConsumeTopic    string   `json:"consume_topic"`
+	DeadLetterTopic string   `json:"dead_letter_topic,omitempty"` // empty: local dead-letter log only
+	MaxAttempts     int      `json:"max_attempts"`                // failed store attempts before the consumer reports itself paused
+	RetryBackoff    Duration `json:"retry_backoff"`               // first retry delay, doubled per attempt
+}
+
+type Idempotency struct {
+	MaxKeys int      `json:"max_keys"`
+	Window  Duration `json:"window"`
+}
+
+type Health struct {
+	MinFreeMB int `json:"min_free_mb"`
+}
+
+type Auth struct {
+	KeysFile       string   `json:"keys_file,omitempty"`
+	JWTSecret      string   `json:"jwt_secret,omitempty"`
+	ReloadInterval Duration `json:"reload_interval"`
+}
+
+type TLS struct {
+	CertFile       string   `json:"cert_file,omitempty"`
+	KeyFile        string   `json:"key_file,omitempty"`
+	ClientCAFile   string   `json:"client_ca_file,omitempty"`
+	ClientAuth     string   `json:"client_auth,omitempty"` // "" or "require"
+	ReloadInterval Duration `json:"reload_interval"`
+}
+
+type Trace struct {
+	Exporter    string `json:"exporter,omitempty"` // "", stdout or otlp-file
+	File        string `json:"file,omitempty"`     // otlp-file output; default <data_dir>/traces.jsonl
+	ServiceName string `json:"service_name"`
+}
+
+// WebSocket lists the browser origins, besides the server's own, allowed to
+// open /events/ws. Requests without an Origin header (non-browser clients)
+// are not checked.
+type WebSocket struct {
+	AllowedOrigins []string `json:"allowed_origins,omitempty"` // e.g. "https://app.example.com"; "*" allows any
+}
+
+// Default is the configuration with nothing set.
+func Default() Config {
+	return Config{
+		HTTPAddr:         ":8080",
+		DataDir:          "./data",
+		MemtableMaxItems: 50000,
+		Log:              Log{Format: "text", Level: "info"},
+		RateLimit: RateLimit{
+			RPS:        100,
+			Burst:      200,
+			Key:        "ip",
+			MaxClients: 10000,
+			RouteCosts: map[string]int{"GET /events": 5, "POST /events:batch": 10},
+		},
+		Concurrency: Concurrency{
+			Enabled:       true,
+			Initial:       64,
+			Min:           8,
+			Max:           1024,
+			TargetLatency: Duration(250 * time.Millisecond),
+			Backoff:       0.9,
+			Priority:      "writes",
+			Reserve:       0.2,
+		},
+		Breaker: Breaker{
+			MinRequests:      5,
+			FailureRatio:     0.5,
+			Interval:         Duration(30 * time.Second),
+			OpenTimeout:      Duration(10 * time.Second),
+			HalfOpenRequests: 3,
+		},
+		Kafka:       Kafka{Enabled: true, Brokers: "localhost:9092", Topic: "events", ConsumeTopic: "events-in", MaxAttempts: 5, RetryBackoff: Duration(500 * time.Millisecond)},
+		Idempotency: Idempotency{MaxKeys: 100000, Window: Duration(24 * time.Hour)},
+		Health:      Health{MinFreeMB: 100},
+		Auth:        Auth{ReloadInterval: Duration(5 * time.Second)},
+		TLS:         TLS{ReloadInterval: Duration(10 * time.Second)},
+		Trace:       Trace{ServiceName: "eventstore"},
+	}
+}
+
+// Load layers the file at path (skipped when path is ""), the environment
+// and then flags over the defaults, and validates the result.
+func Load(path string, flags func(*Config)) (Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.readFile(path); err != nil { return Config{}, err }
+	}
+	if err := c.applyEnv(os.LookupEnv); err != nil { return Config{}, err }
+	if flags != nil { flags(&c) }
+	if c.Trace.Exporter == "otlp-file" && c.Trace.File == "" { c.Trace.File = filepath.Join(c.DataDir, "traces.jsonl") }
+	if err := c.Validate(); err != nil { return Config{}, err }
+	return c, nil
+}
+
+// readFile decodes path over c. Unknown fields are errors, so a misspelt
+// setting is not silently ignored.
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil { return fmt.Errorf("config: %w", err) }
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil { return fmt.Errorf("config %s: %s", path, describe(b, err)) }
+	if _, err := dec.Token(); err != io.EOF { return fmt.Errorf("config %s: unexpected data after the top-level object", path) }
+	return nil
+}
+
+// describe adds the line number to JSON syntax and type errors.
+func describe(b []byte, err error) string {
+	var off int64
+	var syn *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syn):
+		off = syn.Offset
+	case errors.As(err, &typ):
+		if typ.Field != "" { return fmt.Sprintf("%s: want %s, got %s (line %d)", typ.Field, typ.Type, typ.Value, line(b, typ.Offset)) }
+		off = typ.Offset
+	default:
+		return err.Error()
+	}
+	return fmt.Sprintf("%v (line %d)", err, line(b, off))
+}
+
+func line(b []byte, off int64) int {
+	if off > int64(len(b)) { off = int64(len(b)) }
+	return bytes.Count(b[:off], []byte("\n")) + 1
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	bad := func(field, format string, a ...any) { errs = append(errs, fmt.Errorf("%s: "+format, append([]any{field}, a...)...)) }
+
+	if c.HTTPAddr == "" { bad("http_addr", "required") }
+	if c.DataDir == "" { bad("data_dir", "required") }
+	if c.MemtableMaxItems <= 0 { bad("memtable_max_items", "must be positive, got %d", c.MemtableMaxItems) }
+	if c.Retention < 0 { bad("retention", "must not be negative") }
+	if f := strings.ToLower(c.Log.Format); f != "json" && f != "text" { bad("log.format", "want json or text, got %q", c.Log.Format) }
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil { bad("log.level", "want debug, info, warn or error, got %q", c.Log.Level) }
+	if c.RateLimit.RPS <= 0 { bad("rate_limit.rps", "must be positive, got %v", c.RateLimit.RPS) }
+	if c.RateLimit.Burst < 1 { bad("rate_limit.burst", "must be at least 1, got %d", c.RateLimit.Burst) }
+	if c.RateLimit.WriteRPS < 0 { bad("rate_limit.write_rps", "must not be negative") }
+	if c.RateLimit.WriteRPS > 0 && c.RateLimit.WriteBurst < 1 { bad("rate_limit.write_burst", "must be at least 1 when write_rps is set, got %d", c.RateLimit.WriteBurst) }
+	if k := c.RateLimit.Key; k != "ip" && k != "principal" && (!strings.HasPrefix(k, "header:") || k == "header:") { bad("rate_limit.key", "want ip, principal or header:<name>, got %q", k) }
+	if c.RateLimit.MaxClients < 1 { bad("rate_limit.max_clients", "must be at least 1, got %d", c.RateLimit.MaxClients) }
+	for route, n := range c.RateLimit.RouteCosts {
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") { bad("rate_limit.route_costs", "want \"METHOD /route\", got %q", route) }
+		if n < 1 { bad("rate_limit.route_costs", "%q: cost must be at least 1, got %d", route, n) }
+	}
+	if c.Concurrency.Enabled {
+		if c.Concurrency.Min < 1 { bad("concurrency.min", "must be at least 1, got %d", c.Concurrency.Min) }
+		if c.Concurrency.Max < c.Concurrency.Min { bad("concurrency.max", "must be at least min (%d), got %d", c.Concurrency.Min, c.Concurrency.Max) }
+		if c.Concurrency.Initial < c.Concurrency.Min || c.Concurrency.Initial > c.Concurrency.Max { bad("concurrency.initial", "must be between min and max, got %d", c.Concurrency.Initial) }
+		if c.Concurrency.TargetLatency <= 0 { bad("concurrency.target_latency", "must be positive") }
+		if c.Concurrency.Backoff <= 0 || c.Concurrency.Backoff >= 1 { bad("concurrency.backoff", "must be between 0 and 1, got %v", c.Concurrency.Backoff) }
+		if p := c.Concurrency.Priority; p != "" && p != "reads" && p != "writes" { bad("concurrency.priority", "want \"\", reads or writes, got %q", p) }
+		if c.Concurrency.Reserve < 0 || c.Concurrency.Reserve >= 1 { bad("concurrency.reserve", "must be at least 0 and below 1, got %v", c.Concurrency.Reserve) }
+	}
+	if c.Breaker.MinRequests < 1 { bad("breaker.min_requests", "must be at least 1") }
+	if c.Breaker.FailureRatio <= 0 || c.Breaker.FailureRatio >= 1 { bad("breaker.failure_ratio", "must be between 0 and 1, got %v", c.Breaker.FailureRatio) }
+	if c.Breaker.Interval < 0 { bad("breaker.interval", "must not be negative") }
+	if c.Breaker.OpenTimeout <= 0 { bad("breaker.open_timeout", "must be positive") }
+	if c.Breaker.HalfOpenRequests < 1 { bad("breaker.half_open_requests", "must be at least 1") }
+	if c.Kafka.Enabled {
+		if strings.Trim(c.Kafka.Brokers, ", ") == "" { bad("kafka.brokers", "required when kafka is enabled") }
+		if c.Kafka.Topic == "" { bad("kafka.topic", "required when kafka is enabled") }
+		if c.Kafka.ConsumeTopic == c.Kafka.Topic { bad("kafka.consume_topic", "must differ from kafka.topic, where every committed event is published") }
+		if t := c.Kafka.DeadLetterTopic; t != "" && (t == c.Kafka.Topic || t == c.Kafka.ConsumeTopic) { bad("kafka.dead_letter_topic", "must differ from kafka.topic and kafka.consume_topic") }
+		if c.Kafka.MaxAttempts < 1 { bad("kafka.max_attempts", "must be at least 1, got %d", c.Kafka.MaxAttempts) }
+		if c.Kafka.RetryBackoff <= 0 { bad("kafka.retry_backoff", "must be positive") }
+	}
+	if c.Idempotency.MaxKeys <= 0 { bad("idempotency.max_keys", "must be positive, got %d", c.Idempotency.MaxKeys) }
+	if c.Idempotency.Window <= 0 { bad("idempotency.window", "must be positive") }
+	if c.Health.MinFreeMB < 0 { bad("health.min_free_mb", "must not be negative") }
+	if c.Auth.ReloadInterval <= 0 { bad("auth.reload_interval", "must be positive") }
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") { bad("tls", "cert_file and key_file must be set together") }
+	switch c.TLS.ClientAuth {
+	case "":
+	case "require":
+		if c.TLS.ClientCAFile == "" { bad("tls.client_ca_file", "required when client_auth is require") }
+	default:
+		bad("tls.client_auth", "want \"\" or require, got %q", c.TLS.ClientAuth)
+	}
+	if c.TLS.ReloadInterval <= 0 { bad("tls.reload_interval", "must be positive") }
+	switch c.Trace.Exporter {
+	case "", "stdout", "otlp-file":
+	default:
+		bad("trace.exporter", "want stdout or otlp-file, got %q", c.Trace.Exporter)
+	}
+	for _, o := range c.WebSocket.AllowedOrigins {
+		if u, err := url.Parse(o); o != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") { bad("websocket.allowed_origins", "want scheme://host[:port] or *, got %q", o) }
+	}
+	if len(errs) == 0 { return nil }
+	return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+}
+
+// WithReloadable returns c with the settings that can change at runtime
+// (retention, log level, rate limits but the key, concurrency limits,
+// breaker) taken from next.
+func (c Config) WithReloadable(next Config) Config {
+	c.Retention = next.Retention
+	c.Log.Level = next.Log.Level
+	key := c.RateLimit.Key
+	c.RateLimit = next.RateLimit
+	c.RateLimit.Key = key
+	enabled := c.Concurrency.Enabled
+	c.Concurrency = next.Concurrency
+	c.Concurrency.Enabled = enabled
+	c.Breaker = next.Breaker
+	return c
+}
+
+// Diff lists the settings that differ between c and other, by JSON path.
+func (c Config) Diff(other Config) []string {
+	a, b := flatten(c), flatten(other)
+	var out []string
+	for k, v := range a {
+		if b[k] != v { out = append(out, k) }
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok { out = append(out, k) }
+	}
+	sort.Strings(out)
+	return out
+}
+
+func flatten(c Config) map[string]string {
+	raw, _ := json.Marshal(c)
+	var m map[string]any
+	json.Unmarshal(raw, &m)
+	out := map[string]string{}
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		if obj, ok := v.(map[string]any); ok {
+			for k, x := range obj {
+				p := k
+				if prefix != "" { p = prefix + "." + k }
+				walk(p, x)
+			}
+			return
+		}
+		out[prefix] = fmt.Sprint(v)
+	}
+	walk("", m)
+	return out
+}
+
+// Redacted is c with secrets masked, for display.
+func (c Config) Redacted() Config {
+	if c.Auth.JWTSecret != "" { c.Auth.JWTSecret = "<redacted>" }
+	return c
+}
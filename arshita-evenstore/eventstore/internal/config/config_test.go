@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil { t.Fatal(err) }
+	return path
+}
+
+// The file overrides the defaults, the environment the file, flags both.
+func TestLoadLayers(t *testing.T) {
+	path := writeConfig(t, `{
+  "data_dir": "/var/lib/es",
+  "retention": "720h",
+  "log": {"level": "debug"},
+  "rate_limit": {"rps": 10, "burst": 20},
+  "kafka": {"enabled": false}
+}`)
+	t.Setenv("RATE_LIMIT_RPS", "50")
+	t.Setenv("WS_ALLOWED_ORIGINS", " https://a.example.com, ,https://b.example.com")
+	t.Setenv("LOG_LEVEL", "")
+	c, err := Load(path, func(c *Config) { c.DataDir = "/flag" })
+	if err != nil { t.Fatal(err) }
+	if c.DataDir != "/flag" || c.Retention != Duration(720*time.Hour) || c.Log.Level != "debug" { t.Errorf("file and flags: %+v", c) }
+	if c.RateLimit.RPS != 50 || c.RateLimit.Burst != 20 || c.RateLimit.MaxClients != 10000 { t.Errorf("rate_limit: %+v", c.RateLimit) }
+	if got := strings.Join(c.WebSocket.AllowedOrigins, " "); got != "https://a.example.com https://b.example.com" { t.Errorf("origins: %q", got) }
+	if c.Kafka.Enabled { t.Error("kafka.enabled from the file ignored") }
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, c := range []struct{ body, want string }{
+		{`{"data_dri": "x"}`, `unknown field "data_dri"`},
+		{`{"retention": "soon"}`, `invalid duration "soon"`},
+		{"{\n\n  \"http_addr\": \n}", "line 4"},
+		{`{"memtable_max_items": "many"}`, "memtable_max_items: want int, got string"},
+		{`{} {}`, "unexpected data"},
+	} {
+		_, err := Load(writeConfig(t, c.body), nil)
+		if err == nil || !strings.Contains(err.Error(), c.want) { t.Errorf("%s: %v, want %q", c.body, err, c.want) }
+	}
+	t.Setenv("RATE_LIMIT_BURST", "lots")
+	if _, err := Load("", nil); err == nil || !strings.Contains(err.Error(), "RATE_LIMIT_BURST") { t.Errorf("bad env: %v", err) }
+}
+
+func TestValidateReportsEverything(t *testing.T) {
+	c := Default()
+	c.HTTPAddr = ""
+	c.Log.Format = "xml"
+	c.Breaker.FailureRatio = 2
+	c.Kafka.ConsumeTopic = c.Kafka.Topic
+	c.TLS.CertFile = "cert.pem"
+	c.WebSocket.AllowedOrigins = []string{"app.example.com", "*", "https://ok.example.com"}
+	err := c.Validate()
+	if err == nil { t.Fatal("invalid config accepted") }
+	for _, field := range []string{"http_addr", "log.format", "breaker.failure_ratio", "kafka.consume_topic", "tls:", `allowed_origins: want scheme://host[:port] or *, got "app.example.com"`} {
+		if !strings.Contains(err.Error(), field) { t.Errorf("missing %s in:\n%v", field, err) }
+	}
+	if n := strings.Count(err.Error(), "\n"); n != 6 { t.Errorf("%d problems reported, want 6:\n%v", n, err) }
+	if err := Default().Validate(); err != nil { t.Errorf("defaults: %v", err) }
+}
+
+func TestReloadable(t *testing.T) {
+	cur := Default()
+	next := Default()
+	next.Log.Level = "warn"
+	next.RateLimit.RPS = 5
+	next.RateLimit.Key = "principal"
+	next.Concurrency.Enabled = false
+	next.HTTPAddr = ":9090"
+	next.Auth.JWTSecret = "s3cret"
+
+	applied := cur.WithReloadable(next)
+	if got := strings.Join(cur.Diff(applied), " "); got != "log.level rate_limit.rps" { t.Errorf("applied: %s", got) }
+	if got := strings.Join(applied.Diff(next), " "); got != "auth.jwt_secret concurrency.enabled http_addr rate_limit.key" { t.Errorf("need a restart: %s", got) }
+	if r := next.Redacted(); r.Auth.JWTSecret != "<redacted>" || next.Auth.JWTSecret != "s3cret" { t.Errorf("redacted: %q", r.Auth.JWTSecret) }
+}
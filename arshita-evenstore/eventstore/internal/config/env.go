@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// envVars maps each environment variable onto its setting.
+var envVars = []struct {
+	name string
+	set  func(c *Config, v string) error
+}{
+	{"HTTP_ADDR", str(func(c *Config) *string { return &c.HTTPAddr })},
+	{"DATA_DIR", str(func(c *Config) *string { return &c.DataDir })},
+	{"MEMTABLE_MAX_ITEMS", integer(func(c *Config) *int { return &c.MemtableMaxItems })},
+	{"RETENTION", duration(func(c *Config) *Duration { return &c.Retention })},
+	{"LOG_FORMAT", str(func(c *Config) *string { return &c.Log.Format })},
+	{"LOG_LEVEL", str(func(c *Config) *string { return &c.Log.Level })},
+	{"RATE_LIMIT_RPS", float(func(c *Config) *float64 { return &c.RateLimit.RPS })},
+	{"RATE_LIMIT_BURST", integer(func(c *Config) *int { return &c.RateLimit.Burst })},
+	{"RATE_LIMIT_WRITE_RPS", float(func(c *Config) *float64 { return &c.RateLimit.WriteRPS })},
+	{"RATE_LIMIT_WRITE_BURST", integer(func(c *Config) *int { return &c.RateLimit.WriteBurst })},
+	{"RATE_LIMIT_KEY", str(func(c *Config) *string { return &c.RateLimit.Key })},
+	{"RATE_LIMIT_MAX_CLIENTS", integer(func(c *Config) *int { return &c.RateLimit.MaxClients })},
+	{"CONCURRENCY_ENABLED", boolean(func(c *Config) *bool { return &c.Concurrency.Enabled })},
+	{"CONCURRENCY_INITIAL", integer(func(c *Config) *int { return &c.Concurrency.Initial })},
+	{"CONCURRENCY_MIN", integer(func(c *Config) *int { return &c.Concurrency.Min })},
+	{"CONCURRENCY_MAX", integer(func(c *Config) *int { return &c.Concurrency.Max })},
+	{"CONCURRENCY_TARGET_LATENCY", duration(func(c *Config) *Duration { return &c.Concurrency.TargetLatency })},
+	{"CONCURRENCY_PRIORITY", str(func(c *Config) *string { return &c.Concurrency.Priority })},
+	{"BREAKER_MIN_REQUESTS", uint32s(func(c *Config) *uint32 { return &c.Breaker.MinRequests })},
+	{"BREAKER_FAILURE_RATIO", float(func(c *Config) *float64 { return &c.Breaker.FailureRatio })},
+	{"BREAKER_INTERVAL", duration(func(c *Config) *Duration { return &c.Breaker.Interval })},
+	{"BREAKER_OPEN_TIMEOUT", duration(func(c *Config) *Duration { return &c.Breaker.OpenTimeout })},
+	{"BREAKER_HALF_OPEN_REQUESTS", uint32s(func(c *Config) *uint32 { return &c.Breaker.HalfOpenRequests })},
+	{"KAFKA_ENABLED", boolean(func(c *Config) *bool { return &c.Kafka.Enabled })},
+	{"KAFKA_BROKERS", str(func(c *Config) *string { return &c.Kafka.Brokers })},
+	{"KAFKA_TOPIC", str(func(c *Config) *string { return &c.Kafka.Topic })},
+	{"KAFKA_CONSUME_TOPIC", str(func(c *Config) *string { return &c.Kafka.ConsumeTopic })},
+	{"KAFKA_DEAD_LETTER_TOPIC", str(func(c *Config) *string { return &c.Kafka.DeadLetterTopic })},
+	{"KAFKA_MAX_ATTEMPTS", integer(func(c *Config) *int { return &c.Kafka.MaxAttempts })},
+	{"KAFKA_RETRY_BACKOFF", duration(func(c *Config) *Duration { return &c.Kafka.RetryBackoff })},
+	{"IDEMPOTENCY_MAX_KEYS", integer(func(c *Config) *int { return &c.Idempotency.MaxKeys })},
+	{"IDEMPOTENCY_WINDOW", duration(func(c *Config) *Duration { return &c.Idempotency.Window })},
+	{"HEALTH_MIN_FREE_MB", integer(func(c *Config) *int { return &c.Health.MinFreeMB })},
+	{"AUTH_KEYS_FILE", str(func(c *Config) *string { return &c.Auth.KeysFile })},
+	{"AUTH_JWT_SECRET", str(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"AUTH_RELOAD_INTERVAL", duration(func(c *Config) *Duration { return &c.Auth.ReloadInterval })},
+	{"TLS_CERT_FILE", str(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"TLS_KEY_FILE", str(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"TLS_CLIENT_CA_FILE", str(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"TLS_CLIENT_AUTH", str(func(c *Config) *string { return &c.TLS.ClientAuth })},
+	{"TLS_RELOAD_INTERVAL", duration(func(c *Config) *Duration { return &c.TLS.ReloadInterval })},
+	{"TRACE_EXPORTER", str(func(c *Config) *string { return &c.Trace.Exporter })},
+	{"TRACE_FILE", str(func(c *Config) *string { return &c.Trace.File })},
+	{"TRACE_SERVICE_NAME", str(func(c *Config) *string { return &c.Trace.ServiceName })},
+	{"WS_ALLOWED_ORIGINS", list(func(c *Config) *[]string { return &c.WebSocket.AllowedOrigins })},
+}
+
+// applyEnv sets every variable that lookup finds. Empty values count as unset.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for _, ev := range envVars {
+		v, ok := lookup(ev.name)
+		if !ok || v == "" { continue }
+		if err := ev.set(c, strings.TrimSpace(v)); err != nil { return fmt.Errorf("env %s=%q: %w", ev.name, v, err) }
+	}
+	return nil
+}
+
+func str(f func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error { *f(c) = v; return nil }
+}
+
+// list splits a comma-separated value.
+func list(f func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var out []string
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" { out = append(out, s) }
+		}
+		*f(c) = out
+		return nil
+	}
+}
+
+func integer(f func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil { return fmt.Errorf("want an integer") }
+		*f(c) = n
+		return nil
+	}
+}
+
+func uint32s(f func(*Config) *uint32) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil { return fmt.Errorf("want a non-negative integer") }
+		*f(c) = uint32(n)
+		return nil
+	}
+}
+
+func float(f func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil { return fmt.Errorf("want a number") }
+		*f(c) = n
+		return nil
+	}
+}
+
+func boolean(f func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil { return fmt.Errorf("want true or false") }
+		*f(c) = b
+		return nil
+	}
+}
+
+func duration(f func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := parseDuration(v)
+		if err != nil { return fmt.Errorf("want a duration like 10s") }
+		*f(c) = Duration(d)
+		return nil
+	}
+}
@@ -0,0 +1,166 @@
+package dedupe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record is what a first request with an idempotency key produced; replays
+// of the same key get it back verbatim.
+type Record struct {
+	Key         string            `json:"key"`
+	Fingerprint string            `json:"fp,omitempty"` // hash of the request payload
+	Status      int               `json:"status"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        string            `json:"body"`
+	At          time.Time         `json:"at"`
+}
+
+// Fingerprint hashes an event as submitted, whether over HTTP or Kafka, so a
+// key reused with a different payload is told apart from a replay.
+func Fingerprint(id, key string, ts int64, value []byte) string {
+	var v bytes.Buffer
+	_ = json.Compact(&v, value)
+	b, _ := json.Marshal(struct {
+		ID    string          `json:"id,omitempty"`
+		Key   string          `json:"key"`
+		TS    int64           `json:"ts"`
+		Value json.RawMessage `json:"value"`
+	}{id, key, ts, v.Bytes()})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrInFlight means another request with the same key has not finished yet.
+var ErrInFlight = errors.New("request with this idempotency key is in progress")
+
+// Index is a bounded dedupe window persisted as an append-only NDJSON log.
+// It keeps at most max keys, none older than window, and rewrites the log
+// when it has grown to twice the live size.
+type Index struct {
+	path   string
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	f        *os.File
+	records  map[string]Record
+	inflight map[string]struct{}
+	appended int
+}
+
+func Open(path string, max int, window time.Duration) (*Index, error) {
+	if max <= 0 { max = 100000 }
+	if window <= 0 { window = 24 * time.Hour }
+	x := &Index{path: path, max: max, window: window, records: map[string]Record{}, inflight: map[string]struct{}{}}
+
+	if f, err := os.Open(path); err == nil {
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 0, 1<<20), 1<<25)
+		for sc.Scan() {
+			var r Record
+			if err := json.Unmarshal(sc.Bytes(), &r); err == nil && r.Key != "" { x.records[r.Key] = r }
+		}
+		f.Close()
+		if err := sc.Err(); err != nil { return nil, err }
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.compactLocked(); err != nil { return nil, err }
+	return x, nil
+}
+
+// Begin looks key up. A hit returns the stored record and true. A miss
+// reserves the key until Finish or Abort; concurrent callers get ErrInFlight.
+func (x *Index) Begin(key string) (Record, bool, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if r, ok := x.records[key]; ok {
+		if time.Since(r.At) <= x.window { return r, true, nil }
+		delete(x.records, key)
+	}
+	if _, busy := x.inflight[key]; busy { return Record{}, false, ErrInFlight }
+	x.inflight[key] = struct{}{}
+	return Record{}, false, nil
+}
+
+// Abort releases a reservation without recording anything, so the request
+// can be retried.
+func (x *Index) Abort(key string) {
+	x.mu.Lock()
+	delete(x.inflight, key)
+	x.mu.Unlock()
+}
+
+// Finish records the outcome for r.Key and releases the reservation.
+func (x *Index) Finish(r Record) error {
+	if r.At.IsZero() { r.At = time.Now().UTC() }
+	b, err := json.Marshal(r)
+	if err != nil { return err }
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.inflight, r.Key)
+	x.records[r.Key] = r
+	if _, err := x.f.Write(append(b, '\n')); err != nil { return err }
+	x.appended++
+	if x.appended > x.max || len(x.records) > x.max {
+		return x.compactLocked()
+	}
+	return nil
+}
+
+func (x *Index) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.records)
+}
+
+func (x *Index) Close() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.f == nil { return nil }
+	err := x.f.Close()
+	x.f = nil
+	return err
+}
+
+// compactLocked drops expired and excess records and rewrites the log.
+func (x *Index) compactLocked() error {
+	live := make([]Record, 0, len(x.records))
+	for _, r := range x.records {
+		if time.Since(r.At) <= x.window { live = append(live, r) }
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].At.Before(live[j].At) })
+	if len(live) > x.max { live = live[len(live)-x.max:] }
+
+	x.records = make(map[string]Record, len(live))
+	tmp := x.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil { return err }
+	w := bufio.NewWriter(f)
+	for _, r := range live {
+		x.records[r.Key] = r
+		b, _ := json.Marshal(r)
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil { f.Close(); return err }
+	if err := f.Close(); err != nil { return err }
+	if x.f != nil { x.f.Close() }
+	if err := os.Rename(tmp, x.path); err != nil { return err }
+
+	x.f, err = os.OpenFile(x.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	x.appended = 0
+	return err
+}
@@ -0,0 +1,62 @@
+package dedupe
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordsSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.log")
+	x, err := Open(path, 10, time.Hour)
+	if err != nil { t.Fatal(err) }
+	if _, seen, err := x.Begin("a"); seen || err != nil { t.Fatalf("first Begin = %v, %v", seen, err) }
+	if _, _, err := x.Begin("a"); !errors.Is(err, ErrInFlight) { t.Fatalf("second Begin err = %v, want ErrInFlight", err) }
+	if err := x.Finish(Record{Key: "a", Fingerprint: "fp", Status: 201, Body: "ok"}); err != nil { t.Fatal(err) }
+	x.Close()
+
+	x, err = Open(path, 10, time.Hour)
+	if err != nil { t.Fatal(err) }
+	defer x.Close()
+	r, seen, err := x.Begin("a")
+	if !seen || err != nil || r.Status != 201 || r.Body != "ok" || r.Fingerprint != "fp" { t.Fatalf("after reopen: %+v %v %v", r, seen, err) }
+}
+
+func TestAbortFreesKey(t *testing.T) {
+	x, err := Open(filepath.Join(t.TempDir(), "dedupe.log"), 10, time.Hour)
+	if err != nil { t.Fatal(err) }
+	defer x.Close()
+	x.Begin("a")
+	x.Abort("a")
+	if _, seen, err := x.Begin("a"); seen || err != nil { t.Fatalf("Begin after Abort = %v, %v", seen, err) }
+}
+
+func TestWindowAndMax(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.log")
+	x, err := Open(path, 3, time.Hour)
+	if err != nil { t.Fatal(err) }
+	old := time.Now().Add(-2 * time.Hour)
+	x.Finish(Record{Key: "old", Status: 201, At: old})
+	for _, k := range []string{"a", "b", "c", "d"} {
+		x.Begin(k)
+		if err := x.Finish(Record{Key: k, Status: 201}); err != nil { t.Fatal(err) }
+	}
+	if _, seen, _ := x.Begin("old"); seen { t.Error("record past the window still replayed") }
+	x.Abort("old")
+	if n := x.Len(); n > 3 { t.Errorf("Len = %d, want at most 3", n) }
+	x.Close()
+
+	x, err = Open(path, 3, time.Hour)
+	if err != nil { t.Fatal(err) }
+	defer x.Close()
+	if _, seen, _ := x.Begin("d"); !seen { t.Error("newest record lost on reopen") }
+	if _, seen, _ := x.Begin("a"); seen { t.Error("oldest record kept past max") }
+}
+
+func TestFingerprintIgnoresWhitespace(t *testing.T) {
+	a := Fingerprint("id", "k", 1, []byte(`{"n": 1}`))
+	if b := Fingerprint("id", "k", 1, []byte(`{"n":1}`)); a != b { t.Error("formatting changed the fingerprint") }
+	if b := Fingerprint("id", "k", 1, []byte(`{"n":2}`)); a == b { t.Error("different values, same fingerprint") }
+	if b := Fingerprint("id", "k", 2, []byte(`{"n":1}`)); a == b { t.Error("different ts, same fingerprint") }
+}
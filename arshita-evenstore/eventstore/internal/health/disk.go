@@ -0,0 +1,19 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MinFreeDisk fails once the filesystem holding dir has fewer than min bytes
+// available. Where free space cannot be read the check always passes.
+func MinFreeDisk(dir string, min uint64) Check {
+	return func(context.Context) error {
+		free, err := diskFree(dir)
+		if errors.Is(err, errors.ErrUnsupported) { return nil }
+		if err != nil { return err }
+		if free < min { return fmt.Errorf("%d bytes free in %s, want at least %d", free, dir, min) }
+		return nil
+	}
+}
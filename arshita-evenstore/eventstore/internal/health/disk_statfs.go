@@ -0,0 +1,12 @@
+//go:build linux || darwin || freebsd
+
+package health
+
+import "syscall"
+
+// diskFree is the space available to unprivileged users on dir's filesystem.
+func diskFree(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil { return 0, err }
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,146 @@
+// Package health keeps the checks behind /livez and /readyz and reports
+// them as a JSON breakdown.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check returns nil when the component is healthy.
+type Check func(ctx context.Context) error
+
+// Level says what a failing check takes down.
+type Level int
+
+const (
+	// Live checks fail both /livez and /readyz: the process should be restarted.
+	Live Level = iota
+	// Ready checks fail /readyz: stop routing traffic here until they pass.
+	Ready
+	// Degraded checks are reported by /readyz but only mark it degraded,
+	// for dependencies the server keeps working without (e.g. Kafka).
+	Degraded
+)
+
+// Timeout bounds each check run by a probe.
+const Timeout = 2 * time.Second
+
+type entry struct {
+	check Check
+	level Level
+}
+
+// Registry is a named set of checks, safe for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	checks map[string]entry
+}
+
+func New() *Registry { return &Registry{checks: map[string]entry{}} }
+
+// Register adds or replaces the check called name.
+func (r *Registry) Register(name string, level Level, c Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = entry{c, level}
+}
+
+// Remove drops the check called name, if any.
+func (r *Registry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.checks, name)
+}
+
+// Result is one check's outcome.
+type Result struct {
+	Status     string  `json:"status"` // ok, degraded or fail
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Report is the body served by the probes.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Run executes the checks up to maxLevel concurrently.
+func (r *Registry) Run(ctx context.Context, maxLevel Level) Report {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.checks))
+	for n, e := range r.checks {
+		if e.level <= maxLevel { names = append(names, n) }
+	}
+	sort.Strings(names)
+	entries := make([]entry, len(names))
+	for i, n := range names { entries[i] = r.checks[n] }
+	r.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			err := run(ctx, entries[i].check)
+			res := Result{Status: "ok", DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				res.Status, res.Error = "fail", err.Error()
+				if entries[i].level == Degraded { res.Status = "degraded" }
+			}
+			results[i] = res
+		}(i)
+	}
+	wg.Wait()
+
+	rep := Report{Status: "ok", Checks: make(map[string]Result, len(names))}
+	for i, n := range names {
+		rep.Checks[n] = results[i]
+		switch {
+		case results[i].Status == "fail":
+			rep.Status = "fail"
+		case results[i].Status == "degraded" && rep.Status == "ok":
+			rep.Status = "degraded"
+		}
+	}
+	return rep
+}
+
+// run gives up on a check that ignores ctx once the deadline passes.
+func run(ctx context.Context, c Check) error {
+	done := make(chan error, 1)
+	go func() { done <- c(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LiveHandler serves /livez: Live checks only.
+func (r *Registry) LiveHandler() http.Handler { return r.handler(Live) }
+
+// ReadyHandler serves /readyz: every check. A degraded report is still 200.
+func (r *Registry) ReadyHandler() http.Handler { return r.handler(Degraded) }
+
+func (r *Registry) handler(maxLevel Level) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rep := r.Run(req.Context(), maxLevel)
+		code := http.StatusOK
+		if rep.Status == "fail" { code = http.StatusServiceUnavailable }
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(rep)
+	})
+}
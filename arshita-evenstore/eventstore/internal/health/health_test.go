@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+
+func serve(t *testing.T, r *Registry, live bool) (int, Report) {
+	t.Helper()
+	h := r.ReadyHandler()
+	if live { h = r.LiveHandler() }
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var rep Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil { t.Fatal(err) }
+	return rec.Code, rep
+}
+
+func TestLevels(t *testing.T) {
+	r := New()
+	ok := func(context.Context) error { return nil }
+	down := func(context.Context) error { return errors.New("down") }
+	r.Register("wal", Live, ok)
+	r.Register("recovery", Ready, ok)
+	r.Register("kafka", Degraded, down)
+
+	if code, rep := serve(t, r, false); code != 200 || rep.Status != "degraded" || rep.Checks["kafka"].Status != "degraded" || rep.Checks["kafka"].Error != "down" { t.Errorf("ready with kafka down: %d %+v", code, rep) }
+	if code, rep := serve(t, r, true); code != 200 || rep.Status != "ok" || len(rep.Checks) != 1 { t.Errorf("live: %d %+v", code, rep) }
+
+	r.Register("recovery", Ready, down)
+	if code, rep := serve(t, r, false); code != 503 || rep.Status != "fail" { t.Errorf("ready while recovering: %d %+v", code, rep) }
+	if code, _ := serve(t, r, true); code != 200 { t.Errorf("live while recovering: %d", code) }
+
+	r.Register("wal", Live, down)
+	if code, rep := serve(t, r, true); code != 503 || rep.Checks["wal"].Status != "fail" { t.Errorf("live with wal down: %d %+v", code, rep) }
+	r.Remove("wal")
+	r.Remove("recovery")
+	if _, rep := serve(t, r, false); len(rep.Checks) != 1 { t.Errorf("after Remove: %+v", rep) }
+}
+
+func TestStuckCheckTimesOut(t *testing.T) {
+	r := New()
+	block := make(chan struct{})
+	defer close(block)
+	r.Register("stuck", Ready, func(context.Context) error { <-block; return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	rep := r.Run(ctx, Ready)
+	if rep.Status != "fail" || time.Since(start) > Timeout { t.Errorf("stuck check: %+v after %v", rep, time.Since(start)) }
+}
+
+func TestMinFreeDisk(t *testing.T) {
+	dir := t.TempDir()
+	if err := MinFreeDisk(dir, 0)(context.Background()); err != nil { t.Errorf("min 0: %v", err) }
+	if _, err := diskFree(dir); errors.Is(err, errors.ErrUnsupported) { t.Skip("free space not readable here") }
+	if err := MinFreeDisk(dir, math.MaxUint64)(context.Background()); err == nil { t.Error("no filesystem has MaxUint64 bytes free") }
+}
@@ -4,15 +4,33 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 
+	"eventstore/internal/dedupe"
+	"eventstore/internal/metrics"
 	"eventstore/internal/store"
+	"eventstore/internal/trace"
 )
 
+var (
+	published       = metrics.NewCounter("eventstore_kafka_published_total", "Messages published, by topic and result.", "topic", "result")
+	publishDuration = metrics.NewHistogram("eventstore_kafka_publish_duration_seconds", "Latency of Kafka writes.", nil, "topic")
+	consumed        = metrics.NewCounter("eventstore_kafka_consumed_total", "Messages consumed, by topic and result (ok, retry, invalid, rejected, duplicate).", "topic", "result")
+)
+
+func result(err error) string {
+	if err != nil { return "error" }
+	return "ok"
+}
+
 type ProducerConfig struct {
 	BrokersCSV string
 	Topic      string
@@ -36,26 +54,109 @@ func NewProducer(cfg ProducerConfig) (*Producer, error) {
 	return &Producer{w: w}, nil
 }
 
-func (p *Producer) Publish(ctx context.Context, payload []byte) error {
-	return p.w.WriteMessages(ctx, kafka.Message{Value: payload})
+// Message is a payload to publish with the traceparent of the operation that
+// produced it, if any.
+type Message struct {
+	Value       []byte
+	Traceparent string
+}
+
+// PublishBatch sends all msgs in a single WriteMessages call. A message
+// with a traceparent gets its own producer span in that trace, and carries
+// that span in its header; the others carry the batch span.
+func (p *Producer) PublishBatch(ctx context.Context, msgs []Message) (err error) {
+	ctx, span := trace.Start(ctx, "kafka.PublishBatch", trace.KindProducer)
+	span.SetAttr("topic", p.w.Topic)
+	span.SetAttr("messages", len(msgs))
+	defer func() { span.SetError(err); span.End() }()
+	hdrs := traceHeaders(span)
+	out := make([]kafka.Message, len(msgs))
+	var spans []*trace.Span
+	for i, m := range msgs {
+		out[i] = kafka.Message{Value: m.Value, Headers: hdrs}
+		sc, ok := trace.ParseTraceparent(m.Traceparent)
+		if !ok { continue }
+		_, ms := trace.Start(trace.ContextWith(context.Background(), sc), "kafka.Publish", trace.KindProducer)
+		ms.SetAttr("topic", p.w.Topic)
+		ms.SetAttr("batch_trace", span.Context().TraceID.String())
+		out[i].Headers = traceHeaders(ms)
+		spans = append(spans, ms)
+	}
+	defer func() {
+		for _, ms := range spans { ms.SetError(err); ms.End() }
+	}()
+	start := time.Now()
+	err = p.w.WriteMessages(ctx, out...)
+	publishDuration.With(p.w.Topic).Observe(time.Since(start).Seconds())
+	published.With(p.w.Topic, result(err)).Add(float64(len(out)))
+	return err
 }
 
 func (p *Producer) Close() error { return p.w.Close() }
 
+// traceHeaders carries span's context to consumers as a traceparent header.
+func traceHeaders(span *trace.Span) []kafka.Header {
+	return []kafka.Header{{Key: "traceparent", Value: []byte(span.Context().Traceparent())}}
+}
+
+// traceContext continues the trace found in m's traceparent header, if any.
+func traceContext(m kafka.Message) context.Context {
+	ctx := context.Background()
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Key, "traceparent") {
+			if sc, ok := trace.ParseTraceparent(string(h.Value)); ok { ctx = trace.ContextWith(ctx, sc) }
+		}
+	}
+	return ctx
+}
+
 type ConsumerConfig struct {
 	BrokersCSV string
 	Topic      string
 	GroupID    string
+	// Reject, if set, receives every message that is dead-lettered: not
+	// valid event JSON, failed by handle with a Permanent error or
+	// store.ErrInvalidEvent, or reusing an idempotency key with a different
+	// payload. Other failures are retried, never dead-lettered.
+	Reject func(payload []byte, err error)
+	// DeadLetterTopic, if set, receives those messages too, with dlq-*
+	// headers describing the failure. A message is committed only once it
+	// is there.
+	DeadLetterTopic string
+	MaxAttempts     int           // failed calls to handle before Check reports the consumer paused; default 5
+	Backoff         time.Duration // first retry delay, doubled per attempt; default 500ms
+	// Dedupe, if set, drops messages whose "id" field or Idempotency-Key
+	// header was already ingested (over HTTP or Kafka).
+	Dedupe *dedupe.Index
 }
 
+const maxBackoff = 30 * time.Second
+
 type Consumer struct {
-	r *kafka.Reader
+	topic       string
+	r           *kafka.Reader
+	dlq         *kafka.Writer // nil without a dead-letter topic
+	reject      func(payload []byte, err error)
+	dedupe      *dedupe.Index
+	maxAttempts int
+	backoff     time.Duration
+
+	ctx    context.Context // done once closed
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	lastErr error // last broker error, cleared by a successful read
+	errAt   time.Time
 }
 
 func NewConsumer(cfg ConsumerConfig) (*Consumer, error) {
 	brs := splitCSV(cfg.BrokersCSV)
 	if len(brs) == 0 { return nil, errors.New("no brokers") }
-	r := kafka.NewReader(kafka.ReaderConfig{
+	c := &Consumer{topic: cfg.Topic, reject: cfg.Reject, dedupe: cfg.Dedupe, maxAttempts: cfg.MaxAttempts, backoff: cfg.Backoff}
+	if c.maxAttempts < 1 { c.maxAttempts = 5 }
+	if c.backoff <= 0 { c.backoff = 500 * time.Millisecond }
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.r = kafka.NewReader(kafka.ReaderConfig{
 		Brokers:   brs,
 		Topic:     cfg.Topic,
 		GroupID:   cfg.GroupID,
@@ -63,32 +164,245 @@ func NewConsumer(cfg ConsumerConfig) (*Consumer, error) {
 		MaxBytes:  10e6,
 		MaxWait:   500 * time.Millisecond,
 		StartOffset: kafka.LastOffset,
+		// the group reader retries connection errors internally; note them for Check
+		ErrorLogger: kafka.LoggerFunc(func(msg string, args ...any) { c.noteErr(fmt.Errorf(msg, args...)) }),
 	})
-	return &Consumer{r: r}, nil
+	if cfg.DeadLetterTopic != "" {
+		c.dlq = &kafka.Writer{
+			Addr:         kafka.TCP(brs...),
+			Topic:        cfg.DeadLetterTopic,
+			Balancer:     &kafka.Hash{}, // keeps a key's failures in order
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+		}
+	}
+	return c, nil
 }
 
-func (c *Consumer) Consume(handle func(store.Event) error) {
+func (c *Consumer) noteErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr, c.errAt = err, time.Now()
+}
+
+// Check reports the consumer as disconnected if reading from the brokers
+// failed within the last 30s and nothing has been read since.
+func (c *Consumer) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastErr != nil && time.Since(c.errAt) < 30*time.Second { return c.lastErr }
+	return nil
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handle error that retrying cannot fix: the message is
+// dead-lettered at once.
+func Permanent(err error) error { return permanentError{err} }
+
+func permanent(err error) bool {
+	return errors.As(err, new(permanentError)) || errors.Is(err, store.ErrInvalidEvent)
+}
+
+// Consume hands each message to handle with a context carrying the
+// producer's trace, until the consumer is closed. A message's offset is
+// committed once it is stored, skipped as a duplicate or dead-lettered, so
+// after a crash or Close anything not yet settled is delivered again.
+func (c *Consumer) Consume(handle func(context.Context, store.Event) error) {
 	for {
-		m, err := c.r.ReadMessage(context.Background())
+		m, err := c.r.FetchMessage(c.ctx)
+		if errors.Is(err, io.EOF) || c.ctx.Err() != nil {
+			return // reader closed
+		}
 		if err != nil {
-			log.Printf("kafka read: %v", err)
+			slog.Error("kafka read failed", "topic", c.topic, "err", err)
+			c.noteErr(err)
 			time.Sleep(time.Second)
 			continue
 		}
-		var dto struct {
-			Key   string          `json:"key"`
-			TS    int64           `json:"ts"`
-			Value json.RawMessage `json:"value"`
+		c.noteErr(nil)
+		if !c.process(m, handle) {
+			return // closed before m was settled
 		}
-		if err := json.Unmarshal(m.Value, &dto); err != nil {
-			log.Printf("kafka bad json: %v", err)
-			continue
+		if err := c.r.CommitMessages(c.ctx, m); err != nil && c.ctx.Err() == nil {
+			slog.Error("kafka commit failed", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "err", err)
+			c.noteErr(err)
 		}
-		_ = handle(store.Event{Key: dto.Key, TS: dto.TS, Value: []byte(dto.Value)})
 	}
 }
 
-func (c *Consumer) Close() error { return c.r.Close() }
+// errKeyReused dead-letters a message whose idempotency key was already
+// stored with a different payload.
+var errKeyReused = errors.New("idempotency key reused with a different payload")
+
+// stored is what POST /events answers for a stored event; it is recorded
+// under the message's idempotency key so an HTTP retry replays it.
+func stored(key, fp string) dedupe.Record {
+	return dedupe.Record{Key: key, Fingerprint: fp, Status: 201, Header: map[string]string{"Content-Type": "application/json"}, Body: `{"ok":true}`}
+}
+
+// process stores m, or dead-letters it if it is poison: not an event, or
+// rejected by handle. Any other failure, such as a read-only store, is not
+// the message's fault, so it is retried until it clears and the consumer
+// pauses on m meanwhile. It reports false if the consumer was closed first.
+func (c *Consumer) process(m kafka.Message, handle func(context.Context, store.Event) error) bool {
+	var dto struct {
+		ID    string          `json:"id"`
+		Key   string          `json:"key"`
+		TS    int64           `json:"ts"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(m.Value, &dto); err != nil {
+		slog.Warn("kafka message is not valid JSON", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "err", err)
+		consumed.With(c.topic, "invalid").Inc()
+		return c.deadLetter(context.Background(), m, "invalid", 0, err)
+	}
+	idem := idempotencyKey(m, dto.ID)
+	idempotent := idem != "" && c.dedupe != nil
+	fp := ""
+	if idempotent {
+		fp = dedupe.Fingerprint(dto.ID, dto.Key, dto.TS, dto.Value)
+		rec, seen, ok := c.reserve(idem)
+		if !ok {
+			return false
+		}
+		if seen && rec.Fingerprint != "" && rec.Fingerprint != fp {
+			consumed.With(c.topic, "rejected").Inc()
+			return c.deadLetter(context.Background(), m, "rejected", 0, errKeyReused)
+		}
+		if seen {
+			consumed.With(c.topic, "duplicate").Inc()
+			return true // already stored, over HTTP or Kafka
+		}
+	}
+	ctx, span := trace.Start(traceContext(m), "kafka.Consume", trace.KindConsumer)
+	span.SetAttr("topic", m.Topic)
+	span.SetAttr("partition", m.Partition)
+	span.SetAttr("offset", m.Offset)
+	span.SetAttr("key", dto.Key)
+	defer span.End()
+	e := store.Event{Key: dto.Key, TS: dto.TS, Value: []byte(dto.Value)}
+	var err error
+	attempts, paused := 1, false
+	for delay := c.backoff; ; attempts, delay = attempts+1, min(delay*2, maxBackoff) {
+		err = handle(ctx, e)
+		if err == nil || permanent(err) {
+			break
+		}
+		consumed.With(c.topic, "retry").Inc()
+		if attempts >= c.maxAttempts || errors.Is(err, store.ErrReadOnly) {
+			paused = true
+			slog.ErrorContext(ctx, "kafka consumer paused, store write failing", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "key", dto.Key, "attempt", attempts, "retry_in", delay, "err", err)
+			c.noteErr(fmt.Errorf("paused at %s/%d offset %d: %w", m.Topic, m.Partition, m.Offset, err))
+		} else {
+			slog.WarnContext(ctx, "kafka message failed, retrying", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "key", dto.Key, "attempt", attempts, "retry_in", delay, "err", err)
+		}
+		if !sleep(c.ctx, delay) {
+			break
+		}
+	}
+	span.SetError(err)
+	if paused && err == nil {
+		c.noteErr(nil)
+	}
+	if err == nil {
+		consumed.With(c.topic, "ok").Inc()
+		if idempotent {
+			if err := c.dedupe.Finish(stored(idem, fp)); err != nil {
+				slog.ErrorContext(ctx, "kafka dedupe record failed", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "key", dto.Key, "err", err)
+			}
+		}
+		return true
+	}
+	if idempotent {
+		c.dedupe.Abort(idem)
+	}
+	if !permanent(err) {
+		return false // closed while paused
+	}
+	consumed.With(c.topic, "rejected").Inc()
+	return c.deadLetter(ctx, m, "rejected", attempts, err)
+}
+
+// reserve takes key in the dedupe index, waiting while a request holding
+// it is in flight, and returns the stored record if key was ingested
+// already. It reports false if the consumer was closed first.
+func (c *Consumer) reserve(key string) (dedupe.Record, bool, bool) {
+	for delay := c.backoff; ; delay = min(delay*2, maxBackoff) {
+		rec, seen, err := c.dedupe.Begin(key)
+		if !errors.Is(err, dedupe.ErrInFlight) {
+			return rec, seen, true
+		}
+		if !sleep(c.ctx, delay) {
+			return dedupe.Record{}, false, false
+		}
+	}
+}
+
+// deadLetter hands m to Reject and, if set, the dead-letter topic, which
+// it retries until the write succeeds or the consumer is closed.
+func (c *Consumer) deadLetter(ctx context.Context, m kafka.Message, reason string, attempts int, cause error) bool {
+	slog.WarnContext(ctx, "kafka message dead-lettered", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "reason", reason, "attempts", attempts, "err", cause)
+	if c.reject != nil {
+		c.reject(m.Value, cause)
+	}
+	if c.dlq == nil {
+		return true
+	}
+	dl := kafka.Message{Key: m.Key, Value: m.Value, Headers: append(append([]kafka.Header(nil), m.Headers...),
+		kafka.Header{Key: "dlq-error", Value: []byte(cause.Error())},
+		kafka.Header{Key: "dlq-reason", Value: []byte(reason)},
+		kafka.Header{Key: "dlq-attempts", Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: "dlq-source-topic", Value: []byte(m.Topic)},
+		kafka.Header{Key: "dlq-source-partition", Value: []byte(strconv.Itoa(m.Partition))},
+		kafka.Header{Key: "dlq-source-offset", Value: []byte(strconv.FormatInt(m.Offset, 10))},
+		kafka.Header{Key: "dlq-failed-at", Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)}
+	for backoff := c.backoff; ; backoff = min(backoff*2, maxBackoff) {
+		err := c.dlq.WriteMessages(c.ctx, dl)
+		published.With(c.dlq.Topic, result(err)).Inc()
+		if err == nil {
+			return true
+		}
+		if c.ctx.Err() != nil {
+			return false
+		}
+		slog.Error("kafka dead-letter publish failed, retrying", "topic", c.dlq.Topic, "source_topic", m.Topic, "offset", m.Offset, "retry_in", backoff, "err", err)
+		c.noteErr(err)
+		if !sleep(c.ctx, backoff) {
+			return false
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+func idempotencyKey(m kafka.Message, id string) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Key, "Idempotency-Key") && len(h.Value) > 0 { return string(h.Value) }
+	}
+	return id
+}
+
+// Close stops Consume, leaving the message in hand uncommitted.
+func (c *Consumer) Close() error {
+	c.cancel()
+	err := c.r.Close()
+	if c.dlq != nil { err = errors.Join(err, c.dlq.Close()) }
+	return err
+}
 
 func splitCSV(s string) []string {
 	parts := strings.Split(s, ",")
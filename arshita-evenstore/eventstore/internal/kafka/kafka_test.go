@@ -0,0 +1,181 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"eventstore/internal/dedupe"
+	"eventstore/internal/store"
+	"eventstore/internal/trace"
+)
+
+// testConsumer is a Consumer without a reader: tests drive process directly.
+type testConsumer struct {
+	*Consumer
+	mu       sync.Mutex
+	rejected []error
+}
+
+func newTestConsumer(t *testing.T) *testConsumer {
+	t.Helper()
+	idx, err := dedupe.Open(filepath.Join(t.TempDir(), "dedupe.log"), 0, 0)
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { idx.Close() })
+	tc := &testConsumer{}
+	tc.Consumer = &Consumer{topic: "in", dedupe: idx, maxAttempts: 2, backoff: time.Millisecond,
+		reject: func(_ []byte, err error) { tc.mu.Lock(); tc.rejected = append(tc.rejected, err); tc.mu.Unlock() }}
+	tc.ctx, tc.cancel = context.WithCancel(context.Background())
+	t.Cleanup(tc.cancel)
+	return tc
+}
+
+func (tc *testConsumer) rejects() []error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return append([]error(nil), tc.rejected...)
+}
+
+func message(v string) kafka.Message { return kafka.Message{Topic: "in", Value: []byte(v)} }
+
+const event = `{"id":"e1","key":"k","ts":1,"value":{"n":1}}`
+
+func TestProcessDeadLettersPoisonOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  string
+		err  error
+	}{
+		{"not json", `{`, nil},
+		{"permanent", event, Permanent(errors.New("schema"))},
+		{"invalid event", event, store.ErrInvalidEvent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConsumer(t)
+			calls := 0
+			ok := c.process(message(tc.msg), func(context.Context, store.Event) error { calls++; return tc.err })
+			if !ok { t.Fatal("poison message not settled") }
+			if got := len(c.rejects()); got != 1 { t.Fatalf("rejected %d times, want 1", got) }
+			if tc.err != nil && calls != 1 { t.Errorf("handle called %d times, want 1", calls) }
+			// the key is free again: a fixed message can still go through
+			if _, seen, err := c.dedupe.Begin("e1"); seen || err != nil { t.Errorf("Begin after reject = %v, %v", seen, err) }
+		})
+	}
+}
+
+// A failing store pauses the consumer on the message: it is neither
+// committed nor dead-lettered, and goes through once the store recovers.
+func TestProcessPausesOnStoreErrors(t *testing.T) {
+	c := newTestConsumer(t)
+	var mu sync.Mutex
+	fail := true
+	calls := 0
+	handle := func(context.Context, store.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if fail { return store.ErrReadOnly }
+		return nil
+	}
+	done := make(chan bool, 1)
+	go func() { done <- c.process(message(event), handle) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Check() == nil {
+		if time.Now().After(deadline) { t.Fatal("Check did not report the pause") }
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("process settled the message while the store was read-only")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	select {
+	case ok := <-done:
+		if !ok { t.Fatal("process reported closed") }
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not resume")
+	}
+	if n := len(c.rejects()); n != 0 { t.Errorf("dead-lettered %d messages, want 0", n) }
+	if err := c.Check(); err != nil { t.Errorf("Check after recovery = %v", err) }
+	if calls < 2 { t.Errorf("handle called %d times", calls) }
+}
+
+func TestProcessClosedWhilePaused(t *testing.T) {
+	c := newTestConsumer(t)
+	done := make(chan bool, 1)
+	go func() { done <- c.process(message(event), func(context.Context, store.Event) error { return errors.New("disk full") }) }()
+	time.Sleep(20 * time.Millisecond)
+	c.cancel()
+	if ok := <-done; ok { t.Fatal("process settled a message it never stored") }
+	if n := len(c.rejects()); n != 0 { t.Errorf("dead-lettered %d messages, want 0", n) }
+	if _, seen, err := c.dedupe.Begin("e1"); seen || err != nil { t.Errorf("key still held: %v, %v", seen, err) }
+}
+
+// A key held by an HTTP request in flight is waited on, not skipped.
+func TestProcessWaitsForInFlightKey(t *testing.T) {
+	c := newTestConsumer(t)
+	if _, _, err := c.dedupe.Begin("e1"); err != nil { t.Fatal(err) }
+	calls := 0
+	done := make(chan bool, 1)
+	go func() { done <- c.process(message(event), func(context.Context, store.Event) error { calls++; return nil }) }()
+	select {
+	case <-done:
+		t.Fatal("process settled the message while its key was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.dedupe.Abort("e1") // the HTTP request failed
+	if ok := <-done; !ok { t.Fatal("process reported closed") }
+	if calls != 1 { t.Errorf("handle called %d times, want 1", calls) }
+}
+
+func TestProcessRecordsResponseAndFingerprint(t *testing.T) {
+	c := newTestConsumer(t)
+	if !c.process(message(event), func(context.Context, store.Event) error { return nil }) { t.Fatal("not settled") }
+	rec, seen, _ := c.dedupe.Begin("e1")
+	if !seen { t.Fatal("key not recorded") }
+	want := dedupe.Fingerprint("e1", "k", 1, []byte(`{"n": 1}`))
+	if rec.Fingerprint != want { t.Errorf("fingerprint %q, want %q", rec.Fingerprint, want) }
+	if rec.Status != 201 || rec.Header["Content-Type"] != "application/json" || rec.Body != `{"ok":true}` {
+		t.Errorf("record = %+v", rec)
+	}
+
+	// a replay is skipped; the same id with another payload is poison
+	calls := 0
+	handle := func(context.Context, store.Event) error { calls++; return nil }
+	if !c.process(message(event), handle) || calls != 0 { t.Errorf("replay stored again") }
+	if n := len(c.rejects()); n != 0 { t.Fatalf("replay dead-lettered") }
+	if !c.process(message(`{"id":"e1","key":"k","ts":1,"value":{"n":2}}`), handle) || calls != 0 { t.Errorf("reused id stored") }
+	if errs := c.rejects(); len(errs) != 1 || !errors.Is(errs[0], errKeyReused) { t.Errorf("rejects = %v", errs) }
+}
+
+func TestProcessContinuesProducerTrace(t *testing.T) {
+	c := newTestConsumer(t)
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	m := message(event)
+	m.Headers = []kafka.Header{{Key: "traceparent", Value: []byte(tp)}}
+	var got trace.SpanContext
+	c.process(m, func(ctx context.Context, _ store.Event) error { got, _ = trace.FromContext(ctx); return nil })
+	if got.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || got.SpanID.String() == "00f067aa0ba902b7" { t.Errorf("handle ran in %+v, want a child of %s", got, tp) }
+}
+
+func TestTraceHeadersReachTheConsumer(t *testing.T) {
+	_, span := trace.Start(context.Background(), "publish", trace.KindProducer)
+	defer span.End()
+	m := kafka.Message{Headers: append([]kafka.Header{{Key: "other", Value: []byte("x")}}, traceHeaders(span)...)}
+	got, ok := trace.FromContext(traceContext(m))
+	if !ok || got.TraceID != span.Context().TraceID || got.SpanID != span.Context().SpanID {
+		t.Fatalf("consumer context %+v %v, want %+v", got, ok, span.Context())
+	}
+
+	m.Headers = []kafka.Header{{Key: "Traceparent", Value: []byte("garbage")}}
+	if _, ok := trace.FromContext(traceContext(m)); ok { t.Error("invalid traceparent continued") }
+}
@@ -0,0 +1,64 @@
+// Package logging configures log/slog for the server and carries the request
+// ID through contexts, so every record logged with a request's context is
+// tagged with request_id (and trace_id when the request is traced).
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"eventstore/internal/trace"
+)
+
+// New returns a logger writing format ("json" or "text") to w. Records
+// below level are dropped; level can be changed while the logger is in use.
+func New(w io.Writer, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text", "":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log format %q: want json or text", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// ParseLevel reads "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(s)); err != nil { return 0, fmt.Errorf("log level %q: want debug, info, warn or error", s) }
+	return lv, nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID is the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds request_id and trace_id from the record's context.
+type contextHandler struct{ slog.Handler }
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := RequestID(ctx); id != "" { r.AddAttrs(slog.String("request_id", id)) }
+		if sc, ok := trace.FromContext(ctx); ok { r.AddAttrs(slog.String("trace_id", sc.TraceID.String())) }
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(as []slog.Attr) slog.Handler { return contextHandler{h.Handler.WithAttrs(as)} }
+func (h contextHandler) WithGroup(name string) slog.Handler    { return contextHandler{h.Handler.WithGroup(name)} }
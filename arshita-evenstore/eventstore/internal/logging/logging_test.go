@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"eventstore/internal/trace"
+)
+
+func TestContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	var lv slog.LevelVar
+	log, err := New(&buf, "json", &lv)
+	if err != nil { t.Fatal(err) }
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx, span := trace.Start(ctx, "op", trace.KindInternal)
+	log.With("component", "test").InfoContext(ctx, "hello", "key", "k")
+	log.DebugContext(ctx, "dropped")
+
+	var rec map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil { t.Fatalf("%q: %v", buf.String(), err) }
+	for k, want := range map[string]string{"msg": "hello", "request_id": "req-1", "trace_id": span.Context().TraceID.String(), "key": "k", "component": "test"} {
+		if rec[k] != want { t.Errorf("%s = %v, want %s", k, rec[k], want) }
+	}
+
+	buf.Reset()
+	lv.Set(slog.LevelDebug)
+	log.Debug("now kept")
+	if !strings.Contains(buf.String(), "now kept") || strings.Contains(buf.String(), "request_id") { t.Errorf("debug without context: %q", buf.String()) }
+}
+
+func TestFormatsAndLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := New(&buf, "text", new(slog.LevelVar))
+	if err != nil { t.Fatal(err) }
+	log.InfoContext(WithRequestID(context.Background(), "r"), "hi")
+	if !strings.Contains(buf.String(), "msg=hi request_id=r") { t.Errorf("text: %q", buf.String()) }
+	if _, err := New(&buf, "xml", nil); err == nil { t.Error("xml format accepted") }
+
+	if lv, err := ParseLevel("warn"); err != nil || lv != slog.LevelWarn { t.Errorf("warn: %v %v", lv, err) }
+	if _, err := ParseLevel("loud"); err == nil { t.Error("loud accepted") }
+}
@@ -0,0 +1,265 @@
+// Package metrics is a small Prometheus client: counters, gauges and
+// histograms with labels, rendered in the text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets suit request and storage latencies in seconds.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu     sync.Mutex
+	fams   []*family
+	byName map[string]*family
+}
+
+func NewRegistry() *Registry { return &Registry{byName: map[string]*family{}} }
+
+// Default is the registry the package-level constructors register in.
+var Default = NewRegistry()
+
+type family struct {
+	name, help, typ string
+	labels          []string
+	buckets         []float64
+	fn              func(emit func(v float64, labelValues ...string)) // gauge funcs
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	val         atomicFloat
+	counts      []atomic.Uint64 // per bucket, not cumulative; last is +Inf
+	sum         atomicFloat
+	count       atomic.Uint64
+}
+
+// register returns the existing family of that name, so constructors may be
+// called more than once with the same definition.
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.byName[f.name]; ok {
+		if old.typ != f.typ { panic("metrics: " + f.name + " registered as " + old.typ) }
+		return old
+	}
+	f.series = map[string]*series{}
+	r.byName[f.name] = f
+	r.fams = append(r.fams, f)
+	return f
+}
+
+func (f *family) with(lvs []string) *series {
+	if len(lvs) != len(f.labels) { panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(lvs))) }
+	k := strings.Join(lvs, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[k]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), lvs...)}
+		if f.typ == "histogram" { s.counts = make([]atomic.Uint64, len(f.buckets)+1) }
+		f.series[k] = s
+	}
+	return s
+}
+
+// Counter only goes up.
+type Counter struct{ s *series }
+
+func (c Counter) Inc()          { c.s.val.add(1) }
+func (c Counter) Add(v float64) { c.s.val.add(v) }
+
+type CounterVec struct{ f *family }
+
+func (v CounterVec) With(labelValues ...string) Counter { return Counter{v.f.with(labelValues)} }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) CounterVec {
+	return CounterVec{r.register(&family{name: name, help: help, typ: "counter", labels: labels})}
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ s *series }
+
+func (g Gauge) Set(v float64) { g.s.val.set(v) }
+func (g Gauge) Add(v float64) { g.s.val.add(v) }
+
+type GaugeVec struct{ f *family }
+
+func (v GaugeVec) With(labelValues ...string) Gauge { return Gauge{v.f.with(labelValues)} }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) GaugeVec {
+	return GaugeVec{r.register(&family{name: name, help: help, typ: "gauge", labels: labels})}
+}
+
+// NewGaugeFunc registers a gauge whose series are produced by fn at scrape
+// time, for values cheaper to read on demand than to keep up to date.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, fn func(emit func(v float64, labelValues ...string))) {
+	r.register(&family{name: name, help: help, typ: "gauge", labels: labels, fn: fn})
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (h Histogram) Observe(v float64) {
+	h.s.counts[sort.SearchFloat64s(h.buckets, v)].Add(1)
+	h.s.sum.add(v)
+	h.s.count.Add(1)
+}
+
+type HistogramVec struct{ f *family }
+
+func (v HistogramVec) With(labelValues ...string) Histogram {
+	return Histogram{s: v.f.with(labelValues), buckets: v.f.buckets}
+}
+
+// NewHistogram registers a histogram; nil buckets means DefBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) HistogramVec {
+	if buckets == nil { buckets = DefBuckets }
+	return HistogramVec{r.register(&family{name: name, help: help, typ: "histogram", labels: labels, buckets: buckets})}
+}
+
+// Package-level constructors register in Default.
+func NewCounter(name, help string, labels ...string) CounterVec { return Default.NewCounter(name, help, labels...) }
+func NewGauge(name, help string, labels ...string) GaugeVec     { return Default.NewGauge(name, help, labels...) }
+func NewHistogram(name, help string, buckets []float64, labels ...string) HistogramVec {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+func NewGaugeFunc(name, help string, labels []string, fn func(emit func(v float64, labelValues ...string))) {
+	Default.NewGaugeFunc(name, help, labels, fn)
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler { return Default }
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo renders every family in the text exposition format.
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	r.mu.Lock()
+	fams := append([]*family(nil), r.fams...)
+	r.mu.Unlock()
+
+	w := &countWriter{w: bufio.NewWriter(out)}
+	for _, f := range fams {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+		if f.fn != nil {
+			f.fn(func(v float64, lvs ...string) {
+				fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, lvs, "", ""), formatFloat(v))
+			})
+			continue
+		}
+		for _, s := range f.sorted() {
+			switch f.typ {
+			case "histogram":
+				var cum uint64
+				for i, b := range f.buckets {
+					cum += s.counts[i].Load()
+					fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, "le", formatFloat(b)), cum)
+				}
+				cum += s.counts[len(f.buckets)].Load()
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelString(f.labels, s.labelValues, "le", "+Inf"), cum)
+				fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelString(f.labels, s.labelValues, "", ""), formatFloat(s.sum.load()))
+				fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelString(f.labels, s.labelValues, "", ""), s.count.Load())
+			default:
+				fmt.Fprintf(w, "%s%s %s\n", f.name, labelString(f.labels, s.labelValues, "", ""), formatFloat(s.val.load()))
+			}
+		}
+	}
+	err := w.w.Flush()
+	return w.n, err
+}
+
+func (f *family) sorted() []*series {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series { keys = append(keys, k) }
+	sort.Strings(keys)
+	out := make([]*series, len(keys))
+	for i, k := range keys { out[i] = f.series[k] }
+	f.mu.Unlock()
+	return out
+}
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" { return "" }
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 { b.WriteByte(',') }
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 { b.WriteByte(',') }
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (a *atomicFloat) load() float64 { return math.Float64frombits(a.bits.Load()) }
+func (a *atomicFloat) set(v float64) { a.bits.Store(math.Float64bits(v)) }
+func (a *atomicFloat) add(v float64) {
+	for {
+		old := a.bits.Load()
+		if a.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) { return }
+	}
+}
+
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil { t.Fatal(err) }
+	return b.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("t_requests_total", "Requests.\nBy code.", "code")
+	c.With("200").Add(2)
+	c.With("500").Inc()
+	r.NewGauge("t_depth", "Depth.").With().Set(1.5)
+	h := r.NewHistogram("t_seconds", "Latency.", []float64{.1, 1}, "op")
+	for _, v := range []float64{.05, .5, .5, 7} { h.With(`pu"t`).Observe(v) }
+	r.NewGaugeFunc("t_open", "Open things.", []string{"name"}, func(emit func(float64, ...string)) { emit(3, "a") })
+
+	want := `# HELP t_requests_total Requests.\nBy code.
+# TYPE t_requests_total counter
+t_requests_total{code="200"} 2
+t_requests_total{code="500"} 1
+# HELP t_depth Depth.
+# TYPE t_depth gauge
+t_depth 1.5
+# HELP t_seconds Latency.
+# TYPE t_seconds histogram
+t_seconds_bucket{op="pu\"t",le="0.1"} 1
+t_seconds_bucket{op="pu\"t",le="1"} 3
+t_seconds_bucket{op="pu\"t",le="+Inf"} 4
+t_seconds_sum{op="pu\"t"} 8.05
+t_seconds_count{op="pu\"t"} 4
+# HELP t_open Open things.
+# TYPE t_open gauge
+t_open{name="a"} 3
+`
+	if got := render(t, r); got != want { t.Errorf("got:\n%s\nwant:\n%s", got, want) }
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") { t.Errorf("Content-Type %q", ct) }
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("t_total", "T.").With().Inc()
+	r.NewCounter("t_total", "T.").With().Inc()
+	if got := render(t, r); !strings.Contains(got, "t_total 2\n") || strings.Count(got, "# TYPE") != 1 { t.Errorf("got:\n%s", got) }
+
+	defer func() {
+		if recover() == nil { t.Error("registering t_total as a gauge did not panic") }
+	}()
+	r.NewGauge("t_total", "T.")
+}
@@ -0,0 +1,85 @@
+package mw
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"eventstore/internal/logging"
+)
+
+// RequestID gives every request an ID: the caller's X-Request-ID when it is
+// sane, a random one otherwise. It goes into the context and is echoed in
+// the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var b [8]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+type accessKey struct{}
+
+// access is filled in by inner middleware for the access log line.
+type access struct {
+	principal string
+}
+
+// AccessLog writes one structured record per request once it completes.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &access{}
+		sw := &statusWriter{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), accessKey{}, info)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if sw.code >= 500 {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.code),
+			slog.Int64("bytes", sw.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote", r.RemoteAddr),
+		}
+		if info.principal != "" {
+			attrs = append(attrs, slog.String("principal", info.principal))
+		}
+		slog.LogAttrs(ctx, level, "http request", attrs...)
+	})
+}
+
+// notePrincipal records who made the request for the access log.
+func notePrincipal(r *http.Request, p *Principal) {
+	if info, ok := r.Context().Value(accessKey{}).(*access); ok {
+		info.principal = p.Name
+	}
+}
@@ -0,0 +1,78 @@
+package mw
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eventstore/internal/logging"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log, err := logging.New(&buf, "json", new(slog.LevelVar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := slog.Default()
+	slog.SetDefault(log)
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+	for _, c := range []struct {
+		in   string
+		keep bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"has space", false},
+		{strings.Repeat("x", 129), false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", c.in)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		got := rec.Header().Get("X-Request-ID")
+		if got != seen || got == "" || (got == c.in) != c.keep {
+			t.Errorf("%q: response %q, context %q", c.in, got, seen)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := captureLog(t)
+	_, _, authed := newTestAuth(t)
+	h := RequestID(AccessLog(authed))
+	req := httptest.NewRequest(http.MethodGet, "/events?x=1", nil)
+	req.Header.Set("X-Request-ID", "req-7")
+	req.Header.Set("X-API-Key", "r-key")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/events", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("log lines: %q", lines)
+	}
+	var ok, denied map[string]any
+	json.Unmarshal([]byte(lines[0]), &ok)
+	json.Unmarshal([]byte(lines[1]), &denied)
+	for k, want := range map[string]any{"msg": "http request", "method": "GET", "path": "/events", "status": 200.0, "principal": "reader", "request_id": "req-7", "level": "INFO"} {
+		if ok[k] != want {
+			t.Errorf("%s = %v, want %v", k, ok[k], want)
+		}
+	}
+	if denied["status"] != 401.0 || denied["principal"] != nil || denied["request_id"] == "" {
+		t.Errorf("denied request: %v", denied)
+	}
+}
@@ -0,0 +1,324 @@
+package mw
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes understood by the auth middleware. ScopeAdmin implies the others.
+const (
+	ScopeRead  = "events:read"
+	ScopeWrite = "events:write"
+	ScopeAdmin = "admin"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Via    string   `json:"via"` // apikey, jwt, mtls
+}
+
+// Has reports whether p may act with scope.
+func (p *Principal) Has(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal attaches p to ctx.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal the auth middleware attached, if any.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Rule maps requests to the scope they need. Method "" matches any method;
+// Prefix matches the path and everything below it. An empty Scope only
+// requires a valid credential; Public skips authentication.
+type Rule struct {
+	Method string
+	Prefix string
+	Scope  string
+	Public bool
+}
+
+var (
+	errNoCredentials = errors.New("missing credentials")
+	errBadKey        = errors.New("invalid api key")
+	errBadToken      = errors.New("invalid token")
+	errExpired       = errors.New("token expired")
+)
+
+// keyFile is the on-disk principal list. Keys may be stored in clear or as
+// the hex SHA-256 of the key; an entry with a subject instead matches
+// verified client certificates by common name or full subject DN.
+type keyFile struct {
+	Keys []struct {
+		Name    string   `json:"name"`
+		Key     string   `json:"key,omitempty"`
+		SHA256  string   `json:"sha256,omitempty"`
+		Subject string   `json:"subject,omitempty"`
+		Scopes  []string `json:"scopes"`
+	} `json:"keys"`
+}
+
+// Auth authenticates requests with API keys from a JSON file or HS256 JWTs
+// signed with a shared secret, and enforces per-route scopes.
+type Auth struct {
+	keysFile string
+	secret   []byte
+	rules    []Rule
+
+	mu       sync.RWMutex
+	keys     map[string]*Principal // by hex sha256 of the key
+	subjects map[string]*Principal // by client certificate CN or DN
+	modTime  time.Time
+}
+
+// NewAuth loads keysFile (may be empty) and returns the middleware. rules are
+// tried in order; requests matching none need ScopeAdmin.
+func NewAuth(keysFile string, jwtSecret []byte, rules []Rule) (*Auth, error) {
+	a := &Auth{keysFile: keysFile, secret: jwtSecret, rules: rules, keys: map[string]*Principal{}}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload re-reads the key file. On error the previous keys stay in effect.
+func (a *Auth) Reload() error {
+	if a.keysFile == "" {
+		return nil
+	}
+	st, err := os.Stat(a.keysFile)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(a.keysFile)
+	if err != nil {
+		return err
+	}
+	var kf keyFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return err
+	}
+	keys := make(map[string]*Principal, len(kf.Keys))
+	subjects := map[string]*Principal{}
+	for _, k := range kf.Keys {
+		if k.Subject != "" && k.Name != "" {
+			subjects[k.Subject] = &Principal{Name: k.Name, Scopes: k.Scopes, Via: "mtls"}
+			continue
+		}
+		sum := strings.ToLower(k.SHA256)
+		if k.Key != "" {
+			h := sha256.Sum256([]byte(k.Key))
+			sum = hex.EncodeToString(h[:])
+		}
+		if sum == "" || k.Name == "" {
+			return errors.New("auth keys: every entry needs a name and key, sha256 or subject")
+		}
+		keys[sum] = &Principal{Name: k.Name, Scopes: k.Scopes, Via: "apikey"}
+	}
+	a.mu.Lock()
+	a.keys, a.subjects, a.modTime = keys, subjects, st.ModTime()
+	a.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the key file whenever its modification time changes.
+func (a *Auth) Watch(ctx context.Context, every time.Duration) {
+	if a.keysFile == "" {
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			st, err := os.Stat(a.keysFile)
+			if err != nil {
+				continue
+			}
+			a.mu.RLock()
+			changed := !st.ModTime().Equal(a.modTime)
+			a.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := a.Reload(); err != nil {
+				slog.Error("auth keys reload failed", "file", a.keysFile, "err", err)
+			} else {
+				slog.Info("auth keys reloaded", "file", a.keysFile)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Wrap authenticates the request, checks the scope its route needs and
+// passes it on with the principal in the context. A principal attached by
+// an outer Wrap is reused.
+func (a *Auth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := a.match(r)
+		if rule.Public {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, ok := PrincipalFrom(r.Context())
+		if !ok {
+			var err error
+			if p, err = a.authenticate(r); err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="eventstore"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(WithPrincipal(r.Context(), p))
+			notePrincipal(r, p)
+		}
+		if rule.Scope != "" && !p.Has(rule.Scope) {
+			http.Error(w, "missing scope "+rule.Scope, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Auth) match(r *http.Request) Rule {
+	for _, rule := range a.rules {
+		if rule.Method != "" && rule.Method != r.Method {
+			continue
+		}
+		if r.URL.Path == rule.Prefix || strings.HasPrefix(r.URL.Path, rule.Prefix) {
+			return rule
+		}
+	}
+	return Rule{Scope: ScopeAdmin}
+}
+
+// authenticate reads "Authorization: Bearer <key|jwt>" or X-API-Key. GET
+// requests may pass access_token in the query, for browser EventSource and
+// WebSocket clients that cannot set headers. Without any of those a verified
+// client certificate with a mapped subject identifies the caller.
+func (a *Auth) authenticate(r *http.Request) (*Principal, error) {
+	cred := r.Header.Get("X-API-Key")
+	if h := r.Header.Get("Authorization"); cred == "" && h != "" {
+		scheme, tok, _ := strings.Cut(h, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return nil, errNoCredentials
+		}
+		cred = strings.TrimSpace(tok)
+	}
+	if cred == "" && r.Method == http.MethodGet {
+		cred = r.URL.Query().Get("access_token")
+	}
+	if cred == "" {
+		if p := a.fromCert(r); p != nil {
+			return p, nil
+		}
+		return nil, errNoCredentials
+	}
+	if strings.Count(cred, ".") == 2 {
+		return a.verifyJWT(cred)
+	}
+	sum := sha256.Sum256([]byte(cred))
+	a.mu.RLock()
+	p, ok := a.keys[hex.EncodeToString(sum[:])]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, errBadKey
+	}
+	return p, nil
+}
+
+func (a *Auth) fromCert(r *http.Request) *Principal {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if p, ok := a.subjects[leaf.Subject.String()]; ok {
+		return p
+	}
+	if p, ok := a.subjects[leaf.Subject.CommonName]; ok {
+		return p
+	}
+	return nil
+}
+
+const jwtLeeway = 30 * time.Second
+
+// verifyJWT checks an HS256 token. The principal is the sub claim; scopes
+// come from "scope" (space separated) or "scopes".
+func (a *Auth) verifyJWT(tok string) (*Principal, error) {
+	if len(a.secret) == 0 {
+		return nil, errBadToken
+	}
+	parts := strings.Split(tok, ".")
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errBadToken
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(hb, &hdr) != nil || hdr.Alg != "HS256" {
+		return nil, errBadToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errBadToken
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errBadToken
+	}
+
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errBadToken
+	}
+	var claims struct {
+		Sub    string   `json:"sub"`
+		Exp    float64  `json:"exp"`
+		Nbf    float64  `json:"nbf"`
+		Scope  string   `json:"scope"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.Unmarshal(cb, &claims); err != nil || claims.Sub == "" {
+		return nil, errBadToken
+	}
+	now := time.Now()
+	if claims.Exp != 0 && now.Add(-jwtLeeway).After(time.Unix(int64(claims.Exp), 0)) {
+		return nil, errExpired
+	}
+	if claims.Nbf != 0 && now.Add(jwtLeeway).Before(time.Unix(int64(claims.Nbf), 0)) {
+		return nil, errBadToken
+	}
+	scopes := append(strings.Fields(claims.Scope), claims.Scopes...)
+	return &Principal{Name: claims.Sub, Scopes: scopes, Via: "jwt"}, nil
+}
@@ -0,0 +1,149 @@
+package mw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("secret")
+
+func signJWT(t *testing.T, secret []byte, claims map[string]any) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	hdr := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := hdr + "." + enc.EncodeToString(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(msg))
+	return msg + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func writeKeys(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestAuth(t *testing.T) (*Auth, string, http.Handler) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys(t, path, `{"keys":[{"name":"reader","key":"r-key","scopes":["events:read"]},{"name":"ops","key":"a-key","scopes":["admin"]}]}`)
+	a, err := NewAuth(path, testSecret, []Rule{
+		{Prefix: "/livez", Public: true},
+		{Method: http.MethodGet, Prefix: "/events", Scope: ScopeRead},
+		{Method: http.MethodPost, Prefix: "/events", Scope: ScopeWrite},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := PrincipalFrom(r.Context())
+		if ok {
+			w.Header().Set("X-Principal", p.Name+"/"+p.Via)
+		}
+	}))
+	return a, path, h
+}
+
+func do(h http.Handler, method, path string, hdr ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthScopes(t *testing.T) {
+	_, _, h := newTestAuth(t)
+	for _, c := range []struct {
+		name, method, path string
+		hdr                []string
+		code               int
+		principal          string
+	}{
+		{"public", "GET", "/livez", nil, 200, ""},
+		{"anonymous", "GET", "/events", nil, 401, ""},
+		{"reader reads", "GET", "/events", []string{"X-API-Key", "r-key"}, 200, "reader/apikey"},
+		{"reader writes", "POST", "/events", []string{"X-API-Key", "r-key"}, 403, ""},
+		{"bearer key", "GET", "/events", []string{"Authorization", "Bearer r-key"}, 200, "reader/apikey"},
+		{"unknown key", "GET", "/events", []string{"X-API-Key", "nope"}, 401, ""},
+		{"unmatched route needs admin", "POST", "/admin/flush", []string{"X-API-Key", "r-key"}, 403, ""},
+		{"admin implies all", "POST", "/events", []string{"X-API-Key", "a-key"}, 200, "ops/apikey"},
+		{"query token on GET", "GET", "/events?access_token=r-key", nil, 200, "reader/apikey"},
+		{"query token on POST", "POST", "/events?access_token=a-key", nil, 401, ""},
+	} {
+		rec := do(h, c.method, c.path, c.hdr...)
+		if rec.Code != c.code || rec.Header().Get("X-Principal") != c.principal {
+			t.Errorf("%s: %d %q, want %d %q", c.name, rec.Code, rec.Header().Get("X-Principal"), c.code, c.principal)
+		}
+	}
+}
+
+func TestAuthJWT(t *testing.T) {
+	_, _, h := newTestAuth(t)
+	now := time.Now().Unix()
+	for _, c := range []struct {
+		name string
+		tok  string
+		code int
+	}{
+		{"valid", signJWT(t, testSecret, map[string]any{"sub": "svc", "scope": "events:read events:write", "exp": now + 60}), 200},
+		{"scopes list", signJWT(t, testSecret, map[string]any{"sub": "svc", "scopes": []string{"events:write"}}), 200},
+		{"missing scope", signJWT(t, testSecret, map[string]any{"sub": "svc", "scope": "events:read"}), 403},
+		{"expired", signJWT(t, testSecret, map[string]any{"sub": "svc", "scope": "events:write", "exp": now - 3600}), 401},
+		{"not yet valid", signJWT(t, testSecret, map[string]any{"sub": "svc", "scope": "events:write", "nbf": now + 3600}), 401},
+		{"wrong secret", signJWT(t, []byte("other"), map[string]any{"sub": "svc", "scope": "events:write"}), 401},
+		{"no subject", signJWT(t, testSecret, map[string]any{"scope": "events:write"}), 401},
+	} {
+		rec := do(h, "POST", "/events", "Authorization", "Bearer "+c.tok)
+		if rec.Code != c.code {
+			t.Errorf("%s: %d, want %d", c.name, rec.Code, c.code)
+		}
+		if c.code == 200 && rec.Header().Get("X-Principal") != "svc/jwt" {
+			t.Errorf("%s: principal %q", c.name, rec.Header().Get("X-Principal"))
+		}
+	}
+}
+
+func TestAuthReload(t *testing.T) {
+	a, path, h := newTestAuth(t)
+	writeKeys(t, path, `{"keys":[{"name":"writer","sha256":"`+sha256Hex("w-key")+`","scopes":["events:write"]}]}`)
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(h, "POST", "/events", "X-API-Key", "w-key"); rec.Code != 200 {
+		t.Errorf("new key: %d", rec.Code)
+	}
+	if rec := do(h, "GET", "/events", "X-API-Key", "r-key"); rec.Code != 401 {
+		t.Errorf("removed key: %d", rec.Code)
+	}
+
+	// a broken file leaves the last good keys in effect
+	writeKeys(t, path, `{"keys":[{"scopes":["admin"]}]}`)
+	if err := a.Reload(); err == nil {
+		t.Error("Reload accepted an entry without a name or key")
+	}
+	if rec := do(h, "POST", "/events", "X-API-Key", "w-key"); rec.Code != 200 {
+		t.Errorf("after failed reload: %d", rec.Code)
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,23 +1,56 @@
 package mw
 
 import (
+	"sync/atomic"
 	"time"
 
 	"github.com/sony/gobreaker"
 )
 
-func NewBreaker(name string) *gobreaker.CircuitBreaker {
-	st := gobreaker.Settings{
-		Name:        name,
-		MaxRequests: 3, // half-open
-		Interval:    30 * time.Second,
-		Timeout:     10 * time.Second,
+// BreakerSettings are the trip and recovery thresholds of a Breaker.
+type BreakerSettings struct {
+	MinRequests      uint32        // requests in an interval before the failure ratio counts
+	FailureRatio     float64       // open when failures/requests exceeds this
+	Interval         time.Duration // closed-state counting window
+	OpenTimeout      time.Duration // how long to stay open before probing
+	HalfOpenRequests uint32        // probes allowed while half-open
+}
+
+// Breaker is a circuit breaker whose settings can change while in use.
+type Breaker struct {
+	name     string
+	settings atomic.Pointer[BreakerSettings]
+	cb       atomic.Pointer[gobreaker.CircuitBreaker]
+}
+
+func NewBreaker(name string, st BreakerSettings) *Breaker {
+	b := &Breaker{name: name}
+	b.Update(st)
+	return b
+}
+
+// Update applies new settings. A changed breaker starts over closed.
+func (b *Breaker) Update(st BreakerSettings) {
+	if old := b.settings.Load(); old != nil && *old == st {
+		return
+	}
+	b.settings.Store(&st)
+	b.cb.Store(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        b.name,
+		MaxRequests: st.HalfOpenRequests,
+		Interval:    st.Interval,
+		Timeout:     st.OpenTimeout,
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			// open circuit if >=5 requests and >50% failures
-			fail := counts.TotalFailures
-			total := counts.Requests
-			return total >= 5 && float64(fail)/float64(total) > 0.5
+			return counts.Requests >= st.MinRequests && float64(counts.TotalFailures)/float64(counts.Requests) > st.FailureRatio
 		},
-	}
-	return gobreaker.NewCircuitBreaker(st)
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			breakerState.With(name).Set(float64(to))
+			breakerTransitions.With(name, from.String(), to.String()).Inc()
+		},
+	}))
+	breakerState.With(b.name).Set(0)
 }
+
+func (b *Breaker) Execute(fn func() (any, error)) (any, error) { return b.cb.Load().Execute(fn) }
+
+func (b *Breaker) State() gobreaker.State { return b.cb.Load().State() }
@@ -0,0 +1,140 @@
+package mw
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConcurrencySettings configures a ConcurrencyLimiter.
+type ConcurrencySettings struct {
+	Initial, Min, Max int
+	// TargetLatency is the slowest a request may take before the limit is
+	// cut; requests failing with a 5xx count as too slow.
+	TargetLatency time.Duration
+	// Backoff multiplies the limit on every cut, e.g. 0.9.
+	Backoff float64
+	// Priority is "reads", "writes" or "" for neither. The other class is
+	// shed once in-flight requests reach (1-Reserve) of the limit, keeping
+	// the rest for the preferred class.
+	Priority string
+	Reserve  float64
+}
+
+// ConcurrencyLimiter sheds load with 503s once the requests in flight reach
+// a limit that adapts to latency (AIMD): it grows by one for every limit's
+// worth of fast requests while it is in use, and is multiplied by Backoff
+// when a request is slow or fails. Unlike RateLimiter it reacts to the
+// store slowing down rather than to any one client.
+type ConcurrencyLimiter struct {
+	exempt func(*http.Request) bool // may be nil
+
+	mu       sync.Mutex
+	st       ConcurrencySettings
+	limit    float64
+	inflight int
+	cut      time.Time // last decrease; slower requests started before it are ignored
+}
+
+// NewConcurrencyLimiter limits every request exempt does not match. Exempt
+// long-lived requests (streams, WebSockets) and probes.
+func NewConcurrencyLimiter(st ConcurrencySettings, exempt func(*http.Request) bool) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{exempt: exempt}
+	l.Update(st)
+	l.mu.Lock()
+	l.limit = float64(l.st.Initial)
+	l.mu.Unlock()
+	concurrencyLimit.Set(l.limit)
+	return l
+}
+
+// Update changes the settings, clamping the current limit to the new bounds.
+func (l *ConcurrencyLimiter) Update(st ConcurrencySettings) {
+	st.Min = max(st.Min, 1)
+	st.Max = max(st.Max, st.Min)
+	st.Initial = min(max(st.Initial, st.Min), st.Max)
+	if st.Backoff <= 0 || st.Backoff >= 1 {
+		st.Backoff = 0.9
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.st = st
+	if l.limit != 0 {
+		l.limit = min(max(l.limit, float64(st.Min)), float64(st.Max))
+		concurrencyLimit.Set(l.limit)
+	}
+}
+
+// Limit is the current concurrency limit.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+func (l *ConcurrencyLimiter) acquire(class string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit := l.limit
+	if l.st.Priority != "" && l.st.Priority != class {
+		limit *= 1 - l.st.Reserve
+	}
+	if float64(l.inflight) >= max(limit, 1) {
+		return false
+	}
+	l.inflight++
+	concurrencyInflight.Set(float64(l.inflight))
+	return true
+}
+
+func (l *ConcurrencyLimiter) release(start time.Time, failed bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	busy := float64(l.inflight) >= l.limit/2
+	l.inflight--
+	concurrencyInflight.Set(float64(l.inflight))
+
+	switch {
+	case failed || now.Sub(start) > l.st.TargetLatency:
+		if start.Before(l.cut) {
+			return // already cut for this episode
+		}
+		l.limit = max(l.limit*l.st.Backoff, float64(l.st.Min))
+		l.cut = now
+	case busy:
+		l.limit = min(l.limit+1/l.limit, float64(l.st.Max))
+	default:
+		return
+	}
+	concurrencyLimit.Set(l.limit)
+}
+
+// Wrap sheds requests beyond the current limit with a 503 and Retry-After,
+// and feeds the latency and status of those it admits back into the limit.
+// Requests matched by the exempt func given to NewConcurrencyLimiter (the
+// server exempts streams, WebSockets and probes) pass straight through and
+// are not counted.
+func (l *ConcurrencyLimiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.exempt != nil && l.exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		class := "writes"
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			class = "reads"
+		}
+		if !l.acquire(class) {
+			loadShed.With(class).Inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "overloaded, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() { l.release(start, sw.code >= 500) }()
+		next.ServeHTTP(sw, r)
+	})
+}
@@ -0,0 +1,97 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyShedsWithPriority(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencySettings{Initial: 10, Min: 1, Max: 10, TargetLatency: time.Hour, Priority: "writes", Reserve: 0.2}, func(r *http.Request) bool { return r.URL.Path == "/stream" })
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream" {
+			started.Done()
+		}
+		<-release
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	var done sync.WaitGroup
+	hold := func(method string, n int) {
+		started.Add(n)
+		done.Add(n)
+		for i := 0; i < n; i++ {
+			go func() { defer done.Done(); serve(method, "/") }()
+		}
+		started.Wait()
+	}
+	hold("GET", 8) // reads may use 80% of the limit
+	if rec := serve("GET", "/"); rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("ninth read: %d %v", rec.Code, rec.Header())
+	}
+	hold("POST", 2) // writes get the reserve
+	if rec := serve("POST", "/"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("write past the limit: %d", rec.Code)
+	}
+	stream := make(chan int, 1)
+	go func() { stream <- serve("GET", "/stream").Code }() // exempt
+	close(release)
+	done.Wait()
+	if code := <-stream; code != http.StatusOK {
+		t.Errorf("exempt stream: %d", code)
+	}
+	if l.inflight != 0 {
+		t.Errorf("%d still in flight", l.inflight)
+	}
+}
+
+func TestConcurrencyAIMD(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencySettings{Initial: 10, Min: 2, Max: 12, TargetLatency: 50 * time.Millisecond, Backoff: 0.5}, nil)
+
+	// slow requests of one episode cut the limit once
+	slow := time.Now().Add(-time.Second)
+	l.acquire("reads")
+	l.acquire("reads")
+	l.release(slow, false)
+	l.release(slow, false)
+	if got := l.Limit(); got != 5 {
+		t.Fatalf("after a slow episode: %d, want 5", got)
+	}
+	l.acquire("writes")
+	l.release(time.Now(), true)
+	if got := l.Limit(); got != 2 {
+		t.Fatalf("after a failure: %d, want 2", got)
+	}
+
+	// fast requests grow it, but only while it is in use
+	l.acquire("reads")
+	l.release(time.Now(), false)
+	if got := l.limit; got <= 2 {
+		t.Fatalf("busy fast request did not grow the limit: %v", got)
+	}
+	for i := 0; i < 200; i++ {
+		n := l.Limit()
+		for j := 0; j < n; j++ {
+			l.acquire("reads")
+		}
+		for j := 0; j < n; j++ {
+			l.release(time.Now(), false)
+		}
+	}
+	if got := l.Limit(); got != 12 {
+		t.Errorf("limit grew to %d, want the max 12", got)
+	}
+
+	l.Update(ConcurrencySettings{Min: 1, Max: 4, TargetLatency: time.Second})
+	if got := l.Limit(); got != 4 {
+		t.Errorf("after Update: %d, want clamped to 4", got)
+	}
+}
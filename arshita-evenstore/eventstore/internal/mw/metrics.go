@@ -0,0 +1,94 @@
+package mw
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eventstore/internal/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter("eventstore_http_requests_total",
+		"HTTP requests by route, method and status.", "route", "method", "code")
+	httpDuration = metrics.NewHistogram("eventstore_http_request_duration_seconds",
+		"HTTP request latency by route, method and status.", nil, "route", "method", "code")
+	rateLimited = metrics.NewCounter("eventstore_ratelimit_rejected_total",
+		"Requests rejected by the rate limiter, by bucket (read or write).", "bucket")
+	concurrencyLimit = metrics.NewGauge("eventstore_concurrency_limit",
+		"Adaptive limit on requests in flight.").With()
+	concurrencyInflight = metrics.NewGauge("eventstore_concurrency_inflight",
+		"Requests in flight under the concurrency limiter.").With()
+	loadShed = metrics.NewCounter("eventstore_load_shed_total",
+		"Requests shed by the concurrency limiter, by class (reads or writes).", "class")
+	breakerState = metrics.NewGauge("eventstore_breaker_state",
+		"Circuit breaker state: 0 closed, 1 half-open, 2 open.", "name")
+	breakerTransitions = metrics.NewCounter("eventstore_breaker_transitions_total",
+		"Circuit breaker state changes.", "name", "from", "to")
+)
+
+// Instrument counts and times requests to next. route names the pattern a
+// request matched, which keeps label cardinality bounded; "" is reported as
+// "unmatched".
+func Instrument(next http.Handler, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		rt := route(r)
+		if rt == "" {
+			rt = "unmatched"
+		}
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		code := strconv.Itoa(sw.code)
+		httpRequests.With(rt, r.Method, code).Inc()
+		httpDuration.With(rt, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter remembers the response status and size. It keeps Flush and
+// Hijack working for the streaming and WebSocket endpoints.
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	w.code = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,41 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"eventstore/internal/metrics"
+)
+
+func TestInstrumentLabelsRouteAndStatus(t *testing.T) {
+	h := Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}), func(r *http.Request) string {
+		if r.URL.Path == "/missing" {
+			return ""
+		}
+		return "GET /instrumented/{key}"
+	})
+	for _, p := range []string{"/instrumented/a", "/instrumented/b", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+
+	var b strings.Builder
+	metrics.Default.WriteTo(&b)
+	out := b.String()
+	for _, want := range []string{
+		`eventstore_http_requests_total{route="GET /instrumented/{key}",method="GET",code="200"} 2`,
+		`eventstore_http_requests_total{route="unmatched",method="GET",code="404"} 1`,
+		`eventstore_http_request_duration_seconds_count{route="GET /instrumented/{key}",method="GET",code="200"} 2`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
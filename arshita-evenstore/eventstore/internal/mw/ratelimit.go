@@ -1,35 +1,206 @@
 package mw
 
 import (
+	"container/list"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
+// RateLimitSettings configures a RateLimiter. Every client gets a read
+// bucket (GET, HEAD, OPTIONS) and a write bucket (everything else).
+type RateLimitSettings struct {
+	ReadRPS    float64
+	ReadBurst  int
+	WriteRPS   float64
+	WriteBurst int
+	// Costs is the number of tokens a request takes, by "METHOD /route"
+	// (e.g. "GET /events"); unlisted routes cost 1.
+	Costs map[string]int
+	// MaxClients bounds the limiters kept; the least recently used client
+	// is forgotten first and starts over with full buckets.
+	MaxClients int
+}
+
+// KeyFunc says which client a request belongs to.
+type KeyFunc func(*http.Request) string
+
+// ClientIP keys requests by the connection's remote address.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "ip:" + r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ByPrincipal keys requests by their authenticated principal, so a client
+// keeps its budget across addresses. It needs to run inside Auth.Wrap;
+// anonymous requests fall back to ClientIP.
+func ByPrincipal(r *http.Request) string {
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		return "principal:" + p.Name
+	}
+	return ClientIP(r)
+}
+
+// ByHeader keys requests by the value of header name, falling back to
+// ClientIP when it is absent.
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return "header:" + v
+		}
+		return ClientIP(r)
+	}
+}
+
+// RateLimiter keeps token buckets per client.
 type RateLimiter struct {
-	lim *rate.Limiter
+	key   KeyFunc
+	route func(*http.Request) string // may be nil
+
+	mu      sync.Mutex
+	st      RateLimitSettings
+	clients map[string]*list.Element // of *client
+	lru     *list.List               // most recently used at the front
+}
+
+type client struct {
+	key         string
+	read, write *rate.Limiter
+}
+
+// NewRateLimiter limits requests per key(r). route names the matched route
+// for Costs.
+func NewRateLimiter(st RateLimitSettings, key KeyFunc, route func(*http.Request) string) *RateLimiter {
+	rl := &RateLimiter{key: key, route: route, clients: map[string]*list.Element{}, lru: list.New()}
+	rl.st = normalize(st)
+	return rl
+}
+
+func normalize(st RateLimitSettings) RateLimitSettings {
+	if st.MaxClients <= 0 {
+		st.MaxClients = 10000
+	}
+	if st.WriteRPS <= 0 {
+		st.WriteRPS, st.WriteBurst = st.ReadRPS, st.ReadBurst
+	}
+	return st
+}
+
+// Update changes the settings for new and existing clients.
+func (rl *RateLimiter) Update(st RateLimitSettings) {
+	st = normalize(st)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.st = st
+	for e := rl.lru.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*client)
+		c.read.SetLimit(rate.Limit(st.ReadRPS))
+		c.read.SetBurst(st.ReadBurst)
+		c.write.SetLimit(rate.Limit(st.WriteRPS))
+		c.write.SetBurst(st.WriteBurst)
+	}
+	rl.evictLocked()
+}
+
+// bucket returns the limiter r draws from, its name for metrics, and the
+// request's cost (at most the bucket size, so any request can pass).
+func (rl *RateLimiter) bucket(r *http.Request) (*rate.Limiter, string, int) {
+	k := rl.key(r)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	e, ok := rl.clients[k]
+	if ok {
+		rl.lru.MoveToFront(e)
+	} else {
+		e = rl.lru.PushFront(&client{
+			key:   k,
+			read:  rate.NewLimiter(rate.Limit(rl.st.ReadRPS), rl.st.ReadBurst),
+			write: rate.NewLimiter(rate.Limit(rl.st.WriteRPS), rl.st.WriteBurst),
+		})
+		rl.clients[k] = e
+		rl.evictLocked()
+	}
+	c := e.Value.(*client)
+
+	lim, name := c.write, "write"
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		lim, name = c.read, "read"
+	}
+	cost := 1
+	if rl.route != nil {
+		if n, ok := rl.st.Costs[r.Method+" "+rl.route(r)]; ok && n > 0 {
+			cost = n
+		}
+	}
+	return lim, name, min(cost, max(lim.Burst(), 1))
 }
 
-func NewRateLimiter(rps float64, burst int) *RateLimiter {
-	return &RateLimiter{lim: rate.NewLimiter(rate.Limit(rps), burst)}
+func (rl *RateLimiter) evictLocked() {
+	for rl.lru.Len() > rl.st.MaxClients {
+		e := rl.lru.Back()
+		rl.lru.Remove(e)
+		delete(rl.clients, e.Value.(*client).key)
+	}
 }
 
 func (rl *RateLimiter) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !rl.lim.Allow() {
-			w.Header().Set("Retry-After", "1")
+		lim, bucket, cost := rl.bucket(r)
+		now := time.Now()
+		res := lim.ReserveN(now, cost)
+		if delay := res.DelayFrom(now); !res.OK() || delay > 0 {
+			res.CancelAt(now)
+			rateLimited.With(bucket).Inc()
+			setRateLimitHeaders(w, lim, now)
+			if !res.OK() {
+				delay = refill(lim, float64(cost))
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(delay)))
 			http.Error(w, "rate limit", http.StatusTooManyRequests)
 			return
 		}
+		setRateLimitHeaders(w, lim, now)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// setRateLimitHeaders writes the RateLimit-* fields for lim: the bucket
+// size, the whole tokens left and the seconds until it is full again.
+func setRateLimitHeaders(w http.ResponseWriter, lim *rate.Limiter, now time.Time) {
+	burst := lim.Burst()
+	tokens := max(lim.TokensAt(now), 0)
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(refill(lim, float64(burst)-tokens))))
+	h.Set("RateLimit-Policy", strconv.Itoa(burst)+";w="+strconv.Itoa(seconds(refill(lim, float64(burst)))))
+}
+
+// refill is how long lim takes to earn n tokens.
+func refill(lim *rate.Limiter, n float64) time.Duration {
+	if n <= 0 || lim.Limit() <= 0 {
+		return 0
+	}
+	return time.Duration(n / float64(lim.Limit()) * float64(time.Second))
+}
+
+// seconds rounds d up to whole seconds, as the headers require.
+func seconds(d time.Duration) int { return int(math.Ceil(d.Seconds())) }
+
 // If you want a blocking variant (not used here)
 func (rl *RateLimiter) Wait(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := rl.lim.Wait(r.Context()); err != nil {
+		lim, _, cost := rl.bucket(r)
+		if err := lim.WaitN(r.Context(), cost); err != nil {
 			http.Error(w, "rate limit", http.StatusTooManyRequests)
 			return
 		}
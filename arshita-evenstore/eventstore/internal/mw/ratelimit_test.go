@@ -0,0 +1,112 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func limited(rl *RateLimiter) http.Handler {
+	return rl.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+}
+
+func call(h http.Handler, method, path, remote string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = remote
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// drain spends a client's bucket and returns how many requests passed.
+func drain(h http.Handler, method, path, remote string) int {
+	n := 0
+	for call(h, method, path, remote).Code == http.StatusOK {
+		n++
+		if n > 1000 {
+			break
+		}
+	}
+	return n
+}
+
+func TestRateLimitPerClientAndBucket(t *testing.T) {
+	h := limited(NewRateLimiter(RateLimitSettings{ReadRPS: 0.001, ReadBurst: 3, WriteRPS: 0.001, WriteBurst: 2}, ClientIP, nil))
+	if n := drain(h, "GET", "/events", "10.0.0.1:1"); n != 3 {
+		t.Errorf("reads passed: %d, want 3", n)
+	}
+	if n := drain(h, "POST", "/events", "10.0.0.1:2"); n != 2 {
+		t.Errorf("writes after reads ran out: %d, want 2", n)
+	}
+	if n := drain(h, "GET", "/events", "10.0.0.2:1"); n != 3 {
+		t.Errorf("another client: %d, want 3", n)
+	}
+}
+
+func TestRateLimitHeadersAndRouteCosts(t *testing.T) {
+	route := func(r *http.Request) string { return r.URL.Path }
+	h := limited(NewRateLimiter(RateLimitSettings{ReadRPS: 1, ReadBurst: 10, Costs: map[string]int{"GET /events": 4}}, ClientIP, route))
+
+	rec := call(h, "GET", "/events/k", "10.0.0.1:1")
+	if rec.Header().Get("RateLimit-Limit") != "10" || rec.Header().Get("RateLimit-Remaining") != "9" || rec.Header().Get("RateLimit-Policy") != "10;w=10" {
+		t.Errorf("headers after a get: %v", rec.Header())
+	}
+	rec = call(h, "GET", "/events", "10.0.0.1:1")
+	if rec.Header().Get("RateLimit-Remaining") != "5" {
+		t.Errorf("replay cost: remaining %s, want 5", rec.Header().Get("RateLimit-Remaining"))
+	}
+	call(h, "GET", "/events", "10.0.0.1:1")
+	rec = call(h, "GET", "/events", "10.0.0.1:1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third replay: %d", rec.Code)
+	}
+	// one token left, three more needed at 1/s
+	if ra, _ := strconv.Atoi(rec.Header().Get("Retry-After")); ra != 3 {
+		t.Errorf("Retry-After %q, want 3", rec.Header().Get("Retry-After"))
+	}
+	if rec := call(h, "GET", "/events/k", "10.0.0.1:1"); rec.Code != http.StatusOK {
+		t.Errorf("a get still fits in the bucket: %d", rec.Code)
+	}
+}
+
+func TestRateLimitEvictsIdleClients(t *testing.T) {
+	rl := NewRateLimiter(RateLimitSettings{ReadRPS: 0.001, ReadBurst: 1, MaxClients: 2}, ClientIP, nil)
+	h := limited(rl)
+	call(h, "GET", "/", "10.0.0.1:1")
+	call(h, "GET", "/", "10.0.0.2:1")
+	if call(h, "GET", "/", "10.0.0.1:1").Code != http.StatusTooManyRequests {
+		t.Fatal("client 1 not limited")
+	}
+	call(h, "GET", "/", "10.0.0.3:1") // evicts client 2, the least recently used
+	if len(rl.clients) != 2 {
+		t.Fatalf("%d limiters kept, want 2", len(rl.clients))
+	}
+	if call(h, "GET", "/", "10.0.0.2:1").Code != http.StatusOK {
+		t.Error("evicted client did not start over")
+	}
+
+	rl.Update(RateLimitSettings{ReadRPS: 0.001, ReadBurst: 5, MaxClients: 1})
+	if len(rl.clients) != 1 {
+		t.Errorf("%d limiters kept after Update, want 1", len(rl.clients))
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if k := ClientIP(req); k != "ip:10.0.0.1" {
+		t.Errorf("ClientIP %q", k)
+	}
+	if k := ByHeader("X-Client")(req); k != "ip:10.0.0.1" {
+		t.Errorf("ByHeader without the header %q", k)
+	}
+	req.Header.Set("X-Client", "c1")
+	if k := ByHeader("X-Client")(req); k != "header:c1" {
+		t.Errorf("ByHeader %q", k)
+	}
+	req = req.WithContext(WithPrincipal(req.Context(), &Principal{Name: "svc"}))
+	if k := ByPrincipal(req); k != "principal:svc" {
+		t.Errorf("ByPrincipal %q", k)
+	}
+}
@@ -0,0 +1,41 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+
+	"eventstore/internal/trace"
+)
+
+// Trace continues the caller's trace from its traceparent header (or starts
+// a new one), runs the request inside a server span and returns the span's
+// context in the traceparent response header.
+func Trace(next http.Handler, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := trace.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = trace.ContextWith(ctx, sc)
+		}
+		rt := route(r)
+		if rt == "" {
+			rt = "unmatched"
+		}
+		ctx, span := trace.Start(ctx, r.Method+" "+rt, trace.KindServer)
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.route", rt)
+		span.SetAttr("http.target", r.URL.Path)
+		w.Header().Set("traceparent", span.Context().Traceparent())
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		span.SetAttr("http.status_code", sw.code)
+		if sw.code >= 500 {
+			span.SetError(fmt.Errorf("HTTP %d", sw.code))
+		}
+		span.End()
+	})
+}
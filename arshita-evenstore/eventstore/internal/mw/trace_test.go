@@ -0,0 +1,64 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"eventstore/internal/store"
+	"eventstore/internal/trace"
+)
+
+type spanSink struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (s *spanSink) ExportSpans(spans []trace.SpanData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spans = append(s.spans, spans...)
+	return nil
+}
+
+// A request's traceparent is continued through the server span into the
+// store write.
+func TestTraceContinuesIntoStore(t *testing.T) {
+	s, err := store.NewLSMStore(store.Options{DataDir: t.TempDir(), MemtableMaxItems: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	sink := &spanSink{}
+	trace.SetExporter(sink)
+	h := Trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Put(r.Context(), store.Event{Key: "k", TS: 1, Value: []byte(`{}`)}); err != nil {
+			http.Error(w, err.Error(), 500)
+		}
+	}), func(*http.Request) string { return "/events" })
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/events", nil)
+	req.Header.Set("traceparent", parent)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	trace.Shutdown()
+
+	remote, _ := trace.ParseTraceparent(parent)
+	got, ok := trace.ParseTraceparent(rec.Header().Get("traceparent"))
+	if !ok || got.TraceID != remote.TraceID || got.SpanID == remote.SpanID {
+		t.Fatalf("response traceparent %q", rec.Header().Get("traceparent"))
+	}
+	byName := map[string]trace.SpanData{}
+	for _, d := range sink.spans {
+		byName[d.Name] = d
+	}
+	srv, put := byName["POST /events"], byName["store.Put"]
+	if srv.Context.SpanID != got.SpanID || srv.Parent != remote.SpanID || srv.Attrs["http.status_code"] != 200 {
+		t.Errorf("server span %+v", srv)
+	}
+	if put.Context.TraceID != remote.TraceID || put.Parent != srv.Context.SpanID {
+		t.Errorf("store.Put span %+v not under the server span", put)
+	}
+}
@@ -0,0 +1,243 @@
+package ns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"eventstore/internal/store"
+)
+
+// Settings configures one namespace. Zero values fall back to the registry
+// defaults.
+type Settings struct {
+	Name             string  `json:"name"`
+	MemtableMaxItems int     `json:"memtable_max_items,omitempty"`
+	Retention        string  `json:"retention,omitempty"` // Go duration, e.g. "720h"; empty keeps everything
+	RateLimitRPS     float64 `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst   int     `json:"rate_limit_burst,omitempty"`
+	ProduceTopic     string  `json:"produce_topic,omitempty"` // empty publishes to the default topic
+	ConsumeTopic     string  `json:"consume_topic,omitempty"` // empty disables Kafka ingest
+	DeadLetterTopic  string  `json:"dead_letter_topic,omitempty"` // for consumed messages that cannot be stored
+}
+
+// Namespace is an isolated keyspace backed by its own LSM store under
+// <DATA_DIR>/ns/<name>.
+type Namespace struct {
+	Settings Settings
+	Store    *store.LSMStore
+
+	ctx    context.Context // cancelled when the namespace is dropped
+	cancel context.CancelFunc
+	active sync.WaitGroup // references from Acquire
+}
+
+// Context is cancelled when the namespace is being dropped, so long-running
+// requests (streams, WebSockets) holding a reference can end.
+func (n *Namespace) Context() context.Context { return n.ctx }
+
+// Hooks let the server attach per-namespace resources (Kafka producers and
+// consumers, projections) as namespaces come and go.
+type Hooks struct {
+	Opened  func(*Namespace) error
+	Closing func(*Namespace)
+}
+
+type Registry struct {
+	dir      string
+	defaults Settings
+	hooks    Hooks
+
+	mu       sync.RWMutex
+	spaces   map[string]*Namespace
+	dropping map[string]bool // removed, store not yet closed and deleted
+}
+
+var (
+	ErrNotFound = errors.New("namespace not found")
+	ErrExists   = errors.New("namespace already exists")
+	validName   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+)
+
+type registryFile struct {
+	Namespaces []Settings `json:"namespaces"`
+}
+
+// Open loads the namespaces recorded in <dataDir>/namespaces.json and opens
+// their stores.
+func Open(dataDir string, defaults Settings, hooks Hooks) (*Registry, error) {
+	r := &Registry{dir: dataDir, defaults: defaults, hooks: hooks, spaces: map[string]*Namespace{}, dropping: map[string]bool{}}
+	b, err := os.ReadFile(r.path())
+	if err != nil && !os.IsNotExist(err) { return nil, err }
+	if err == nil {
+		var f registryFile
+		if err := json.Unmarshal(b, &f); err != nil { return nil, fmt.Errorf("namespaces.json: %w", err) }
+		for _, st := range f.Namespaces {
+			if _, err := r.open(st); err != nil {
+				r.Close()
+				return nil, fmt.Errorf("namespace %s: %w", st.Name, err)
+			}
+		}
+	}
+	return r, nil
+}
+
+// Create validates st, opens a fresh store for it and records it.
+func (r *Registry) Create(st Settings) (*Namespace, error) {
+	if !validName.MatchString(st.Name) {
+		return nil, fmt.Errorf("invalid namespace name %q", st.Name)
+	}
+	if _, err := parseRetention(st.Retention); err != nil { return nil, err }
+	// committed events are published, so consuming the produce topic would loop
+	if st.ConsumeTopic != "" && st.ConsumeTopic == st.ProduceTopic { return nil, fmt.Errorf("consume_topic and produce_topic must differ") }
+	if t := st.DeadLetterTopic; t != "" && (t == st.ConsumeTopic || t == st.ProduceTopic) { return nil, fmt.Errorf("dead_letter_topic must differ from consume_topic and produce_topic") }
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.spaces[st.Name]; ok || r.dropping[st.Name] { return nil, ErrExists }
+	n, err := r.openLocked(st)
+	if err != nil { return nil, err }
+	if err := r.saveLocked(); err != nil {
+		r.closeLocked(n)
+		return nil, err
+	}
+	return n, nil
+}
+
+// Drop removes the namespace, waits for the references taken by Acquire to
+// be released (cancelling the namespace context so streams end), then closes
+// the store and deletes its data.
+func (r *Registry) Drop(name string) error {
+	r.mu.Lock()
+	n, ok := r.spaces[name]
+	if !ok {
+		r.mu.Unlock()
+		return ErrNotFound
+	}
+	delete(r.spaces, name)
+	if err := r.saveLocked(); err != nil {
+		r.spaces[name] = n
+		r.mu.Unlock()
+		return err
+	}
+	r.dropping[name] = true
+	r.mu.Unlock()
+
+	n.cancel()
+	n.active.Wait()
+	r.closeLocked(n) // no longer reachable, so r.mu is not needed
+	err := os.RemoveAll(r.dataDir(name))
+
+	r.mu.Lock()
+	delete(r.dropping, name)
+	r.mu.Unlock()
+	return err
+}
+
+func (r *Registry) Get(name string) (*Namespace, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n, ok := r.spaces[name]
+	return n, ok
+}
+
+// Acquire is Get holding a reference to the namespace until release is
+// called; Drop does not close the store before then.
+func (r *Registry) Acquire(name string) (n *Namespace, release func(), ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n, ok = r.spaces[name]
+	if !ok { return nil, nil, false }
+	n.active.Add(1)
+	var once sync.Once
+	return n, func() { once.Do(n.active.Done) }, true
+}
+
+// List returns the settings of every namespace, sorted by name.
+func (r *Registry) List() []Settings {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Settings, 0, len(r.spaces))
+	for _, n := range r.spaces { out = append(out, n.Settings) }
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Close closes every namespace store.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var first error
+	for _, n := range r.spaces {
+		if err := r.closeLocked(n); err != nil && first == nil { first = err }
+	}
+	return first
+}
+
+func (r *Registry) open(st Settings) (*Namespace, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.openLocked(st)
+}
+
+func (r *Registry) openLocked(st Settings) (*Namespace, error) {
+	st = r.withDefaults(st)
+	ret, err := parseRetention(st.Retention)
+	if err != nil { return nil, err }
+	lsm, err := store.NewLSMStore(store.Options{
+		DataDir:          r.dataDir(st.Name),
+		MemtableMaxItems: st.MemtableMaxItems,
+		Retention:        ret,
+	})
+	if err != nil { return nil, err }
+	n := &Namespace{Settings: st, Store: lsm}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	if r.hooks.Opened != nil {
+		if err := r.hooks.Opened(n); err != nil {
+			n.cancel()
+			_ = lsm.Close()
+			return nil, err
+		}
+	}
+	r.spaces[st.Name] = n
+	return n, nil
+}
+
+func (r *Registry) closeLocked(n *Namespace) error {
+	n.cancel()
+	if r.hooks.Closing != nil { r.hooks.Closing(n) }
+	return n.Store.Close()
+}
+
+func (r *Registry) withDefaults(st Settings) Settings {
+	if st.MemtableMaxItems <= 0 { st.MemtableMaxItems = r.defaults.MemtableMaxItems }
+	if st.Retention == "" { st.Retention = r.defaults.Retention }
+	if st.RateLimitRPS <= 0 { st.RateLimitRPS = r.defaults.RateLimitRPS }
+	if st.RateLimitBurst <= 0 { st.RateLimitBurst = r.defaults.RateLimitBurst }
+	return st
+}
+
+func (r *Registry) saveLocked() error {
+	f := registryFile{Namespaces: make([]Settings, 0, len(r.spaces))}
+	for _, n := range r.spaces { f.Namespaces = append(f.Namespaces, n.Settings) }
+	sort.Slice(f.Namespaces, func(i, j int) bool { return f.Namespaces[i].Name < f.Namespaces[j].Name })
+	b, _ := json.MarshalIndent(f, "", "  ")
+	return os.WriteFile(r.path(), b, 0o644)
+}
+
+func (r *Registry) path() string            { return filepath.Join(r.dir, "namespaces.json") }
+func (r *Registry) dataDir(name string) string { return filepath.Join(r.dir, "ns", name) }
+
+func parseRetention(s string) (time.Duration, error) {
+	if s == "" { return 0, nil }
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 { return 0, fmt.Errorf("invalid retention %q", s) }
+	return d, nil
+}
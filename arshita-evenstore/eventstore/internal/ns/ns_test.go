@@ -0,0 +1,102 @@
+package ns
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"eventstore/internal/store"
+)
+
+func openTestRegistry(t *testing.T, dir string) *Registry {
+	t.Helper()
+	r, err := Open(dir, Settings{MemtableMaxItems: 100}, Hooks{})
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestNamespacesAreIsolated(t *testing.T) {
+	r := openTestRegistry(t, t.TempDir())
+	ctx := context.Background()
+	a, err := r.Create(Settings{Name: "a"})
+	if err != nil { t.Fatal(err) }
+	b, err := r.Create(Settings{Name: "b", MemtableMaxItems: 5})
+	if err != nil { t.Fatal(err) }
+	if a.Settings.MemtableMaxItems != 100 || b.Settings.MemtableMaxItems != 5 { t.Errorf("settings: %+v %+v", a.Settings, b.Settings) }
+
+	if err := a.Store.Put(ctx, store.Event{Key: "k", TS: 1, Value: []byte(`{"in":"a"}`)}); err != nil { t.Fatal(err) }
+	if _, ok, _ := b.Store.Get(ctx, "k"); ok { t.Fatal("event written to a is visible in b") }
+	if _, err := r.Create(Settings{Name: "a"}); !errors.Is(err, ErrExists) { t.Errorf("duplicate create: %v", err) }
+	for _, name := range []string{"", "A", "../x", "-a"} {
+		if _, err := r.Create(Settings{Name: name}); err == nil { t.Errorf("created invalid namespace %q", name) }
+	}
+	if _, err := r.Create(Settings{Name: "c", Retention: "soon"}); err == nil { t.Error("created a namespace with an invalid retention") }
+}
+
+func TestNamespacesPersistAndDrop(t *testing.T) {
+	dir := t.TempDir()
+	r := openTestRegistry(t, dir)
+	for _, name := range []string{"b", "a"} {
+		if _, err := r.Create(Settings{Name: name}); err != nil { t.Fatal(err) }
+	}
+	if err := r.Drop("b"); err != nil { t.Fatal(err) }
+	if _, err := os.Stat(r.dataDir("b")); !os.IsNotExist(err) { t.Fatalf("data dir left behind: %v", err) }
+	if err := r.Drop("b"); !errors.Is(err, ErrNotFound) { t.Fatalf("second drop: %v", err) }
+	r.Close()
+
+	r2 := openTestRegistry(t, dir)
+	if got := r2.List(); len(got) != 1 || got[0].Name != "a" { t.Fatalf("namespaces after reopen: %+v", got) }
+	if _, ok := r2.Get("a"); !ok { t.Fatal("namespace a not reopened") }
+}
+
+func TestHooksSeeOpenAndClose(t *testing.T) {
+	var opened, closed []string
+	r, err := Open(t.TempDir(), Settings{}, Hooks{
+		Opened: func(n *Namespace) error {
+			if n.Settings.Name == "bad" { return errors.New("refused") }
+			opened = append(opened, n.Settings.Name)
+			return nil
+		},
+		Closing: func(n *Namespace) { closed = append(closed, n.Settings.Name) },
+	})
+	if err != nil { t.Fatal(err) }
+	if _, err := r.Create(Settings{Name: "a"}); err != nil { t.Fatal(err) }
+	if _, err := r.Create(Settings{Name: "bad"}); err == nil { t.Fatal("Opened error ignored") }
+	if _, ok := r.Get("bad"); ok { t.Fatal("namespace registered after its Opened hook failed") }
+	if err := r.Drop("a"); err != nil { t.Fatal(err) }
+	if len(opened) != 1 || len(closed) != 1 { t.Fatalf("opened %v closed %v", opened, closed) }
+}
+
+func TestDropWaitsForReferences(t *testing.T) {
+	dir := t.TempDir()
+	r := openTestRegistry(t, dir)
+	if _, err := r.Create(Settings{Name: "a"}); err != nil { t.Fatal(err) }
+
+	n, release, ok := r.Acquire("a")
+	if !ok { t.Fatal("acquire failed") }
+	dropped := make(chan error, 1)
+	go func() { dropped <- r.Drop("a") }()
+
+	select {
+	case <-n.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("namespace context not cancelled")
+	}
+	if _, _, ok := r.Acquire("a"); ok { t.Fatal("acquired a namespace being dropped") }
+	if _, err := r.Create(Settings{Name: "a"}); !errors.Is(err, ErrExists) { t.Fatalf("create while dropping: %v", err) }
+	select {
+	case err := <-dropped:
+		t.Fatalf("drop returned with a reference held: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the store is still usable by the holder
+	if err := n.Store.Put(context.Background(), store.Event{Key: "k", TS: 1, Value: []byte(`{}`)}); err != nil { t.Fatal(err) }
+	release()
+	if err := <-dropped; err != nil { t.Fatal(err) }
+	if _, err := os.Stat(r.dataDir("a")); !os.IsNotExist(err) { t.Fatalf("data dir left behind: %v", err) }
+	if _, err := r.Create(Settings{Name: "a"}); err != nil { t.Fatalf("create after drop: %v", err) }
+}
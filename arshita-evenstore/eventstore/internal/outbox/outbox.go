@@ -0,0 +1,287 @@
+// Package outbox publishes committed events to Kafka. A Relay tails the
+// store's write-ahead log (the transactional outbox: a write is durable once
+// it is in the WAL) and sends events in seq order; what cannot be sent is
+// spooled to a durable local log, the Outbox, and relayed from there, oldest
+// first, once the broker is back. Delivery is at least once.
+package outbox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eventstore/internal/metrics"
+)
+
+var (
+	depth   = metrics.NewGauge("eventstore_outbox_depth", "Messages spooled in the outbox waiting to be relayed, by topic.", "topic")
+	spooled = metrics.NewCounter("eventstore_outbox_spooled_total", "Messages written to the outbox, by topic.", "topic")
+	relayed = metrics.NewCounter("eventstore_outbox_relayed_total", "Messages relayed from the outbox, by topic.", "topic")
+)
+
+const (
+	headerSize = 8        // uint32 length + uint32 CRC-32 of the record body
+	maxBatch   = 500      // messages per relay send
+	rewriteAt  = 64 << 20 // relayed bytes after which the log is rewritten without them
+)
+
+// Record is one message: its payload and the traceparent of the write it
+// came from, if any, to continue that trace on the topic.
+type Record struct {
+	Payload []byte
+	Trace   string
+}
+
+// SendFunc publishes records in order.
+type SendFunc func(ctx context.Context, recs []Record) error
+
+// encode lays r out as a spooled record body: the trace, prefixed by its
+// length in one byte, then the payload.
+func (r Record) encode() []byte {
+	t := r.Trace
+	if len(t) > 255 { t = "" } // not a traceparent
+	return append(append([]byte{byte(len(t))}, t...), r.Payload...)
+}
+
+func decodeRecord(b []byte) (Record, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) { return Record{}, errors.New("short record") }
+	n := 1 + int(b[0])
+	return Record{Trace: string(b[1:n]), Payload: b[n:]}, nil
+}
+
+// Outbox is an append-only log of pending messages, <dir>/outbox.log, and
+// the offset up to which they have been relayed, <dir>/outbox.offset.
+// Delivery is at least once: a crash between a send and saving the offset
+// sends that batch again.
+type Outbox struct {
+	topic   string
+	path    string
+	offPath string
+
+	mu     sync.Mutex
+	f      *os.File
+	off    int64 // start of the oldest pending record
+	size   int64
+	count  int
+	notify chan struct{} // signalled by Append
+}
+
+// Open loads the outbox in dir, dropping a record torn by a crash mid-write.
+func Open(dir, topic string) (*Outbox, error) {
+	o := &Outbox{topic: topic, path: filepath.Join(dir, "outbox.log"), offPath: filepath.Join(dir, "outbox.offset"), notify: make(chan struct{}, 1)}
+	if b, err := os.ReadFile(o.offPath); err == nil {
+		o.off, _ = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(o.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil { return nil, err }
+	o.f = f
+	st, err := f.Stat()
+	if err != nil { f.Close(); return nil, err }
+	if o.off > st.Size() { o.off = st.Size() }
+
+	end := o.off
+	for end < st.Size() {
+		n, err := recordAt(f, end, nil)
+		if err != nil {
+			slog.Warn("outbox: dropping torn tail", "path", o.path, "offset", end, "err", err)
+			break
+		}
+		end += n
+		o.count++
+	}
+	if end < st.Size() {
+		if err := f.Truncate(end); err != nil { f.Close(); return nil, err }
+	}
+	o.size = end
+	depth.With(topic).Set(float64(o.count))
+	return o, nil
+}
+
+// recordAt reads the record at off, passing its payload to fn if set, and
+// returns the record's size on disk.
+func recordAt(r io.ReaderAt, off int64, fn func([]byte)) (int64, error) {
+	var h [headerSize]byte
+	if _, err := r.ReadAt(h[:], off); err != nil { return 0, err }
+	n := binary.BigEndian.Uint32(h[0:4])
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off+headerSize); err != nil { return 0, err }
+	if crc32.ChecksumIEEE(b) != binary.BigEndian.Uint32(h[4:8]) { return 0, errors.New("checksum mismatch") }
+	if fn != nil { fn(b) }
+	return headerSize + int64(n), nil
+}
+
+// Append spools recs durably, in order.
+func (o *Outbox) Append(recs ...Record) error {
+	var buf []byte
+	for _, r := range recs {
+		p := r.encode()
+		var h [headerSize]byte
+		binary.BigEndian.PutUint32(h[0:4], uint32(len(p)))
+		binary.BigEndian.PutUint32(h[4:8], crc32.ChecksumIEEE(p))
+		buf = append(append(buf, h[:]...), p...)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err := o.f.Write(buf); err != nil {
+		o.f.Truncate(o.size) // do not leave a partial record behind
+		return err
+	}
+	if err := o.f.Sync(); err != nil { return err }
+	o.size += int64(len(buf))
+	o.count += len(recs)
+	depth.With(o.topic).Set(float64(o.count))
+	spooled.With(o.topic).Add(float64(len(recs)))
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Depth is the number of messages waiting to be relayed.
+func (o *Outbox) Depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.count
+}
+
+// Publish sends recs through send while nothing is spooled, and spools
+// them when send fails. Once anything is spooled, new records are spooled
+// too, so they reach the topic after the ones before them. It only fails
+// when the records could be neither sent nor spooled.
+func (o *Outbox) Publish(ctx context.Context, recs []Record, send SendFunc) error {
+	if o.Depth() > 0 { return o.Append(recs...) }
+	err := send(ctx, recs)
+	if err == nil { return nil }
+	if serr := o.Append(recs...); serr != nil { return errors.Join(err, serr) }
+	slog.Warn("kafka publish failed, spooled to outbox", "topic", o.topic, "messages", len(recs), "err", err)
+	return nil
+}
+
+// Relay sends spooled messages through send, oldest first, until ctx is
+// done. It waits while ready reports false (say, the circuit breaker is
+// open) and backs off after failed sends.
+func (o *Outbox) Relay(ctx context.Context, ready func() bool, send SendFunc) {
+	backoff := time.Second
+	for {
+		batch, end, err := o.peek()
+		if err != nil {
+			slog.Error("outbox read failed", "topic", o.topic, "err", err)
+			if !sleep(ctx, 5*time.Second) { return }
+			continue
+		}
+		if len(batch) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-o.notify:
+			}
+			continue
+		}
+		if ready != nil && !ready() {
+			if !sleep(ctx, time.Second) { return }
+			continue
+		}
+		if err := send(ctx, batch); err != nil {
+			slog.Warn("outbox relay failed, retrying", "topic", o.topic, "pending", o.Depth(), "retry_in", backoff, "err", err)
+			if !sleep(ctx, backoff) { return }
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		backoff = time.Second
+		if err := o.commit(end, len(batch)); err != nil { slog.Error("outbox offset save failed", "topic", o.topic, "err", err) }
+		relayed.With(o.topic).Add(float64(len(batch)))
+		if o.Depth() == 0 { slog.Info("outbox drained", "topic", o.topic) }
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// peek reads up to maxBatch of the oldest pending messages and the offset
+// just past them.
+func (o *Outbox) peek() ([]Record, int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var batch []Record
+	off := o.off
+	for off < o.size && len(batch) < maxBatch {
+		var body []byte
+		n, err := recordAt(o.f, off, func(b []byte) { body = b })
+		if err != nil { return nil, 0, err }
+		r, err := decodeRecord(body)
+		if err != nil { return nil, 0, err }
+		batch = append(batch, r)
+		off += n
+	}
+	return batch, off, nil
+}
+
+// commit marks the n messages before end as relayed. A drained log is
+// truncated, and one with rewriteAt bytes relayed is rewritten.
+func (o *Outbox) commit(end int64, n int) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.off = end
+	o.count -= n
+	depth.With(o.topic).Set(float64(o.count))
+	switch {
+	case o.off == o.size:
+		if err := o.f.Truncate(0); err != nil { return err }
+		o.off, o.size = 0, 0
+	case o.off >= rewriteAt:
+		if err := o.rewriteLocked(); err != nil { return err }
+	}
+	return o.saveOffsetLocked()
+}
+
+// rewriteLocked copies the pending records to a new log.
+func (o *Outbox) rewriteLocked() error {
+	tmp := o.path + ".tmp"
+	nf, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil { return err }
+	if _, err := io.Copy(nf, io.NewSectionReader(o.f, o.off, o.size-o.off)); err != nil { nf.Close(); return err }
+	if err := nf.Sync(); err != nil { nf.Close(); return err }
+	nf.Close()
+	// the offset must not point past the new, shorter log if we crash here
+	if err := os.WriteFile(o.offPath, []byte("0"), 0o644); err != nil { return err }
+	if err := os.Rename(tmp, o.path); err != nil { return err }
+	f, err := os.OpenFile(o.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil { return err }
+	o.f.Close()
+	o.f = f
+	o.size -= o.off
+	o.off = 0
+	return nil
+}
+
+func (o *Outbox) saveOffsetLocked() error {
+	tmp := o.offPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(o.off, 10)), 0o644); err != nil { return err }
+	return os.Rename(tmp, o.offPath)
+}
+
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.f.Close()
+}
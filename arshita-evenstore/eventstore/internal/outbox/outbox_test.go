@@ -0,0 +1,142 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestOutbox(t *testing.T, dir string) *Outbox {
+	t.Helper()
+	o, err := Open(dir, "t")
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { o.Close() })
+	return o
+}
+
+// sink records what was sent and fails while down is set.
+type sink struct {
+	mu   sync.Mutex
+	down bool
+	got  []string
+	recs []Record
+	sent chan struct{}
+}
+
+func newSink() *sink { return &sink{sent: make(chan struct{}, 100)} }
+
+func (s *sink) send(ctx context.Context, recs []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down { return errors.New("broker down") }
+	for _, r := range recs { s.got = append(s.got, string(r.Payload)) }
+	s.recs = append(s.recs, recs...)
+	s.sent <- struct{}{}
+	return nil
+}
+
+func (s *sink) setDown(down bool) {
+	s.mu.Lock()
+	s.down = down
+	s.mu.Unlock()
+}
+
+func (s *sink) received() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fmt.Sprint(s.got)
+}
+
+func records(ss ...string) []Record {
+	out := make([]Record, len(ss))
+	for i, s := range ss { out[i] = Record{Payload: []byte(s), Trace: "tp-" + s} }
+	return out
+}
+
+func TestPublishSpoolsInOrder(t *testing.T) {
+	o := openTestOutbox(t, t.TempDir())
+	k := newSink()
+	ctx := context.Background()
+
+	if err := o.Publish(ctx, records("a"), k.send); err != nil { t.Fatal(err) }
+	k.setDown(true)
+	if err := o.Publish(ctx, records("b", "c"), k.send); err != nil { t.Fatal(err) }
+	k.setDown(false)
+	// the broker is back, but "d" must not overtake the spooled messages
+	if err := o.Publish(ctx, records("d"), k.send); err != nil { t.Fatal(err) }
+	if got := k.received(); got != "[a]" { t.Fatalf("sent directly: %s", got) }
+	if o.Depth() != 3 { t.Fatalf("depth %d", o.Depth()) }
+
+	rctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() { o.Relay(rctx, nil, k.send); close(done) }()
+	deadline := time.After(5 * time.Second)
+	for o.Depth() > 0 {
+		select {
+		case <-k.sent:
+		case <-deadline:
+			t.Fatalf("spool not drained, depth %d", o.Depth())
+		}
+	}
+	cancel()
+	<-done
+	if got := k.received(); got != "[a b c d]" { t.Fatalf("received %s", got) }
+	for _, r := range k.recs {
+		if r.Trace != "tp-"+string(r.Payload) { t.Fatalf("trace lost: %q for %q", r.Trace, r.Payload) }
+	}
+	if fi, _ := os.Stat(o.path); fi.Size() != 0 { t.Fatalf("drained log not truncated: %d bytes", fi.Size()) }
+}
+
+func TestReopenResumesAndDropsTornTail(t *testing.T) {
+	dir := t.TempDir()
+	o := openTestOutbox(t, dir)
+	if err := o.Append(records("a", "b", "c")...); err != nil { t.Fatal(err) }
+	batch, end, err := o.peek()
+	if err != nil || len(batch) != 3 { t.Fatalf("peek: %d, %v", len(batch), err) }
+	// relay "a" only
+	first, _ := recordAt(o.f, 0, nil)
+	if err := o.commit(first, 1); err != nil { t.Fatal(err) }
+	o.Close()
+
+	// a crash mid-append leaves half a record
+	f, _ := os.OpenFile(filepath.Join(dir, "outbox.log"), os.O_APPEND|os.O_WRONLY, 0)
+	f.Write([]byte{0, 0, 0, 9, 1, 2})
+	f.Close()
+
+	o2 := openTestOutbox(t, dir)
+	if o2.Depth() != 2 { t.Fatalf("depth after reopen: %d", o2.Depth()) }
+	batch, end2, err := o2.peek()
+	if err != nil || len(batch) != 2 || string(batch[0].Payload) != "b" || batch[1].Trace != "tp-c" { t.Fatalf("pending after reopen: %+v, %v", batch, err) }
+	if end2 != end { t.Fatalf("torn tail kept: end %d, want %d", end2, end) }
+}
+
+func TestRelayWaitsForReady(t *testing.T) {
+	o := openTestOutbox(t, t.TempDir())
+	if err := o.Append(records("a")...); err != nil { t.Fatal(err) }
+	k := newSink()
+	var mu sync.Mutex
+	ready := false
+	isReady := func() bool { mu.Lock(); defer mu.Unlock(); return ready }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.Relay(ctx, isReady, k.send)
+	select {
+	case <-k.sent:
+		t.Fatal("relayed while not ready")
+	case <-time.After(50 * time.Millisecond):
+	}
+	mu.Lock()
+	ready = true
+	mu.Unlock()
+	select {
+	case <-k.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not relayed once ready")
+	}
+}
@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"eventstore/internal/store"
+)
+
+// Message is the Kafka payload for an event.
+type Message struct {
+	Key   string          `json:"key"`
+	TS    int64           `json:"ts"`
+	Value json.RawMessage `json:"value"`
+	Seq   uint64          `json:"seq"`
+}
+
+// Relay tails a store's committed events and publishes them through an
+// Outbox: straight to the topic while it is reachable, spooled while not.
+// Each record carries the trace of the write that committed its event, as
+// recorded in the WAL.
+// The store keeps its WAL only until events are sent or spooled, and
+// records in published.seq how far that got.
+type Relay struct {
+	s     *store.LSMStore
+	ob    *Outbox
+	ready func() bool // may be nil
+	send  SendFunc
+
+	mu      sync.Mutex
+	lastErr error // last failure to send or spool, cleared by a success
+}
+
+// New turns on WAL retention in s and returns its relay. ready, if set, is
+// polled before relaying spooled messages, e.g. to wait while a circuit
+// breaker is open.
+func New(s *store.LSMStore, ob *Outbox, ready func() bool, send SendFunc) (*Relay, error) {
+	if err := s.RetainWAL(); err != nil { return nil, err }
+	return &Relay{s: s, ob: ob, ready: ready, send: send}, nil
+}
+
+// Run relays until ctx is done, draining the spool alongside. Events that
+// could be neither sent nor spooled are tried again, with backoff, before
+// anything after them.
+func (r *Relay) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.ob.Relay(ctx, r.ready, r.send)
+	}()
+	defer wg.Wait()
+
+	changes := r.s.ChangesAfter(r.s.Published())
+	backoff := time.Second
+	var pending []Record
+	var upTo uint64
+	for {
+		if len(pending) == 0 {
+			evs, err := changes.Next(maxBatch)
+			if err != nil {
+				r.fail(err)
+				if !sleep(ctx, 5*time.Second) { return }
+				continue
+			}
+			if len(evs) == 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-r.s.Commits():
+				}
+				continue
+			}
+			for _, e := range evs {
+				b, _ := json.Marshal(Message{Key: e.Key, TS: e.TS, Value: e.Value, Seq: e.Seq})
+				pending = append(pending, Record{Payload: b, Trace: e.Trace})
+			}
+			upTo = evs[len(evs)-1].Seq
+		}
+		if err := r.ob.Publish(ctx, pending, r.send); err != nil {
+			if ctx.Err() != nil { return }
+			r.fail(err)
+			slog.Error("outbox relay could not send or spool, retrying", "topic", r.ob.topic, "events", len(pending), "retry_in", backoff, "err", err)
+			if !sleep(ctx, backoff) { return }
+			backoff = min(backoff*2, 30*time.Second)
+			continue
+		}
+		pending, backoff = nil, time.Second
+		r.fail(nil)
+		if err := r.s.MarkPublished(upTo); err != nil { slog.Error("outbox offset save failed", "topic", r.ob.topic, "err", err) }
+	}
+}
+
+func (r *Relay) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastErr = err
+}
+
+// Check fails while messages wait in the spool or events wait behind a
+// failure to send or spool them.
+func (r *Relay) Check() error {
+	if n := r.ob.Depth(); n > 0 { return fmt.Errorf("%d messages waiting to be relayed", n) }
+	r.mu.Lock()
+	err := r.lastErr
+	r.mu.Unlock()
+	if err == nil { return nil }
+	if st := r.s.Stats(); st.LastSeq > st.PublishedSeq { return fmt.Errorf("%d events waiting: %w", st.LastSeq-st.PublishedSeq, err) }
+	return nil
+}
@@ -0,0 +1,105 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"eventstore/internal/store"
+	"eventstore/internal/trace"
+)
+
+func (s *sink) seqs(t *testing.T) string {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []uint64
+	for _, p := range s.got {
+		var m Message
+		if err := json.Unmarshal([]byte(p), &m); err != nil { t.Fatal(err) }
+		out = append(out, m.Seq)
+	}
+	return fmt.Sprint(out)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) { t.Fatalf("timed out waiting for %s", what) }
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// While the broker is down events are spooled and the WAL released for
+// them; once it is back they arrive in seq order ahead of newer ones.
+func TestRelaySpoolsWhileBrokerDown(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewLSMStore(store.Options{DataDir: dir, MemtableMaxItems: 2})
+	if err != nil { t.Fatal(err) }
+	defer s.Close()
+	o := openTestOutbox(t, dir)
+	k := newSink()
+	k.setDown(true)
+	r, err := New(s, o, nil, k.send)
+	if err != nil { t.Fatal(err) }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { r.Run(ctx); close(done) }()
+	defer func() { cancel(); <-done }()
+
+	put := func(i int) {
+		if err := s.Put(ctx, store.Event{Key: fmt.Sprint("k", i), TS: int64(i), Value: []byte(`{}`)}); err != nil { t.Fatal(err) }
+	}
+	for i := 1; i <= 5; i++ { put(i) }
+	waitFor(t, "events spooled", func() bool { return s.Published() == 5 && o.Depth() == 5 })
+	if st := s.Stats(); st.WALArchives != 0 { t.Fatalf("WAL archives kept for spooled events: %d", st.WALArchives) }
+	if err := r.Check(); err == nil || !strings.Contains(err.Error(), "5 messages") { t.Fatalf("check: %v", err) }
+
+	k.setDown(false)
+	waitFor(t, "spool drained", func() bool { return o.Depth() == 0 })
+	put(6)
+	waitFor(t, "event 6", func() bool { return s.Published() == 6 })
+	waitFor(t, "event 6 sent", func() bool { return strings.HasSuffix(k.seqs(t), "6]") })
+	if got := k.seqs(t); got != "[1 2 3 4 5 6]" { t.Fatalf("received %s", got) }
+	if err := r.Check(); err != nil { t.Fatalf("check after drain: %v", err) }
+}
+
+// Each event keeps the trace of the request that wrote it, through the WAL
+// and the spool.
+func TestRelayCarriesEachEventsTrace(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewLSMStore(store.Options{DataDir: dir, MemtableMaxItems: 100})
+	if err != nil { t.Fatal(err) }
+	defer s.Close()
+	o := openTestOutbox(t, dir)
+	k := newSink()
+	k.setDown(true)
+	r, err := New(s, o, nil, k.send)
+	if err != nil { t.Fatal(err) }
+
+	var want []trace.TraceID
+	for i := 1; i <= 3; i++ {
+		ctx, span := trace.Start(context.Background(), "request", trace.KindServer)
+		if err := s.Put(ctx, store.Event{Key: fmt.Sprint("k", i), TS: int64(i), Value: []byte(`{}`)}); err != nil { t.Fatal(err) }
+		span.End()
+		want = append(want, span.Context().TraceID)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { r.Run(ctx); close(done) }()
+	defer func() { cancel(); <-done }()
+	waitFor(t, "events spooled", func() bool { return o.Depth() == 3 })
+	k.setDown(false)
+	waitFor(t, "spool drained", func() bool { return o.Depth() == 0 })
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i, rec := range k.recs {
+		sc, ok := trace.ParseTraceparent(rec.Trace)
+		if !ok || sc.TraceID != want[i] { t.Errorf("event %d: trace %q, want trace id %s", i+1, rec.Trace, want[i]) }
+	}
+}
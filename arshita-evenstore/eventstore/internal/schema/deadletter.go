@@ -0,0 +1,69 @@
+package schema
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DeadLetter is an ingested message that could not be stored.
+type DeadLetter struct {
+	ReceivedAt time.Time       `json:"received_at"`
+	Source     string          `json:"source"` // e.g. "kafka:events-in"
+	Error      string          `json:"error"`
+	Details    any             `json:"details,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// DeadLetters is an append-only NDJSON file, <DATA_DIR>/deadletter.log.
+type DeadLetters struct {
+	mu   sync.Mutex
+	path string
+}
+
+func OpenDeadLetters(dataDir string) *DeadLetters {
+	return &DeadLetters{path: filepath.Join(dataDir, "deadletter.log")}
+}
+
+func (d *DeadLetters) Append(dl DeadLetter) error {
+	if dl.ReceivedAt.IsZero() { dl.ReceivedAt = time.Now().UTC() }
+	if !json.Valid(dl.Payload) {
+		// keep raw bytes readable without breaking the NDJSON line
+		b, _ := json.Marshal(string(dl.Payload))
+		dl.Payload = b
+	}
+	b, err := json.Marshal(dl)
+	if err != nil { return err }
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil { return err }
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// Tail returns up to limit of the most recent dead letters, oldest first.
+func (d *DeadLetters) Tail(limit int) ([]DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.Open(d.path)
+	if os.IsNotExist(err) { return nil, nil }
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	out := []DeadLetter{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1<<20), 1<<25)
+	for sc.Scan() {
+		var dl DeadLetter
+		if err := json.Unmarshal(sc.Bytes(), &dl); err != nil { continue }
+		out = append(out, dl)
+		if limit > 0 && len(out) > limit { out = out[1:] }
+	}
+	return out, sc.Err()
+}
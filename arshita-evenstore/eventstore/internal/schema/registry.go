@@ -0,0 +1,332 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"eventstore/internal/store"
+)
+
+// Compatibility modes for new schema versions of a subject.
+const (
+	CompatBackward = "BACKWARD" // the new version must accept everything the previous one did
+	CompatNone     = "NONE"
+)
+
+// Subject binds events to a versioned schema, either by the value's "type"
+// field or by key prefix (type wins when both match).
+type Subject struct {
+	Name          string    `json:"name"`
+	EventType     string    `json:"event_type,omitempty"`
+	KeyPrefix     string    `json:"key_prefix,omitempty"`
+	Compatibility string    `json:"compatibility"`
+	Versions      []Version `json:"versions"`
+}
+
+type Version struct {
+	Version   int             `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Registration is a request to add a version to a subject (creating it if
+// needed).
+type Registration struct {
+	EventType     string          `json:"event_type,omitempty"`
+	KeyPrefix     string          `json:"key_prefix,omitempty"`
+	Compatibility string          `json:"compatibility,omitempty"`
+	Schema        json.RawMessage `json:"schema"`
+}
+
+var (
+	ErrNotFound  = errors.New("schema subject not found")
+	ErrInvalid   = errors.New("invalid schema registration")
+	ErrTypeTaken = errors.New("event_type already bound to another subject")
+)
+
+// IncompatibleError lists why a new version breaks the previous one.
+type IncompatibleError struct {
+	Subject string
+	Reasons []string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("schema for %s is not %s compatible: %s", e.Subject, CompatBackward, strings.Join(e.Reasons, "; "))
+}
+
+// RejectedError is returned by Validate when an event fails its schema.
+type RejectedError struct {
+	Subject string            `json:"subject"`
+	Version int               `json:"version"`
+	Errors  []ValidationError `json:"errors"`
+}
+
+func (e *RejectedError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, ve := range e.Errors { msgs[i] = ve.Error() }
+	return fmt.Sprintf("event does not match schema %s v%d: %s", e.Subject, e.Version, strings.Join(msgs, "; "))
+}
+
+// Registry holds the subjects, persisted in <DATA_DIR>/schemas.json.
+type Registry struct {
+	path string
+
+	mu       sync.RWMutex
+	subjects map[string]*Subject
+	compiled map[string]*Schema // latest version per subject
+}
+
+type registryFile struct {
+	Subjects []*Subject `json:"subjects"`
+}
+
+func Open(dataDir string) (*Registry, error) {
+	r := &Registry{path: filepath.Join(dataDir, "schemas.json"), subjects: map[string]*Subject{}, compiled: map[string]*Schema{}}
+	b, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) { return r, nil }
+	if err != nil { return nil, err }
+	var f registryFile
+	if err := json.Unmarshal(b, &f); err != nil { return nil, fmt.Errorf("schemas.json: %w", err) }
+	for _, s := range f.Subjects {
+		if len(s.Versions) == 0 { continue }
+		c, err := Compile(s.Versions[len(s.Versions)-1].Schema)
+		if err != nil { return nil, fmt.Errorf("schema %s: %w", s.Name, err) }
+		r.subjects[s.Name] = s
+		r.compiled[s.Name] = c
+	}
+	return r, nil
+}
+
+// Register adds a new version to subject after checking it compiles and is
+// compatible with the latest version. The subject is only changed (including
+// its compatibility mode) when the registration succeeds.
+func (r *Registry) Register(subject string, reg Registration) (Version, error) {
+	if subject == "" || strings.ContainsAny(subject, "/ ") {
+		return Version{}, fmt.Errorf("%w: bad subject name %q", ErrInvalid, subject)
+	}
+	next, err := Compile(reg.Schema)
+	if err != nil { return Version{}, fmt.Errorf("%w: %v", ErrInvalid, err) }
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var s Subject
+	if cur, ok := r.subjects[subject]; ok {
+		s = *cur
+		s.Versions = append([]Version(nil), cur.Versions...)
+	} else {
+		if reg.EventType == "" && reg.KeyPrefix == "" {
+			return Version{}, fmt.Errorf("%w: event_type or key_prefix required", ErrInvalid)
+		}
+		// one subject per type, or Validate could pick either
+		for _, o := range r.subjects {
+			if reg.EventType != "" && o.EventType == reg.EventType {
+				return Version{}, fmt.Errorf("%w: %q is used by %s", ErrTypeTaken, reg.EventType, o.Name)
+			}
+		}
+		s = Subject{Name: subject, EventType: reg.EventType, KeyPrefix: reg.KeyPrefix, Compatibility: CompatBackward}
+	}
+	if reg.Compatibility != "" {
+		if reg.Compatibility != CompatBackward && reg.Compatibility != CompatNone {
+			return Version{}, fmt.Errorf("%w: compatibility must be %s or %s", ErrInvalid, CompatBackward, CompatNone)
+		}
+		s.Compatibility = reg.Compatibility
+	}
+	if prev := r.compiled[subject]; prev != nil && s.Compatibility == CompatBackward {
+		if reasons := backwardCompatible(prev, next, ""); len(reasons) > 0 {
+			return Version{}, &IncompatibleError{Subject: subject, Reasons: reasons}
+		}
+	}
+
+	v := Version{Version: len(s.Versions) + 1, Schema: reg.Schema, CreatedAt: time.Now().UTC()}
+	s.Versions = append(s.Versions, v)
+	prev, prevCompiled := r.subjects[subject], r.compiled[subject]
+	r.subjects[subject], r.compiled[subject] = &s, next
+	if err := r.saveLocked(); err != nil {
+		if prev == nil {
+			delete(r.subjects, subject)
+			delete(r.compiled, subject)
+		} else {
+			r.subjects[subject], r.compiled[subject] = prev, prevCompiled
+		}
+		return Version{}, err
+	}
+	return v, nil
+}
+
+func (r *Registry) Delete(subject string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subjects[subject]; !ok { return ErrNotFound }
+	delete(r.subjects, subject)
+	delete(r.compiled, subject)
+	return r.saveLocked()
+}
+
+func (r *Registry) Subject(name string) (Subject, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.subjects[name]
+	if !ok { return Subject{}, false }
+	return *s, true
+}
+
+func (r *Registry) Subjects() []Subject {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Subject, 0, len(r.subjects))
+	for _, s := range r.subjects { out = append(out, *s) }
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Validate checks e against the latest schema of the subject it maps to.
+// Events no subject claims are accepted.
+func (r *Registry) Validate(e store.Event) error {
+	var typed struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(e.Value, &typed)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := r.matchLocked(typed.Type, e.Key)
+	if s == nil { return nil }
+	if errs := r.compiled[s.Name].Validate(e.Value); len(errs) > 0 {
+		return &RejectedError{Subject: s.Name, Version: len(s.Versions), Errors: errs}
+	}
+	return nil
+}
+
+// matchLocked picks the subject for an event: the one bound to its type, else
+// the longest matching key prefix. Ties (possible in files written before
+// types were unique) go to the first subject by name.
+func (r *Registry) matchLocked(eventType, key string) *Subject {
+	var typed, best *Subject
+	for _, s := range r.subjects {
+		if s.EventType != "" && s.EventType == eventType {
+			if typed == nil || s.Name < typed.Name { typed = s }
+		}
+		if s.KeyPrefix != "" && strings.HasPrefix(key, s.KeyPrefix) {
+			if best == nil || len(s.KeyPrefix) > len(best.KeyPrefix) || len(s.KeyPrefix) == len(best.KeyPrefix) && s.Name < best.Name { best = s }
+		}
+	}
+	if typed != nil { return typed }
+	return best
+}
+
+func (r *Registry) saveLocked() error {
+	f := registryFile{Subjects: make([]*Subject, 0, len(r.subjects))}
+	for _, s := range r.subjects { f.Subjects = append(f.Subjects, s) }
+	sort.Slice(f.Subjects, func(i, j int) bool { return f.Subjects[i].Name < f.Subjects[j].Name })
+	b, _ := json.MarshalIndent(f, "", "  ")
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil { return err }
+	return os.Rename(tmp, r.path)
+}
+
+// backwardCompatible reports the ways in which next rejects documents prev
+// accepted. It is conservative: any keyword next adds or narrows counts, even
+// where prev's other keywords already ruled out what it rejects.
+func backwardCompatible(prev, next *Schema, at string) []string {
+	var out []string
+	where := at
+	if where == "" { where = "/" }
+
+	if len(next.types) > 0 {
+		for _, t := range effectiveTypes(prev) {
+			if !next.allowsType(t) { out = append(out, fmt.Sprintf("%s: type %s no longer allowed", where, t)) }
+		}
+	}
+	prevReq := map[string]bool{}
+	for _, r := range prev.required { prevReq[r] = true }
+	for _, r := range next.required {
+		if !prevReq[r] { out = append(out, fmt.Sprintf("%s: new required property %q", where, r)) }
+	}
+	if next.additional != nil && !*next.additional {
+		if prev.additional == nil || *prev.additional {
+			out = append(out, fmt.Sprintf("%s: additionalProperties turned off", where))
+		}
+		for name := range prev.properties {
+			if _, ok := next.properties[name]; !ok {
+				out = append(out, fmt.Sprintf("%s: property %q removed while additionalProperties is false", where, name))
+			}
+		}
+	}
+	if next.enum != nil {
+		if prev.enum == nil {
+			out = append(out, fmt.Sprintf("%s: enum added", where))
+		} else {
+			for _, v := range prev.enum {
+				found := false
+				for _, w := range next.enum {
+					if equalJSON(v, w) { found = true; break }
+				}
+				if !found { out = append(out, fmt.Sprintf("%s: enum value %v removed", where, v)) }
+			}
+		}
+	}
+	out = append(out, tightened(where, "minimum", prev.minimum, next.minimum, true)...)
+	out = append(out, tightened(where, "maximum", prev.maximum, next.maximum, false)...)
+	out = append(out, tightenedInt(where, "minLength", prev.minLength, next.minLength, true)...)
+	out = append(out, tightenedInt(where, "maxLength", prev.maxLength, next.maxLength, false)...)
+	out = append(out, tightened(where, "exclusiveMinimum", prev.exclMin, next.exclMin, true)...)
+	out = append(out, tightened(where, "exclusiveMaximum", prev.exclMax, next.exclMax, false)...)
+	out = append(out, tightenedInt(where, "minItems", prev.minItems, next.minItems, true)...)
+	out = append(out, tightenedInt(where, "maxItems", prev.maxItems, next.maxItems, false)...)
+	if next.pattern != nil && (prev.pattern == nil || prev.pattern.String() != next.pattern.String()) {
+		out = append(out, fmt.Sprintf("%s: pattern added or changed", where))
+	}
+	if next.constant != nil && (prev.constant == nil || !equalJSON(*prev.constant, *next.constant)) {
+		out = append(out, fmt.Sprintf("%s: const added or changed", where))
+	}
+
+	// A property or items schema next adds constrains values prev took as
+	// anything, unless additionalProperties false already kept them out.
+	for name, np := range next.properties {
+		pp, ok := prev.properties[name]
+		if !ok && prev.additional != nil && !*prev.additional { continue }
+		if !ok { pp = &Schema{} }
+		out = append(out, backwardCompatible(pp, np, at+"/"+name)...)
+	}
+	if next.items != nil {
+		pi := prev.items
+		if pi == nil { pi = &Schema{} }
+		out = append(out, backwardCompatible(pi, next.items, at+"/items")...)
+	}
+	return out
+}
+
+func effectiveTypes(s *Schema) []string {
+	if len(s.types) > 0 { return s.types }
+	return []string{"object", "array", "string", "number", "boolean", "null"}
+}
+
+func (s *Schema) allowsType(t string) bool {
+	for _, have := range s.types {
+		if have == t || (have == "number" && t == "integer") { return true }
+	}
+	return false
+}
+
+func tightened(at, kw string, prev, next *float64, lower bool) []string {
+	if next == nil { return nil }
+	if prev == nil || (lower && *next > *prev) || (!lower && *next < *prev) {
+		return []string{fmt.Sprintf("%s: %s tightened", at, kw)}
+	}
+	return nil
+}
+
+func tightenedInt(at, kw string, prev, next *int, lower bool) []string {
+	if next == nil { return nil }
+	var p *float64
+	if prev != nil { f := float64(*prev); p = &f }
+	n := float64(*next)
+	return tightened(at, kw, p, &n, lower)
+}
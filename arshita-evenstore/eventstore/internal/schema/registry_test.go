@@ -0,0 +1,159 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"eventstore/internal/store"
+)
+
+func openTestRegistry(t *testing.T, dir string) *Registry {
+	t.Helper()
+	r, err := Open(dir)
+	if err != nil { t.Fatal(err) }
+	return r
+}
+
+func register(r *Registry, subject, schema string) error {
+	_, err := r.Register(subject, Registration{EventType: subject, Schema: json.RawMessage(schema)})
+	return err
+}
+
+func TestBackwardCompatibility(t *testing.T) {
+	base := `{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"n":{"type":"integer","minimum":0},"kind":{"enum":["a","b"]}}}`
+	for _, c := range []struct {
+		name, next string
+		ok         bool
+	}{
+		{"same", base, true},
+		{"unconstrained property added", `{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"n":{"type":"integer","minimum":0},"kind":{"enum":["a","b"]},"x":{}}}`, true},
+		{"typed property added", `{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"n":{"type":"integer","minimum":0},"kind":{"enum":["a","b"]},"x":{"type":"string"}}}`, false},
+		{"required dropped", `{"type":"object","properties":{"id":{"type":"string"}}}`, true},
+		{"minimum relaxed", `{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"n":{"type":"integer","minimum":-5}}}`, true},
+		{"enum widened", `{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"kind":{"enum":["a","b","c"]}}}`, true},
+		{"new required", `{"type":"object","required":["id","n"],"properties":{"id":{"type":"string"}}}`, false},
+		{"type narrowed", `{"type":"object","required":["id"],"properties":{"id":{"type":"integer"}}}`, false},
+		{"minimum tightened", `{"type":"object","required":["id"],"properties":{"n":{"type":"integer","minimum":1}}}`, false},
+		{"enum value removed", `{"type":"object","required":["id"],"properties":{"kind":{"enum":["a"]}}}`, false},
+		{"additionalProperties off", `{"type":"object","required":["id"],"additionalProperties":false,"properties":{"id":{"type":"string"},"n":{"type":"integer"},"kind":{"enum":["a","b"]}}}`, false},
+		{"pattern added", `{"type":"object","required":["id"],"properties":{"id":{"type":"string","pattern":"^x"}}}`, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := openTestRegistry(t, t.TempDir())
+			if err := register(r, "orders", base); err != nil { t.Fatal(err) }
+			err := register(r, "orders", c.next)
+			var inc *IncompatibleError
+			if c.ok && err != nil { t.Fatalf("rejected: %v", err) }
+			if !c.ok && !errors.As(err, &inc) { t.Fatalf("accepted or wrong error: %v", err) }
+		})
+	}
+}
+
+func checkCompatible(t *testing.T, prev, next string, ok bool) {
+	t.Helper()
+	p, err := Compile(json.RawMessage(prev))
+	if err != nil { t.Fatal(err) }
+	n, err := Compile(json.RawMessage(next))
+	if err != nil { t.Fatal(err) }
+	if reasons := backwardCompatible(p, n, ""); ok != (len(reasons) == 0) { t.Errorf("%s -> %s: %v", prev, next, reasons) }
+}
+
+func TestBackwardCompatibleExclusiveMinimum(t *testing.T) {
+	checkCompatible(t, `{"exclusiveMinimum":0}`, `{"exclusiveMinimum":-1}`, true)
+	checkCompatible(t, `{"exclusiveMinimum":0}`, `{"exclusiveMinimum":1}`, false)
+	checkCompatible(t, `{}`, `{"exclusiveMinimum":0}`, false)
+}
+
+func TestBackwardCompatibleExclusiveMaximum(t *testing.T) {
+	checkCompatible(t, `{"exclusiveMaximum":10}`, `{"exclusiveMaximum":11}`, true)
+	checkCompatible(t, `{"exclusiveMaximum":10}`, `{"exclusiveMaximum":9}`, false)
+	checkCompatible(t, `{}`, `{"exclusiveMaximum":10}`, false)
+}
+
+func TestBackwardCompatibleMinItems(t *testing.T) {
+	checkCompatible(t, `{"minItems":2}`, `{"minItems":1}`, true)
+	checkCompatible(t, `{"minItems":2}`, `{"minItems":3}`, false)
+	checkCompatible(t, `{}`, `{"minItems":1}`, false)
+}
+
+func TestBackwardCompatibleMaxItems(t *testing.T) {
+	checkCompatible(t, `{"maxItems":2}`, `{"maxItems":3}`, true)
+	checkCompatible(t, `{"maxItems":2}`, `{"maxItems":1}`, false)
+	checkCompatible(t, `{}`, `{"maxItems":2}`, false)
+}
+
+func TestBackwardCompatibleConst(t *testing.T) {
+	checkCompatible(t, `{"const":{"a":1}}`, `{"const":{"a":1}}`, true)
+	checkCompatible(t, `{"const":{"a":1}}`, `{}`, true)
+	checkCompatible(t, `{"const":1}`, `{"const":2}`, false)
+	checkCompatible(t, `{}`, `{"const":1}`, false)
+}
+
+func TestBackwardCompatibleItems(t *testing.T) {
+	checkCompatible(t, `{"items":{"type":"string"}}`, `{}`, true)
+	checkCompatible(t, `{"type":"array"}`, `{"type":"array","items":{}}`, true)
+	checkCompatible(t, `{"type":"array"}`, `{"type":"array","items":{"type":"string"}}`, false)
+	checkCompatible(t, `{"items":{"minLength":1}}`, `{"items":{"minLength":2}}`, false)
+}
+
+func TestBackwardCompatibleAddedProperty(t *testing.T) {
+	checkCompatible(t, `{"type":"object"}`, `{"type":"object","properties":{"x":{}}}`, true)
+	checkCompatible(t, `{"type":"object"}`, `{"type":"object","properties":{"x":{"type":"string"}}}`, false)
+	checkCompatible(t, `{"type":"object","additionalProperties":true}`, `{"type":"object","additionalProperties":true,"properties":{"x":{"type":"string"}}}`, false)
+	// x was rejected outright before, so any schema for it accepts more.
+	checkCompatible(t, `{"type":"object","additionalProperties":false}`, `{"type":"object","additionalProperties":false,"properties":{"x":{"type":"string"}}}`, true)
+}
+
+// A rejected registration must leave the subject exactly as it was.
+func TestRejectedRegistrationKeepsSubject(t *testing.T) {
+	dir := t.TempDir()
+	r := openTestRegistry(t, dir)
+	if err := register(r, "orders", `{"type":"object"}`); err != nil { t.Fatal(err) }
+	_, err := r.Register("orders", Registration{Compatibility: CompatNone, Schema: json.RawMessage(`{"type":"object","required":["id"]}`)})
+	if err != nil { t.Fatal(err) } // NONE allows it
+	_, err = r.Register("orders", Registration{Compatibility: CompatBackward, Schema: json.RawMessage(`{"type":"object","required":["id","n"]}`)})
+	var inc *IncompatibleError
+	if !errors.As(err, &inc) { t.Fatalf("want incompatible, got %v", err) }
+	s, _ := r.Subject("orders")
+	if s.Compatibility != CompatNone || len(s.Versions) != 2 { t.Fatalf("subject changed by a rejected registration: %s, %d versions", s.Compatibility, len(s.Versions)) }
+
+	_, err = r.Register("orders", Registration{Compatibility: "FORWARD", Schema: json.RawMessage(`{"type":"object"}`)})
+	if !errors.Is(err, ErrInvalid) { t.Fatalf("bad mode: %v", err) }
+	if s, _ := openTestRegistry(t, dir).Subject("orders"); s.Compatibility != CompatNone || len(s.Versions) != 2 { t.Fatalf("persisted: %s, %d versions", s.Compatibility, len(s.Versions)) }
+}
+
+func TestEventTypeBoundOnce(t *testing.T) {
+	r := openTestRegistry(t, t.TempDir())
+	if _, err := r.Register("a", Registration{EventType: "OrderPlaced", Schema: json.RawMessage(`{"type":"object","required":["id"]}`)}); err != nil { t.Fatal(err) }
+	_, err := r.Register("b", Registration{EventType: "OrderPlaced", Schema: json.RawMessage(`{"type":"object"}`)})
+	if !errors.Is(err, ErrTypeTaken) { t.Fatalf("second subject for the type: %v", err) }
+	if _, ok := r.Subject("b"); ok { t.Fatal("subject b created") }
+}
+
+func TestValidateMatchesTypeThenLongestPrefix(t *testing.T) {
+	r := openTestRegistry(t, t.TempDir())
+	regs := map[string]Registration{
+		"typed":   {EventType: "Signup", Schema: json.RawMessage(`{"type":"object","required":["email"]}`)},
+		"user":    {KeyPrefix: "user-", Schema: json.RawMessage(`{"type":"object","required":["name"]}`)},
+		"userVIP": {KeyPrefix: "user-vip-", Schema: json.RawMessage(`{"type":"object","required":["tier"]}`)},
+	}
+	for name, reg := range regs {
+		if _, err := r.Register(name, reg); err != nil { t.Fatal(err) }
+	}
+	for _, c := range []struct{ key, value, subject string }{
+		{"user-1", `{"type":"Signup"}`, "typed"},
+		{"user-1", `{}`, "user"},
+		{"user-vip-1", `{"name":"x"}`, "userVIP"},
+		{"order-1", `{}`, ""},
+	} {
+		err := r.Validate(store.Event{Key: c.key, Value: json.RawMessage(c.value)})
+		var rej *RejectedError
+		switch {
+		case c.subject == "" && err != nil:
+			t.Errorf("%s %s: %v", c.key, c.value, err)
+		case c.subject != "" && (!errors.As(err, &rej) || rej.Subject != c.subject):
+			t.Errorf("%s %s: want rejection by %s, got %v", c.key, c.value, c.subject, err)
+		}
+	}
+}
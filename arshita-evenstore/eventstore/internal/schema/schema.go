@@ -0,0 +1,247 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema. Supported keywords: type, properties,
+// required, additionalProperties (boolean), items, enum, const, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern,
+// minItems, maxItems. Unknown keywords are ignored.
+type Schema struct {
+	types      []string
+	properties map[string]*Schema
+	required   []string
+	additional *bool
+	items      *Schema
+	enum       []any
+	constant   *any
+	minimum    *float64
+	maximum    *float64
+	exclMin    *float64
+	exclMax    *float64
+	minLength  *int
+	maxLength  *int
+	pattern    *regexp.Regexp
+	minItems   *int
+	maxItems   *int
+}
+
+// ValidationError points at the offending value with a JSON-pointer-like path.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" { return e.Message }
+	return e.Path + ": " + e.Message
+}
+
+type rawSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	Enum                 []any                      `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	ExclusiveMinimum     *float64                   `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64                   `json:"exclusiveMaximum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              string                     `json:"pattern"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+}
+
+var knownTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Compile parses a JSON Schema document.
+func Compile(doc json.RawMessage) (*Schema, error) { return compile(doc, "#") }
+
+func compile(doc json.RawMessage, at string) (*Schema, error) {
+	var raw rawSchema
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, fmt.Errorf("%s: schema must be an object: %v", at, err)
+	}
+	s := &Schema{
+		required:  raw.Required,
+		enum:      raw.Enum,
+		minimum:   raw.Minimum,
+		maximum:   raw.Maximum,
+		exclMin:   raw.ExclusiveMinimum,
+		exclMax:   raw.ExclusiveMaximum,
+		minLength: raw.MinLength,
+		maxLength: raw.MaxLength,
+		minItems:  raw.MinItems,
+		maxItems:  raw.MaxItems,
+	}
+	if len(raw.Type) > 0 {
+		var one string
+		if err := json.Unmarshal(raw.Type, &one); err == nil {
+			s.types = []string{one}
+		} else if err := json.Unmarshal(raw.Type, &s.types); err != nil {
+			return nil, fmt.Errorf("%s/type: must be a string or array of strings", at)
+		}
+		for _, t := range s.types {
+			if !knownTypes[t] { return nil, fmt.Errorf("%s/type: unknown type %q", at, t) }
+		}
+	}
+	if len(raw.Properties) > 0 {
+		s.properties = make(map[string]*Schema, len(raw.Properties))
+		for name, sub := range raw.Properties {
+			c, err := compile(sub, at+"/properties/"+name)
+			if err != nil { return nil, err }
+			s.properties[name] = c
+		}
+	}
+	if len(raw.AdditionalProperties) > 0 {
+		var b bool
+		if err := json.Unmarshal(raw.AdditionalProperties, &b); err != nil {
+			return nil, fmt.Errorf("%s/additionalProperties: only booleans are supported", at)
+		}
+		s.additional = &b
+	}
+	if len(raw.Items) > 0 {
+		c, err := compile(raw.Items, at+"/items")
+		if err != nil { return nil, err }
+		s.items = c
+	}
+	if len(raw.Const) > 0 {
+		var v any
+		if err := json.Unmarshal(raw.Const, &v); err != nil { return nil, fmt.Errorf("%s/const: %v", at, err) }
+		s.constant = &v
+	}
+	if raw.Pattern != "" {
+		re, err := regexp.Compile(raw.Pattern)
+		if err != nil { return nil, fmt.Errorf("%s/pattern: %v", at, err) }
+		s.pattern = re
+	}
+	return s, nil
+}
+
+// Validate checks a JSON document against the schema and returns every
+// violation found (nil when valid).
+func (s *Schema) Validate(doc json.RawMessage) []ValidationError {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return []ValidationError{{Path: "", Message: "invalid json: " + err.Error()}}
+	}
+	var errs []ValidationError
+	s.validate(v, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(v any, path string, errs *[]ValidationError) {
+	fail := func(format string, a ...any) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if len(s.types) > 0 && !s.typeMatches(v) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if equalJSON(e, v) { found = true; break }
+		}
+		if !found { fail("value is not one of the allowed enum values") }
+	}
+	if s.constant != nil && !equalJSON(*s.constant, v) {
+		fail("value does not match const")
+	}
+
+	switch x := v.(type) {
+	case map[string]any:
+		for _, r := range s.required {
+			if _, ok := x[r]; !ok { fail("missing required property %q", r) }
+		}
+		names := make([]string, 0, len(x))
+		for k := range x { names = append(names, k) }
+		sort.Strings(names)
+		for _, k := range names {
+			sub, ok := s.properties[k]
+			if ok {
+				sub.validate(x[k], path+"/"+k, errs)
+			} else if s.additional != nil && !*s.additional {
+				*errs = append(*errs, ValidationError{Path: path + "/" + k, Message: "additional property not allowed"})
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(x) < *s.minItems { fail("expected at least %d items, got %d", *s.minItems, len(x)) }
+		if s.maxItems != nil && len(x) > *s.maxItems { fail("expected at most %d items, got %d", *s.maxItems, len(x)) }
+		if s.items != nil {
+			for i, it := range x { s.items.validate(it, fmt.Sprintf("%s/%d", path, i), errs) }
+		}
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.minLength != nil && n < *s.minLength { fail("shorter than minLength %d", *s.minLength) }
+		if s.maxLength != nil && n > *s.maxLength { fail("longer than maxLength %d", *s.maxLength) }
+		if s.pattern != nil && !s.pattern.MatchString(x) { fail("does not match pattern %q", s.pattern.String()) }
+	case json.Number:
+		f, _ := x.Float64()
+		if s.minimum != nil && f < *s.minimum { fail("less than minimum %v", *s.minimum) }
+		if s.maximum != nil && f > *s.maximum { fail("greater than maximum %v", *s.maximum) }
+		if s.exclMin != nil && f <= *s.exclMin { fail("not greater than exclusiveMinimum %v", *s.exclMin) }
+		if s.exclMax != nil && f >= *s.exclMax { fail("not less than exclusiveMaximum %v", *s.exclMax) }
+	}
+}
+
+func (s *Schema) typeMatches(v any) bool {
+	got := typeOf(v)
+	for _, t := range s.types {
+		if t == got { return true }
+		if t == "number" && got == "integer" { return true }
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case json.Number:
+		if f, err := x.Float64(); err == nil && f == math.Trunc(f) && !strings.ContainsAny(x.String(), ".eE") {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+// equalJSON compares a value decoded with UseNumber against one decoded
+// without it (schema literals).
+func equalJSON(schemaVal, v any) bool {
+	a, _ := json.Marshal(schemaVal)
+	b, _ := json.Marshal(v)
+	var na, nb any
+	_ = json.Unmarshal(a, &na)
+	_ = json.Unmarshal(b, &nb)
+	ca, _ := json.Marshal(na)
+	cb, _ := json.Marshal(nb)
+	return bytes.Equal(ca, cb)
+}
@@ -0,0 +1,50 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateKeywords(t *testing.T) {
+	for _, c := range []struct {
+		schema, doc string
+		errs        int
+	}{
+		{`{"type":"object","required":["id"]}`, `{"id":1}`, 0},
+		{`{"type":"object","required":["id"]}`, `{}`, 1},
+		{`{"type":"object"}`, `[]`, 1},
+		{`{"type":["string","null"]}`, `null`, 0},
+		{`{"type":"integer"}`, `1.5`, 1},
+		{`{"properties":{"n":{"type":"number","minimum":0,"maximum":10}}}`, `{"n":11}`, 1},
+		{`{"properties":{"n":{"exclusiveMinimum":0}}}`, `{"n":0}`, 1},
+		{`{"properties":{"n":{"exclusiveMaximum":10}}}`, `{"n":9.5}`, 0},
+		{`{"additionalProperties":false,"properties":{"a":{}}}`, `{"a":1,"b":2}`, 1},
+		{`{"type":"string","minLength":2,"maxLength":3}`, `"ab"`, 0},
+		{`{"type":"string","maxLength":3}`, `"abcd"`, 1},
+		{`{"type":"string","pattern":"^o-[0-9]+$"}`, `"o-12"`, 0},
+		{`{"type":"string","pattern":"^o-[0-9]+$"}`, `"x"`, 1},
+		{`{"enum":["a","b"]}`, `"c"`, 1},
+		{`{"const":{"v":1}}`, `{"v":1}`, 0},
+		{`{"type":"array","items":{"type":"integer"},"minItems":1,"maxItems":2}`, `[1,"x"]`, 1},
+		{`{"type":"array","maxItems":2}`, `[1,2,3]`, 1},
+		{`{"type":"array","minItems":1}`, `[]`, 1},
+	} {
+		s, err := Compile(json.RawMessage(c.schema))
+		if err != nil { t.Fatalf("%s: %v", c.schema, err) }
+		if errs := s.Validate(json.RawMessage(c.doc)); len(errs) != c.errs {
+			t.Errorf("%s against %s: %v, want %d errors", c.doc, c.schema, errs, c.errs)
+		}
+	}
+}
+
+func TestCompileRejectsBadSchemas(t *testing.T) {
+	for _, doc := range []string{
+		`[]`,
+		`{"type":"thing"}`,
+		`{"additionalProperties":{"type":"string"}}`,
+		`{"pattern":"("}`,
+		`{"properties":{"a":{"type":1}}}`,
+	} {
+		if _, err := Compile(json.RawMessage(doc)); err == nil { t.Errorf("%s compiled", doc) }
+	}
+}
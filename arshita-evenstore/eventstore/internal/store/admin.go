@@ -0,0 +1,159 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"eventstore/internal/trace"
+)
+
+// ErrReadOnly is returned by writes while the store is set read-only.
+var ErrReadOnly = errors.New("store is read-only")
+
+// SetReadOnly turns writes off (Put and PutBatch fail with ErrReadOnly) or
+// back on. Reads, flushes and compactions are unaffected.
+func (s *LSMStore) SetReadOnly(on bool) { s.readOnly.Store(on) }
+
+func (s *LSMStore) ReadOnly() bool { return s.readOnly.Load() }
+
+// Flush writes the memtable out as a new segment now.
+func (s *LSMStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked(ctx)
+}
+
+// CompactStats describes one compaction.
+type CompactStats struct {
+	SegmentsBefore int     `json:"segments_before"`
+	SegmentsAfter  int     `json:"segments_after"`
+	EventsIn       int     `json:"events_in"`
+	EventsOut      int     `json:"events_out"`
+	DurationMS     float64 `json:"duration_ms"`
+}
+
+// Compact merges every segment into one, keeping the newest version of each
+// key and dropping events past retention. Writes wait until it is done.
+func (s *LSMStore) Compact(ctx context.Context) (st CompactStats, err error) {
+	start := time.Now()
+	_, span := trace.Start(ctx, "store.compact", trace.KindInternal)
+	defer func() { span.SetError(err); span.End() }()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := append([]string(nil), s.manifest.Segments...)
+	st.SegmentsBefore = len(old)
+	if len(old) < 2 && s.cutoff() == minTS {
+		st.SegmentsAfter = len(old)
+		return st, nil // nothing to merge or expire
+	}
+
+	// oldest to newest, so later segments win
+	latest := map[string]Event{}
+	for _, seg := range old {
+		evs, err := sstableRangeTS(filepath.Join(s.opts.DataDir, "sst", seg), minTS, maxTS, nil)
+		if err != nil { return st, err }
+		st.EventsIn += len(evs)
+		for _, e := range evs { latest[e.Key] = e }
+	}
+	items := make([]Event, 0, len(latest))
+	for _, e := range latest {
+		if !s.expired(e) { items = append(items, e) }
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	st.EventsOut = len(items)
+
+	// write the merged segment before the manifest points at it
+	var segs []string
+	ranges, seqs := map[string]tsRange{}, map[string]seqRange{}
+	if len(items) > 0 {
+		name := s.manifest.nextName()
+		path := filepath.Join(s.opts.DataDir, "sst", name)
+		if err := sstableWrite(path, items); err != nil { return st, err }
+		if err := s.writeIndexSidecarsLocked(path, items); err != nil { return st, err }
+		segs, ranges[name], seqs[name] = []string{name}, spanOf(items), seqSpanOf(items)
+	}
+	prev := *s.manifest
+	s.manifest.Segments, s.manifest.Ranges, s.manifest.Seqs = segs, ranges, seqs
+	if err := s.manifest.Save(filepath.Join(s.opts.DataDir, "manifest.json")); err != nil {
+		*s.manifest = prev
+		return st, err
+	}
+	s.retireSegmentsLocked(old)
+
+	st.SegmentsAfter = len(segs)
+	st.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+	compactions.Inc()
+	compactSeconds.Observe(time.Since(start).Seconds())
+	span.SetAttr("segments", st.SegmentsBefore)
+	span.SetAttr("events", st.EventsOut)
+	slog.InfoContext(ctx, "store compacted", "dir", s.opts.DataDir, "segments_before", st.SegmentsBefore, "segments_after", st.SegmentsAfter, "events_in", st.EventsIn, "events_out", st.EventsOut)
+	return st, nil
+}
+
+// removeSegment deletes a segment with its key index and index sidecars.
+func removeSegment(path string) {
+	os.Remove(path)
+	os.Remove(path + ".index.json")
+	sidecars, _ := filepath.Glob(path + ".idx.*.json")
+	for _, p := range sidecars { os.Remove(p) }
+}
+
+// SegmentInfo is a segment as recorded in the manifest.
+type SegmentInfo struct {
+	Name  string `json:"name"`
+	MinTS int64  `json:"min_ts"`
+	MaxTS int64  `json:"max_ts"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Segments lists the segments oldest first.
+func (s *LSMStore) Segments() []SegmentInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]SegmentInfo, 0, len(s.manifest.Segments))
+	for _, seg := range s.manifest.Segments {
+		r := s.manifest.rangeOf(seg)
+		info := SegmentInfo{Name: seg, MinTS: r.MinTS, MaxTS: r.MaxTS}
+		if fi, err := os.Stat(filepath.Join(s.opts.DataDir, "sst", seg)); err == nil { info.Bytes = fi.Size() }
+		out = append(out, info)
+	}
+	return out
+}
+
+// Stats is a snapshot of the store's in-memory and on-disk state.
+type Stats struct {
+	DataDir          string `json:"data_dir"`
+	ReadOnly         bool   `json:"read_only"`
+	MemtableItems    int    `json:"memtable_items"`
+	MemtableMaxItems int    `json:"memtable_max_items"`
+	WALBytes         int64  `json:"wal_bytes"`
+	Segments         int    `json:"segments"`
+	LastSeq          uint64 `json:"last_seq"`
+	PublishedSeq     uint64 `json:"published_seq,omitempty"` // with WAL retention for the relay
+	WALArchives      int    `json:"wal_archives,omitempty"`
+	Subscribers      int    `json:"subscribers"`
+}
+
+func (s *LSMStore) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := Stats{
+		DataDir:          s.opts.DataDir,
+		ReadOnly:         s.ReadOnly(),
+		MemtableItems:    s.mem.len(),
+		MemtableMaxItems: s.opts.MemtableMaxItems,
+		Segments:         len(s.manifest.Segments),
+		LastSeq:          s.seq,
+		WALArchives:      len(s.archives),
+		Subscribers:      len(s.subs),
+	}
+	if s.retainWAL { st.PublishedSeq = s.published }
+	if fi, err := os.Stat(s.wal.path); err == nil { st.WALBytes = fi.Size() }
+	return st
+}
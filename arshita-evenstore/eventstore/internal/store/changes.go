@@ -0,0 +1,317 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A relay (the Kafka publisher) follows committed events through the WAL.
+// While WAL retention is on, a flush archives wal.log as
+// wal-<first seq>-<last seq>.log instead of truncating it, and an archive is
+// deleted once MarkPublished has passed its last seq. published.seq records
+// how far the relay got, so it resumes there after a restart and delivers at
+// least once.
+
+const publishedFile = "published.seq"
+
+type walArchive struct {
+	gen   uint64 // identifies the file to ChangeReader; wal.log has s.walGen
+	first uint64 // seqs first..last, every event committed in between
+	last  uint64
+	path  string
+}
+
+// loadRelayState picks up published.seq and the archived WAL files.
+func (s *LSMStore) loadRelayState() error {
+	b, err := os.ReadFile(filepath.Join(s.opts.DataDir, publishedFile))
+	switch {
+	case err == nil:
+		n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil { return fmt.Errorf("%s: %w", publishedFile, err) }
+		s.retainWAL, s.published = true, n
+	case !os.IsNotExist(err):
+		return err
+	}
+	names, err := filepath.Glob(filepath.Join(s.opts.DataDir, "wal-*.log"))
+	if err != nil { return err }
+	for _, p := range names {
+		var a walArchive
+		if _, err := fmt.Sscanf(filepath.Base(p), "wal-%d-%d.log", &a.first, &a.last); err != nil { continue }
+		a.path = p
+		s.archives = append(s.archives, a)
+	}
+	sort.Slice(s.archives, func(i, j int) bool { return s.archives[i].last < s.archives[j].last })
+	for i := range s.archives { s.archives[i].gen = uint64(i) + 1 }
+	s.walGen = uint64(len(s.archives)) + 1
+	return nil
+}
+
+// RetainWAL keeps committed events in the WAL past flushes until they are
+// marked published. Turned on for the first time, it starts from the
+// current seq: earlier events are not relayed.
+func (s *LSMStore) RetainWAL() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retainWAL { return nil }
+	s.published = s.seq
+	if err := s.savePublishedLocked(); err != nil { return err }
+	s.retainWAL = true
+	return nil
+}
+
+// ReleaseWAL turns WAL retention off and deletes the archived WAL files,
+// including events never relayed.
+func (s *LSMStore) ReleaseWAL() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.retainWAL && len(s.archives) == 0 { return nil }
+	if n := s.seq - s.published; n > 0 { slog.Warn("dropping events not yet relayed", "dir", s.opts.DataDir, "events", n) }
+	s.retainWAL = false
+	for _, a := range s.archives { removeFile(a.path) }
+	s.archives = nil
+	if err := os.Remove(filepath.Join(s.opts.DataDir, publishedFile)); err != nil && !os.IsNotExist(err) { return err }
+	return nil
+}
+
+// Published is the seq up to which events have been relayed.
+func (s *LSMStore) Published() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.published
+}
+
+// MarkPublished records that every event up to seq has been relayed and
+// deletes the WAL archives that held only such events.
+func (s *LSMStore) MarkPublished(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq <= s.published { return nil }
+	s.published = seq
+	if err := s.savePublishedLocked(); err != nil { return err }
+	keep := s.archives[:0]
+	for _, a := range s.archives {
+		if a.last <= seq {
+			removeFile(a.path)
+			continue
+		}
+		keep = append(keep, a)
+	}
+	s.archives = keep
+	return nil
+}
+
+func (s *LSMStore) savePublishedLocked() error {
+	path := filepath.Join(s.opts.DataDir, publishedFile)
+	if err := os.WriteFile(path+".tmp", []byte(strconv.FormatUint(s.published, 10)), 0o644); err != nil { return err }
+	return os.Rename(path+".tmp", path)
+}
+
+func removeFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) { slog.Warn("remove failed", "path", path, "err", err) }
+}
+
+// rotateWALLocked empties the WAL after a flush. With retention on and
+// events not yet published, it is archived instead.
+func (s *LSMStore) rotateWALLocked() error {
+	gen := s.walGen
+	// either way wal.log starts over, so readers must not keep their offset
+	// into it; re-reading from 0 is harmless, they skip seqs already returned
+	s.walGen++
+	if !s.retainWAL || s.published >= s.seq { return s.wal.Rotate() }
+	first := s.manifest.LastSeq + 1 // the seq at the previous flush
+	path := filepath.Join(s.opts.DataDir, fmt.Sprintf("wal-%020d-%020d.log", first, s.seq))
+	if err := s.wal.archive(path); err != nil { return err }
+	s.archives = append(s.archives, walArchive{gen: gen, first: first, last: s.seq, path: path})
+	return nil
+}
+
+// walFloorLocked is the first seq the WAL files hold: from it on they have
+// every committed event, below it only the segments are left.
+func (s *LSMStore) walFloorLocked() uint64 {
+	if len(s.archives) > 0 { return s.archives[0].first }
+	return s.manifest.LastSeq + 1
+}
+
+// Commits receives a value after events are committed, for a single relay
+// waiting on new events.
+func (s *LSMStore) Commits() <-chan struct{} { return s.commits }
+
+// ChangeReader reads committed events in seq order: from the WAL files,
+// which hold every event, as far back as they go, and before that from the
+// segments, which keep only the newest event per key as of each flush.
+type ChangeReader struct {
+	s      *LSMStore
+	keep   func(Event) bool // nil keeps every event
+	after  uint64           // last seq read
+	legacy bool             // unsequenced segment events still to read
+	gen    uint64           // WAL file being read, at off
+	off    int64
+	buf    []Event // rest of the segment being read, in seq order
+	segEnd uint64  // after is at least this once buf is drained
+	seen   uint64  // seq of the last event returned, before keep
+	missed uint64  // seqs passed over between events: no longer stored
+}
+
+// Changes reads the events committed after seq. Read from 0 it starts with
+// the events of segments written before seqs were assigned.
+func (s *LSMStore) Changes(after uint64) *ChangeReader {
+	return &ChangeReader{s: s, after: after, seen: after, legacy: after == 0}
+}
+
+// ChangesAfter is Changes without the unsequenced events, even from 0. The
+// relay reads from it: a data dir from before seqs has published 0 and
+// those events were never meant to be relayed.
+func (s *LSMStore) ChangesAfter(after uint64) *ChangeReader {
+	return &ChangeReader{s: s, after: after, seen: after}
+}
+
+// ChangesMatching is Changes for the events accepted by f that are not
+// past retention.
+func (s *LSMStore) ChangesMatching(after uint64, f Filter) (*ChangeReader, error) {
+	if err := f.compile(); err != nil { return nil, err }
+	r := s.Changes(after)
+	r.keep = func(e Event) bool { return f.match(e) && !s.expired(e) }
+	return r, nil
+}
+
+// changeChunk bounds the WAL records read while holding the store lock.
+const changeChunk = 4096
+
+// Next returns up to max of the following events, or none when caught up.
+// Segments are read without the store lock and the WAL in bounded chunks,
+// so a long read does not stall writers.
+func (r *ChangeReader) Next(max int) ([]Event, error) { return r.next(max, false) }
+
+// next is Next; with locked the caller holds s.mu and it is not taken.
+func (r *ChangeReader) next(max int, locked bool) ([]Event, error) {
+	var out []Event
+	for len(out) < max {
+		evs, more, err := r.read(min(max-len(out), changeChunk), locked)
+		for _, e := range evs {
+			if e.Seq > r.seen+1 { r.missed += e.Seq - r.seen - 1 }
+			if e.Seq > r.seen { r.seen = e.Seq }
+			if r.keep == nil || r.keep(e) { out = append(out, e) }
+		}
+		if err != nil || !more { return out, err }
+	}
+	return out, nil
+}
+
+// read returns up to n events after r.after, unfiltered, and whether more
+// may follow.
+func (r *ChangeReader) read(n int, locked bool) ([]Event, bool, error) {
+	if len(r.buf) > 0 { return r.take(n), true, nil }
+	s := r.s
+	if !locked { s.mu.RLock() }
+	floor := s.walFloorLocked()
+	if segs, seqs := r.segmentsLocked(floor); len(segs) > 0 {
+		release := s.pinSegmentsLocked(segs)
+		if !locked { s.mu.RUnlock() }
+		err := r.fill(segs, seqs, floor)
+		release()
+		return r.take(n), true, err
+	}
+	r.after = max(r.after, floor-1) // nothing else below the floor survives
+	evs, more, err := r.readWALLocked(n)
+	if !locked { s.mu.RUnlock() }
+	return evs, more, err
+}
+
+// segmentsLocked picks what to read below floor: every segment holding
+// unsequenced events while r.legacy, then the first holding seqs between
+// r.after and floor. The seq ranges come back alongside so fill never has to
+// look at the manifest once s.mu is released.
+func (r *ChangeReader) segmentsLocked(floor uint64) ([]string, []seqRange) {
+	m := r.s.manifest
+	var out []string
+	var seqs []seqRange
+	if r.legacy {
+		for _, seg := range m.Segments {
+			if sr := m.Seqs[seg]; sr.Min == 0 { out, seqs = append(out, seg), append(seqs, sr) }
+		}
+		if len(out) > 0 { return out, seqs }
+		r.legacy = false
+	}
+	for _, seg := range m.Segments {
+		if sr := m.Seqs[seg]; sr.Max > r.after && sr.Min < floor { return []string{seg}, []seqRange{sr} }
+	}
+	return nil, nil
+}
+
+// fill loads what r has yet to read from segs into r.buf, in seq order.
+func (r *ChangeReader) fill(segs []string, seqs []seqRange, floor uint64) error {
+	legacy := r.legacy
+	r.legacy = false
+	var buf []Event
+	for i, seg := range segs {
+		evs, err := sstableRangeTS(filepath.Join(r.s.opts.DataDir, "sst", seg), minTS, maxTS, func(e Event) bool {
+			if legacy { return e.Seq == 0 }
+			return e.Seq > r.after && e.Seq < floor
+		})
+		if err != nil { return err }
+		buf = append(buf, evs...)
+		if !legacy { r.segEnd = max(r.segEnd, min(seqs[i].Max, floor-1)) }
+	}
+	sort.SliceStable(buf, func(i, j int) bool { return buf[i].Seq < buf[j].Seq })
+	r.buf = buf
+	if len(buf) == 0 { r.after = max(r.after, r.segEnd) }
+	return nil
+}
+
+func (r *ChangeReader) take(n int) []Event {
+	n = min(n, len(r.buf))
+	out := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	for _, e := range out {
+		if e.Seq > r.after { r.after = e.Seq }
+	}
+	if len(r.buf) == 0 { r.after = max(r.after, r.segEnd) }
+	return out
+}
+
+// readWALLocked reads from the WAL files, oldest first.
+func (r *ChangeReader) readWALLocked(n int) ([]Event, bool, error) {
+	s := r.s
+	files := append(append([]walArchive(nil), s.archives...), walArchive{gen: s.walGen, last: s.seq, path: s.wal.path})
+	var out []Event
+	for _, f := range files {
+		if f.last <= r.after { continue }
+		if f.gen != r.gen { r.gen, r.off = f.gen, 0 }
+		evs, err := r.readWAL(f.path, n-len(out))
+		out = append(out, evs...)
+		if err != nil { return out, false, err }
+		if len(out) >= n { return out, true, nil }
+	}
+	return out, false, nil
+}
+
+func (r *ChangeReader) readWAL(path string, max int) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+	if _, err := f.Seek(r.off, io.SeekStart); err != nil { return nil, err }
+	br := bufio.NewReaderSize(f, 1<<16)
+	var out []Event
+	for len(out) < max {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF { return out, nil } // caught up; a torn last line is not consumed
+		if err != nil { return out, err }
+		r.off += int64(len(line))
+		evs, err := parseWALLine(line)
+		if err != nil { continue } // skipped, as on recovery
+		for _, e := range evs {
+			if e.Seq > r.after {
+				out = append(out, e)
+				r.after = e.Seq
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func seqs(evs []Event) []uint64 {
+	out := make([]uint64, len(evs))
+	for i, e := range evs { out[i] = e.Seq }
+	return out
+}
+
+func next(t *testing.T, r *ChangeReader, max int) []Event {
+	t.Helper()
+	evs, err := r.Next(max)
+	if err != nil { t.Fatal(err) }
+	return evs
+}
+
+// A flush that truncates wal.log must not leave readers at their old offset.
+func TestChangesAfterTruncatingFlush(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	if err := s.RetainWAL(); err != nil { t.Fatal(err) }
+	for i := 1; i <= 3; i++ { put(t, s, fmt.Sprint("k", i), int64(i), `{"n":1}`) }
+
+	r := s.Changes(s.Published())
+	if got := seqs(next(t, r, 10)); fmt.Sprint(got) != "[1 2 3]" { t.Fatalf("first read: %v", got) }
+	if err := s.MarkPublished(3); err != nil { t.Fatal(err) }
+	if err := s.Flush(context.Background()); err != nil { t.Fatal(err) }
+
+	put(t, s, "k4", 4, `{"n":1}`)
+	put(t, s, "k5", 5, `{"n":1}`)
+	if got := seqs(next(t, r, 10)); fmt.Sprint(got) != "[4 5]" { t.Fatalf("after truncate: %v", got) }
+
+	// a record straddling the old offset must not be read from the middle
+	if err := s.MarkPublished(5); err != nil { t.Fatal(err) }
+	if err := s.Flush(context.Background()); err != nil { t.Fatal(err) }
+	put(t, s, "k6", 6, `{"pad":"`+strings.Repeat("x", 500)+`"}`)
+	if got := seqs(next(t, r, 10)); fmt.Sprint(got) != "[6]" { t.Fatalf("after second truncate: %v", got) }
+}
+
+func TestChangesAcrossArchivesAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, 1000)
+	put(t, s, "before", 1, `1`) // committed before retention: never relayed
+	if err := s.RetainWAL(); err != nil { t.Fatal(err) }
+	for i := 2; i <= 4; i++ { put(t, s, "k", int64(i), `1`) } // one key, three versions
+	if err := s.Flush(context.Background()); err != nil { t.Fatal(err) }
+	put(t, s, "k", 5, `1`)
+
+	if n := len(s.archives); n != 1 { t.Fatalf("archives = %d, want 1", n) }
+	r := s.Changes(s.Published())
+	if got := seqs(next(t, r, 2)); fmt.Sprint(got) != "[2 3]" { t.Fatalf("page 1: %v", got) }
+	if err := s.MarkPublished(3); err != nil { t.Fatal(err) }
+	if err := s.Close(); err != nil { t.Fatal(err) }
+
+	s = openTestStore(t, dir, 1000)
+	if p := s.Published(); p != 3 { t.Fatalf("published after restart = %d, want 3", p) }
+	r = s.Changes(s.Published())
+	if got := seqs(next(t, r, 10)); fmt.Sprint(got) != "[4 5]" { t.Fatalf("after restart: %v", got) }
+	if err := s.MarkPublished(5); err != nil { t.Fatal(err) }
+	if a, _ := filepath.Glob(filepath.Join(dir, "wal-*.log")); len(a) != 0 { t.Fatalf("archives left after publishing: %v", a) }
+	if got := next(t, r, 10); len(got) != 0 { t.Fatalf("caught up, got %v", seqs(got)) }
+}
+
+func TestReleaseWAL(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, 1000)
+	if err := s.RetainWAL(); err != nil { t.Fatal(err) }
+	put(t, s, "k", 1, `1`)
+	if err := s.Flush(context.Background()); err != nil { t.Fatal(err) }
+	if err := s.ReleaseWAL(); err != nil { t.Fatal(err) }
+	if left, _ := filepath.Glob(filepath.Join(dir, "wal-*.log")); len(left) != 0 { t.Fatalf("archives left behind: %v", left) }
+	if _, err := os.Stat(filepath.Join(dir, publishedFile)); !os.IsNotExist(err) { t.Fatalf("%s left behind: %v", publishedFile, err) }
+	if st := s.Stats(); st.PublishedSeq != 0 || st.WALArchives != 0 { t.Fatalf("stats after release: %+v", st) }
+}
+
+// Retention turned on over a data dir from before seqs starts the relay at
+// 0, which must not replay the old segments.
+func TestChangesAfterSkipsUnsequencedSegments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sst"), 0o755); err != nil { t.Fatal(err) }
+	legacy := []Event{{Key: "a", TS: 1, Value: []byte(`1`)}, {Key: "b", TS: 2, Value: []byte(`1`)}}
+	if err := sstableWrite(filepath.Join(dir, "sst", "000001.sst"), legacy); err != nil { t.Fatal(err) }
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(`{"segments":["000001.sst"]}`), 0o644); err != nil { t.Fatal(err) }
+
+	s := openTestStore(t, dir, 1000)
+	if err := s.RetainWAL(); err != nil { t.Fatal(err) }
+	if s.Published() != 0 { t.Fatalf("published %d", s.Published()) }
+	r := s.ChangesAfter(s.Published())
+	if evs := next(t, r, 10); len(evs) != 0 { t.Fatalf("relayed legacy events %v", evs) }
+	put(t, s, "c", 3, `1`)
+	if evs := next(t, r, 10); len(evs) != 1 || evs[0].Key != "c" || evs[0].Seq != 1 { t.Fatalf("got %+v", evs) }
+}
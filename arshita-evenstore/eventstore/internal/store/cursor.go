@@ -0,0 +1,34 @@
+package store
+
+import "sort"
+
+// Cursor is a position in replay order: TS, then key, then seq. Replay with
+// ReplayOptions.After resumes strictly after it.
+type Cursor struct {
+	TS  int64
+	Key string
+	Seq uint64
+}
+
+// Cursor is the position of e in replay order.
+func (e Event) Cursor() Cursor { return Cursor{TS: e.TS, Key: e.Key, Seq: e.Seq} }
+
+func (c Cursor) less(d Cursor) bool {
+	if c.TS != d.TS { return c.TS < d.TS }
+	if c.Key != d.Key { return c.Key < d.Key }
+	return c.Seq < d.Seq
+}
+
+// page applies After and Limit to events already in replay order
+// (ascending, or descending when o.Desc).
+func (o ReplayOptions) page(all []Event) []Event {
+	if o.After != nil {
+		i := sort.Search(len(all), func(i int) bool {
+			if o.Desc { return all[i].Cursor().less(*o.After) }
+			return o.After.less(all[i].Cursor())
+		})
+		all = all[i:]
+	}
+	if o.Limit > 0 && len(all) > o.Limit { all = all[:o.Limit] }
+	return all
+}
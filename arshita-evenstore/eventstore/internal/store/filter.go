@@ -0,0 +1,129 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ReplayOptions narrows what Replay and Lookup emit.
+type ReplayOptions struct {
+	Filter Filter
+	Limit  int     // max events to emit; 0 means no limit
+	After  *Cursor // start strictly after this position (before it when Desc)
+	Desc   bool    // newest first
+}
+
+// Filter selects events by key and by simple predicates on JSON value fields.
+// The zero Filter matches everything.
+type Filter struct {
+	KeyPrefix string
+	KeyGlob   string // path.Match syntax, e.g. "order-*"
+	Where     []Predicate
+}
+
+// Predicate tests one JSON path inside the event value.
+type Predicate struct {
+	Path  string
+	Op    string // exists, eq, ne, gt, gte, lt, lte
+	Value string
+
+	steps []string
+	num   float64
+}
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// opChars may not appear in a predicate path: they mean an operator
+// ParsePredicate did not recognise.
+const opChars = "=!<>~"
+
+var predicateOps = []struct{ tok, op string }{
+	// longest tokens first so ">=" is not read as ">"
+	{"==", "eq"}, {"!=", "ne"}, {">=", "gte"}, {"<=", "lte"}, {">", "gt"}, {"<", "lt"},
+}
+
+// ParsePredicate parses "$.type==OrderPlaced", "$.amount>=10" or "$.customer"
+// (existence). The first operator in s splits it, so the value may contain
+// operators itself. The result is validated when the filter is used: an
+// operator it does not know, like "=" or "=~", is left in the path and
+// rejected there.
+func ParsePredicate(s string) Predicate {
+	op, at := -1, len(s)
+	for i, o := range predicateOps {
+		if j := strings.Index(s, o.tok); j > 0 && j < at { op, at = i, j }
+	}
+	if op < 0 { return Predicate{Path: s, Op: "exists"} }
+	o := predicateOps[op]
+	return Predicate{Path: s[:at], Op: o.op, Value: s[at+len(o.tok):]}
+}
+
+// compile validates the filter and prepares it for matching. Errors wrap
+// ErrInvalidFilter.
+func (f *Filter) compile() error {
+	if f.KeyGlob != "" {
+		if _, err := path.Match(f.KeyGlob, ""); err != nil {
+			return fmt.Errorf("%w: key glob %q: %v", ErrInvalidFilter, f.KeyGlob, err)
+		}
+	}
+	f.Where = append([]Predicate(nil), f.Where...) // don't write into the caller's slice
+	for i := range f.Where {
+		p := &f.Where[i]
+		if strings.ContainsAny(p.Path, opChars) { return fmt.Errorf("%w: unknown operator in %q", ErrInvalidFilter, p.Path) }
+		steps, err := parseJSONPath(p.Path)
+		if err != nil { return fmt.Errorf("%w: %v", ErrInvalidFilter, err) }
+		p.steps = steps
+		switch p.Op {
+		case "exists", "eq", "ne":
+		case "gt", "gte", "lt", "lte":
+			n, err := strconv.ParseFloat(p.Value, 64)
+			if err != nil { return fmt.Errorf("%w: %s %s needs a number, got %q", ErrInvalidFilter, p.Path, p.Op, p.Value) }
+			p.num = n
+		default:
+			return fmt.Errorf("%w: unknown op %q", ErrInvalidFilter, p.Op)
+		}
+	}
+	return nil
+}
+
+func (f *Filter) empty() bool {
+	return f.KeyPrefix == "" && f.KeyGlob == "" && len(f.Where) == 0
+}
+
+func (f *Filter) match(e Event) bool {
+	if f.KeyPrefix != "" && !strings.HasPrefix(e.Key, f.KeyPrefix) { return false }
+	if f.KeyGlob != "" {
+		if ok, _ := path.Match(f.KeyGlob, e.Key); !ok { return false }
+	}
+	for _, p := range f.Where {
+		if !p.match(e) { return false }
+	}
+	return true
+}
+
+func (p *Predicate) match(e Event) bool {
+	v, ok := extractJSONPath(e.Value, p.steps)
+	switch p.Op {
+	case "exists":
+		return ok
+	case "eq":
+		return ok && v == p.Value
+	case "ne":
+		return !ok || v != p.Value
+	}
+	if !ok { return false }
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil { return false }
+	switch p.Op {
+	case "gt":
+		return n > p.num
+	case "gte":
+		return n >= p.num
+	case "lt":
+		return n < p.num
+	default: // lte
+		return n <= p.num
+	}
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParsePredicate(t *testing.T) {
+	for _, c := range []struct{ in, path, op, value string }{
+		{"$.type==OrderPlaced", "$.type", "eq", "OrderPlaced"},
+		{"$.amount>=10", "$.amount", "gte", "10"},
+		{"$.amount>10", "$.amount", "gt", "10"},
+		{"$.amount<=10", "$.amount", "lte", "10"},
+		{"$.status!=done", "$.status", "ne", "done"},
+		{"$.x>a==b", "$.x", "gt", "a==b"},
+		{"$.x!=a<b", "$.x", "ne", "a<b"},
+		{"$.customer", "$.customer", "exists", ""},
+	} {
+		p := ParsePredicate(c.in)
+		if p.Path != c.path || p.Op != c.op || p.Value != c.value {
+			t.Errorf("%s: got %q %s %q", c.in, p.Path, p.Op, p.Value)
+		}
+	}
+}
+
+func replayKeys(t *testing.T, s *LSMStore, opts ReplayOptions) []string {
+	t.Helper()
+	ch, err := s.Replay(context.Background(), minTS, maxTS, opts)
+	if err != nil { t.Fatal(err) }
+	var keys []string
+	for e := range ch { keys = append(keys, e.Key) }
+	return keys
+}
+
+func TestReplayFiltersSegmentsAndMemtable(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	put(t, s, "order-1", 1, `{"amount":5}`)
+	put(t, s, "order-2", 2, `{"amount":20}`)
+	put(t, s, "user-1", 3, `{"amount":50}`)
+	if err := s.Flush(context.Background()); err != nil { t.Fatal(err) }
+	put(t, s, "order-3", 4, `{"amount":30}`)
+	put(t, s, "order-4", 5, `{"note":"x"}`)
+
+	for _, c := range []struct {
+		f    Filter
+		want string
+	}{
+		{Filter{}, "[order-1 order-2 user-1 order-3 order-4]"},
+		{Filter{KeyPrefix: "order-"}, "[order-1 order-2 order-3 order-4]"},
+		{Filter{KeyGlob: "*-1"}, "[order-1 user-1]"},
+		{Filter{KeyPrefix: "order-", Where: []Predicate{ParsePredicate("$.amount>=20")}}, "[order-2 order-3]"},
+		{Filter{Where: []Predicate{ParsePredicate("$.amount!=20")}}, "[order-1 user-1 order-3 order-4]"},
+		{Filter{Where: []Predicate{ParsePredicate("$.note")}}, "[order-4]"},
+	} {
+		if got := fmt.Sprint(replayKeys(t, s, ReplayOptions{Filter: c.f})); got != c.want { t.Errorf("%+v: got %s, want %s", c.f, got, c.want) }
+	}
+
+	desc := replayKeys(t, s, ReplayOptions{Desc: true, Limit: 2, Filter: Filter{Where: []Predicate{ParsePredicate("$.amount>10")}}})
+	if got := fmt.Sprint(desc); got != "[order-3 user-1]" { t.Errorf("desc: %s", got) }
+}
+
+func TestReplayRejectsInvalidFilters(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	for _, f := range []Filter{
+		{KeyGlob: "["},
+		{Where: []Predicate{ParsePredicate("$.amount>ten")}},
+		{Where: []Predicate{{Path: "$.a", Op: "like"}}},
+		{Where: []Predicate{ParsePredicate("$.a=b")}},
+		{Where: []Predicate{ParsePredicate("$.a=>1")}},
+		{Where: []Predicate{ParsePredicate("$.a=~b")}},
+		{Where: []Predicate{ParsePredicate("$.a~b")}},
+	} {
+		if _, err := s.Replay(context.Background(), minTS, maxTS, ReplayOptions{Filter: f}); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%+v: err %v, want ErrInvalidFilter", f, err)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IndexDef declares a secondary index over a JSON path inside event values,
+// e.g. {"name":"type","path":"$.type"}.
+type IndexDef struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Ready bool   `json:"ready"` // false until every existing segment has a sidecar
+}
+
+var (
+	ErrUnknownIndex  = errors.New("unknown index")
+	ErrIndexNotReady = errors.New("index not built for existing segments")
+)
+
+type secondaryIndex struct {
+	def   IndexDef
+	steps []string
+	// memtable part: value -> keys currently holding that value
+	mem map[string]map[string]struct{}
+}
+
+type indexFile struct {
+	Indexes []IndexDef `json:"indexes"`
+}
+
+func (s *LSMStore) loadIndexes() error {
+	b, err := os.ReadFile(s.indexesPath())
+	if os.IsNotExist(err) { return nil }
+	if err != nil { return err }
+	var f indexFile
+	if err := json.Unmarshal(b, &f); err != nil { return err }
+	for _, d := range f.Indexes {
+		steps, err := parseJSONPath(d.Path)
+		if err != nil { return fmt.Errorf("index %s: %w", d.Name, err) }
+		s.indexes = append(s.indexes, &secondaryIndex{def: d, steps: steps, mem: map[string]map[string]struct{}{}})
+	}
+	return nil
+}
+
+func (s *LSMStore) saveIndexesLocked() error {
+	f := indexFile{Indexes: make([]IndexDef, 0, len(s.indexes))}
+	for _, ix := range s.indexes { f.Indexes = append(f.Indexes, ix.def) }
+	b, _ := json.MarshalIndent(f, "", "  ")
+	return os.WriteFile(s.indexesPath(), b, 0o644)
+}
+
+func (s *LSMStore) indexesPath() string { return filepath.Join(s.opts.DataDir, "indexes.json") }
+
+// CreateIndex declares a new secondary index. The memtable is indexed right
+// away; if segments already exist the index stays not-ready until BuildIndex.
+func (s *LSMStore) CreateIndex(name, path string) (IndexDef, error) {
+	if name == "" || strings.ContainsAny(name, `/\. `) {
+		return IndexDef{}, fmt.Errorf("invalid index name %q", name)
+	}
+	steps, err := parseJSONPath(path)
+	if err != nil { return IndexDef{}, err }
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ix := range s.indexes {
+		if ix.def.Name == name { return IndexDef{}, fmt.Errorf("index %s already exists", name) }
+	}
+	ix := &secondaryIndex{
+		def:   IndexDef{Name: name, Path: path, Ready: len(s.manifest.Segments) == 0},
+		steps: steps,
+		mem:   map[string]map[string]struct{}{},
+	}
+	for _, e := range s.mem.data { ix.add(e) }
+	s.indexes = append(s.indexes, ix)
+	return ix.def, s.saveIndexesLocked()
+}
+
+// BuildIndex writes the index sidecar for every existing segment and marks
+// the index ready. The sidecars are written without the store lock; segments
+// flushed or compacted meanwhile get theirs from the flush or compaction.
+func (s *LSMStore) BuildIndex(ctx context.Context, name string) (IndexDef, error) {
+	s.mu.RLock()
+	ix := s.indexLocked(name)
+	if ix == nil {
+		s.mu.RUnlock()
+		return IndexDef{}, ErrUnknownIndex
+	}
+	def := ix.def
+	segs := append([]string(nil), s.manifest.Segments...)
+	release := s.pinSegmentsLocked(segs)
+	s.mu.RUnlock()
+	defer release()
+
+	var written []string
+	for _, seg := range segs {
+		if err := ctx.Err(); err != nil { return def, err }
+		path := filepath.Join(s.opts.DataDir, "sst", seg)
+		items, err := sstableRangeTS(path, minTS, maxTS, nil)
+		if err != nil { return def, err }
+		if err := ix.writeSidecar(path, items); err != nil { return def, err }
+		written = append(written, path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indexLocked(name) != ix {
+		// dropped while building
+		for _, path := range written { _ = os.Remove(ix.sidecarPath(path)) }
+		return def, ErrUnknownIndex
+	}
+	ix.def.Ready = true
+	return ix.def, s.saveIndexesLocked()
+}
+
+// DropIndex removes the index definition and its segment sidecars.
+func (s *LSMStore) DropIndex(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, ix := range s.indexes {
+		if ix.def.Name != name { continue }
+		for _, seg := range s.manifest.Segments {
+			_ = os.Remove(ix.sidecarPath(filepath.Join(s.opts.DataDir, "sst", seg)))
+		}
+		s.indexes = append(s.indexes[:i], s.indexes[i+1:]...)
+		return s.saveIndexesLocked()
+	}
+	return ErrUnknownIndex
+}
+
+// Indexes lists the declared secondary indexes.
+func (s *LSMStore) Indexes() []IndexDef {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]IndexDef, 0, len(s.indexes))
+	for _, ix := range s.indexes { out = append(out, ix.def) }
+	return out
+}
+
+// FindIndex resolves an index by name or by JSON path ("type" and "$.type"
+// both match an index declared on "$.type").
+func (s *LSMStore) FindIndex(field string) (IndexDef, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	want, _ := parseJSONPath(field)
+	for _, ix := range s.indexes {
+		if ix.def.Name == field || (want != nil && strings.Join(ix.steps, ".") == strings.Join(want, ".")) {
+			return ix.def, true
+		}
+	}
+	return IndexDef{}, false
+}
+
+// Lookup streams the events in [from, to] whose indexed value equals value
+// and that pass opts.Filter, sorted and paged like Replay, without scanning
+// segments.
+func (s *LSMStore) Lookup(ctx context.Context, name, value string, from, to int64, opts ReplayOptions) (<-chan Event, error) {
+	if err := opts.Filter.compile(); err != nil { return nil, err }
+	start := time.Now()
+	if c := s.cutoff(); from < c { from = c }
+	if opts.After != nil && !opts.Desc && opts.After.TS > from { from = opts.After.TS }
+	keep := func(e Event) bool { return e.TS >= from && e.TS <= to && opts.Filter.match(e) }
+
+	s.mu.RLock()
+	ix := s.indexLocked(name)
+	if ix == nil {
+		s.mu.RUnlock()
+		return nil, ErrUnknownIndex
+	}
+	if !ix.def.Ready {
+		s.mu.RUnlock()
+		return nil, ErrIndexNotReady
+	}
+	var all []Event
+	for k := range ix.mem[value] {
+		if e, ok := s.mem.get(k); ok && keep(e) { all = append(all, e) }
+	}
+	files := append([]string(nil), s.manifest.Segments...)
+	release := s.pinSegmentsLocked(files) // a compaction must not delete them under us
+	s.mu.RUnlock()
+	defer release()
+
+	for _, f := range files {
+		path := filepath.Join(s.opts.DataDir, "sst", f)
+		keys, err := ix.readSidecar(path, value)
+		if err != nil { return nil, err }
+		evs, err := sstableGetMany(path, keys)
+		if err != nil { return nil, err }
+		for _, e := range evs {
+			if keep(e) { all = append(all, e) }
+		}
+	}
+	if opts.Desc {
+		sortByTSDesc(all)
+	} else {
+		sortByTS(all)
+	}
+	all = opts.page(all)
+	observe("lookup", start)
+
+	out := make(chan Event, 128)
+	go func() {
+		defer close(out)
+		for _, e := range all {
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *LSMStore) indexLocked(name string) *secondaryIndex {
+	for _, ix := range s.indexes {
+		if ix.def.Name == name { return ix }
+	}
+	return nil
+}
+
+// indexPutLocked keeps the memtable part of every index in sync after an
+// upsert that replaced prev (if any) with cur.
+func (s *LSMStore) indexPutLocked(prev Event, hadPrev bool, cur Event) {
+	for _, ix := range s.indexes {
+		if hadPrev { ix.remove(prev) }
+		ix.add(cur)
+	}
+}
+
+// writeIndexSidecarsLocked indexes a freshly written segment.
+func (s *LSMStore) writeIndexSidecarsLocked(segPath string, items []Event) error {
+	for _, ix := range s.indexes {
+		if err := ix.writeSidecar(segPath, items); err != nil { return err }
+	}
+	return nil
+}
+
+// resetIndexesLocked drops the memtable part of every index; used when the
+// memtable is cleared.
+func (s *LSMStore) resetIndexesLocked() {
+	for _, ix := range s.indexes { ix.mem = map[string]map[string]struct{}{} }
+}
+
+func (ix *secondaryIndex) add(e Event) {
+	v, ok := extractJSONPath(e.Value, ix.steps)
+	if !ok { return }
+	keys := ix.mem[v]
+	if keys == nil {
+		keys = map[string]struct{}{}
+		ix.mem[v] = keys
+	}
+	keys[e.Key] = struct{}{}
+}
+
+func (ix *secondaryIndex) remove(e Event) {
+	v, ok := extractJSONPath(e.Value, ix.steps)
+	if !ok { return }
+	delete(ix.mem[v], e.Key)
+	if len(ix.mem[v]) == 0 { delete(ix.mem, v) }
+}
+
+// sidecar: <segment>.idx.<name>.json mapping value -> sorted keys
+func (ix *secondaryIndex) sidecarPath(segPath string) string {
+	return segPath + ".idx." + ix.def.Name + ".json"
+}
+
+func (ix *secondaryIndex) writeSidecar(segPath string, items []Event) error {
+	m := map[string][]string{}
+	for _, e := range items {
+		if v, ok := extractJSONPath(e.Value, ix.steps); ok { m[v] = append(m[v], e.Key) }
+	}
+	for _, keys := range m { sort.Strings(keys) }
+	b, _ := json.Marshal(m)
+	return os.WriteFile(ix.sidecarPath(segPath), b, 0o644)
+}
+
+func (ix *secondaryIndex) readSidecar(segPath, value string) ([]string, error) {
+	b, err := os.ReadFile(ix.sidecarPath(segPath))
+	if err != nil { return nil, err }
+	var m map[string][]string
+	if err := json.Unmarshal(b, &m); err != nil { return nil, err }
+	return m[value], nil
+}
+
+// parseJSONPath accepts "$.a.b", "a.b" or "$" style dotted paths.
+func parseJSONPath(p string) ([]string, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+	if p == "" { return nil, errors.New("empty json path") }
+	steps := strings.Split(p, ".")
+	for _, st := range steps {
+		if st == "" { return nil, fmt.Errorf("invalid json path %q", p) }
+	}
+	return steps, nil
+}
+
+// extractJSONPath returns the value at steps in a canonical string form:
+// strings as-is, everything else as compact JSON.
+func extractJSONPath(v json.RawMessage, steps []string) (string, bool) {
+	cur := v
+	for _, st := range steps {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(cur, &obj); err != nil { return "", false }
+		next, ok := obj[st]
+		if !ok { return "", false }
+		cur = next
+	}
+	var str string
+	if err := json.Unmarshal(cur, &str); err == nil { return str, true }
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, cur); err != nil { return "", false }
+	return buf.String(), true
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func lookup(t *testing.T, s *LSMStore, name, value string) []string {
+	t.Helper()
+	ch, err := s.Lookup(context.Background(), name, value, math.MinInt64, math.MaxInt64, ReplayOptions{})
+	if err != nil { t.Fatal(err) }
+	var keys []string
+	for e := range ch { keys = append(keys, e.Key) }
+	return keys
+}
+
+func TestIndexLookupAcrossMemtableAndSegments(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 2)
+	if _, err := s.CreateIndex("type", "$.type"); err != nil { t.Fatal(err) }
+	put(t, s, "a", 1, `{"type":"x"}`)
+	put(t, s, "b", 2, `{"type":"y"}`) // flushes a and b
+	put(t, s, "c", 3, `{"type":"x"}`)
+
+	if got := lookup(t, s, "type", "x"); fmt.Sprint(got) != "[a c]" { t.Fatalf("x: %v", got) }
+	if got := lookup(t, s, "type", "y"); fmt.Sprint(got) != "[b]" { t.Fatalf("y: %v", got) }
+	if got := lookup(t, s, "type", "z"); len(got) != 0 { t.Fatalf("z: %v", got) }
+	if def, ok := s.FindIndex("type"); !ok || def.Path != "$.type" { t.Errorf("FindIndex by path: %+v %v", def, ok) }
+}
+
+func TestBuildAndDropIndex(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1)
+	put(t, s, "a", 1, `{"type":"x"}`)
+	def, err := s.CreateIndex("type", "type")
+	if err != nil { t.Fatal(err) }
+	if def.Ready { t.Fatal("index over existing segments is ready before a build") }
+	if _, err := s.Lookup(context.Background(), "type", "x", math.MinInt64, math.MaxInt64, ReplayOptions{}); !errors.Is(err, ErrIndexNotReady) {
+		t.Fatalf("lookup before build: %v", err)
+	}
+	if def, err = s.BuildIndex(context.Background(), "type"); err != nil || !def.Ready { t.Fatalf("build: %+v %v", def, err) }
+	if got := lookup(t, s, "type", "x"); fmt.Sprint(got) != "[a]" { t.Fatalf("after build: %v", got) }
+	if _, err := s.CreateIndex("type", "$.other"); err == nil { t.Error("created a duplicate index") }
+
+	if err := s.DropIndex("type"); err != nil { t.Fatal(err) }
+	if _, err := s.Lookup(context.Background(), "type", "x", math.MinInt64, math.MaxInt64, ReplayOptions{}); !errors.Is(err, ErrUnknownIndex) {
+		t.Fatalf("lookup after drop: %v", err)
+	}
+	if err := s.DropIndex("type"); !errors.Is(err, ErrUnknownIndex) { t.Fatalf("second drop: %v", err) }
+}
+
+// Lookups and builds racing compactions must not fail on segments the
+// compaction deletes.
+func TestIndexWhileCompacting(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	if _, err := s.CreateIndex("type", "$.type"); err != nil { t.Fatal(err) }
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := int64(1); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := s.Put(ctx, Event{Key: fmt.Sprint("k", i%20), TS: i, Value: []byte(`{"type":"x"}`)}); err != nil { t.Error(err); return }
+			if i%2 == 0 {
+				if err := s.Flush(ctx); err != nil { t.Error(err); return }
+			}
+			if i%6 == 0 {
+				if _, err := s.Compact(ctx); err != nil { t.Error(err); return }
+			}
+		}
+	}()
+	for i := 0; i < 2000; i++ {
+		ch, err := s.Lookup(ctx, "type", "x", math.MinInt64, math.MaxInt64, ReplayOptions{})
+		if err != nil { t.Fatalf("lookup %d: %v", i, err) }
+		for range ch {
+		}
+		if i%100 == 0 {
+			if _, err := s.BuildIndex(ctx, "type"); err != nil { t.Fatalf("build %d: %v", i, err) }
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
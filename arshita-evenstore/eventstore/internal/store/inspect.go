@@ -0,0 +1,103 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Offline access to a data directory, for tools that run while the server
+// is stopped. Nothing here takes the store's locks.
+
+// ErrBadSegment means a segment data file lacks the SST1 header.
+var ErrBadSegment = errors.New("not a segment file")
+
+// SegmentRecord is one line of a segment data file.
+type SegmentRecord struct {
+	Offset int64
+	Event  Event
+	Err    error // the line did not parse
+}
+
+// ScanSegment reads the segment data file at path in file order.
+func ScanSegment(path string, fn func(SegmentRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil { return err }
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, 1<<20)
+	header, err := br.ReadString('\n')
+	if err != nil || header != "SST1\n" { return fmt.Errorf("%s: %w", path, ErrBadSegment) }
+	off := int64(len(header))
+	for {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			rec := SegmentRecord{Offset: off}
+			var ok bool
+			rec.Event, ok, rec.Err = tryParseLine(strings.TrimRight(line, "\n"))
+			if rec.Err == nil && !ok { rec.Err = errors.New("malformed line") }
+			if err := fn(rec); err != nil { return err }
+			off += int64(len(line))
+		}
+		if errors.Is(err, io.EOF) { return nil }
+		if err != nil { return err }
+	}
+}
+
+// SegmentGet looks key up through the segment's key index.
+func SegmentGet(path, key string) (Event, bool, error) { return sstableGet(path, key) }
+
+// ReadKeyIndex returns the key -> offset index of the segment at path.
+func ReadKeyIndex(path string) (map[string]int64, error) {
+	b, err := os.ReadFile(path + ".index.json")
+	if err != nil { return nil, err }
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil { return nil, err }
+	return idx.Offsets, nil
+}
+
+// RebuildKeyIndex regenerates the segment's .index.json from its data file
+// and returns the number of keys indexed. Unparseable lines are left out.
+func RebuildKeyIndex(path string) (int, error) {
+	idx := index{Offsets: map[string]int64{}}
+	err := ScanSegment(path, func(rec SegmentRecord) error {
+		if rec.Err == nil { idx.Offsets[rec.Event.Key] = rec.Offset }
+		return nil
+	})
+	if err != nil { return 0, err }
+	return len(idx.Offsets), writeKeyIndex(path, idx)
+}
+
+// ManifestInfo is the manifest of a data directory.
+type ManifestInfo struct {
+	Segments []SegmentInfo `json:"segments"`
+	LastSeq  uint64        `json:"last_seq"`
+}
+
+// ReadManifest loads dataDir's manifest without creating one. Segments
+// with no recorded TS range report the full int64 range.
+func ReadManifest(dataDir string) (ManifestInfo, error) {
+	b, err := os.ReadFile(filepath.Join(dataDir, "manifest.json"))
+	if err != nil { return ManifestInfo{}, err }
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil { return ManifestInfo{}, fmt.Errorf("manifest: %w", err) }
+	out := ManifestInfo{Segments: make([]SegmentInfo, 0, len(m.Segments)), LastSeq: m.LastSeq}
+	for _, seg := range m.Segments {
+		r := m.rangeOf(seg)
+		info := SegmentInfo{Name: seg, MinTS: r.MinTS, MaxTS: r.MaxTS}
+		if fi, err := os.Stat(SegmentPath(dataDir, seg)); err == nil { info.Bytes = fi.Size() }
+		out.Segments = append(out.Segments, info)
+	}
+	return out, nil
+}
+
+// SegmentPath is where segment name lives in dataDir.
+func SegmentPath(dataDir, name string) string { return filepath.Join(dataDir, "sst", name) }
+
+// WALPath is dataDir's write-ahead log.
+func WALPath(dataDir string) string { return filepath.Join(dataDir, "wal.log") }
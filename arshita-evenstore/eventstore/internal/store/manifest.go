@@ -9,9 +9,30 @@ import (
 )
 
 type manifest struct {
-	Segments []string `json:"segments"`
+	Segments []string            `json:"segments"`
+	Ranges   map[string]tsRange  `json:"ranges,omitempty"` // per segment
+	Seqs     map[string]seqRange `json:"seqs,omitempty"`   // per segment
+	LastSeq  uint64              `json:"last_seq,omitempty"`
 }
 
+// tsRange is the span of event timestamps held by a segment.
+type tsRange struct {
+	MinTS int64 `json:"min_ts"`
+	MaxTS int64 `json:"max_ts"`
+}
+
+func spanOf(items []Event) tsRange {
+	r := tsRange{MinTS: maxTS, MaxTS: minTS}
+	for _, e := range items {
+		if e.TS < r.MinTS { r.MinTS = e.TS }
+		if e.TS > r.MaxTS { r.MaxTS = e.TS }
+	}
+	return r
+}
+
+// overlaps reports whether the segment may hold events in [from, to].
+func (r tsRange) overlaps(from, to int64) bool { return r.MinTS <= to && r.MaxTS >= from }
+
 func loadOrCreateManifest(path string) (*manifest, error) {
 	if _, err := os.Stat(path); err == nil {
 		b, err := os.ReadFile(path)
@@ -29,7 +50,18 @@ func (m *manifest) Save(path string) error {
 	return os.WriteFile(path, b, 0o644)
 }
 
-func (m *manifest) Add(seg string) { m.Segments = append(m.Segments, seg) }
+func (m *manifest) Add(seg string, r tsRange, seqs seqRange) {
+	m.Segments = append(m.Segments, seg)
+	if m.Ranges == nil { m.Ranges = map[string]tsRange{} }
+	if m.Seqs == nil { m.Seqs = map[string]seqRange{} }
+	m.Ranges[seg], m.Seqs[seg] = r, seqs
+}
+
+// rangeOf returns the segment's TS span; segments without one span everything.
+func (m *manifest) rangeOf(seg string) tsRange {
+	if r, ok := m.Ranges[seg]; ok { return r }
+	return tsRange{MinTS: minTS, MaxTS: maxTS}
+}
 
 func (m *manifest) nextName() string {
 	// find next incrementing number like 000001.sst
@@ -11,11 +11,14 @@ func newMemtable(max int) *memtable {
 	return &memtable{maxItems: max, data: make(map[string]Event, max)}
 }
 
-func (m *memtable) upsert(e Event) {
+// upsert keeps the newest event (by TS) per key and reports whether e won.
+func (m *memtable) upsert(e Event) bool {
 	cur, ok := m.data[e.Key]
 	if !ok || e.TS >= cur.TS {
 		m.data[e.Key] = e
+		return true
 	}
+	return false
 }
 
 func (m *memtable) get(key string) (Event, bool) {
@@ -44,9 +47,15 @@ func (m *memtable) rangeByTS(from, to int64) []Event {
 	return out
 }
 
+// sortByTSDesc is sortByTS reversed: newest first.
+func sortByTSDesc(v []Event) {
+	sort.SliceStable(v, func(i, j int) bool {
+		return v[j].Cursor().less(v[i].Cursor())
+	})
+}
+
 func sortByTS(v []Event) {
 	sort.SliceStable(v, func(i, j int) bool {
-		if v[i].TS == v[j].TS { return v[i].Key < v[j].Key }
-		return v[i].TS < v[j].TS
+		return v[i].Cursor().less(v[j].Cursor())
 	})
 }
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"eventstore/internal/metrics"
+)
+
+var (
+	opDuration     = metrics.NewHistogram("eventstore_store_op_duration_seconds", "Latency of store operations.", nil, "op")
+	eventsWritten  = metrics.NewCounter("eventstore_store_events_written_total", "Events appended to the WAL.").With()
+	flushes        = metrics.NewCounter("eventstore_flush_total", "Memtable flushes.").With()
+	flushDuration  = metrics.NewHistogram("eventstore_flush_duration_seconds", "Memtable flush latency.", nil).With()
+	flushedEvents  = metrics.NewCounter("eventstore_flushed_events_total", "Events written to segments by flushes.").With()
+	compactions    = metrics.NewCounter("eventstore_compaction_total", "Segment compactions.").With()
+	compactSeconds = metrics.NewHistogram("eventstore_compaction_duration_seconds", "Segment compaction latency.", []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300}).With()
+)
+
+// open stores, reported by the scrape-time gauges below
+var openStores sync.Map // *LSMStore -> struct{}
+
+func init() {
+	gauge := func(name, help string, read func(s *LSMStore) float64) {
+		metrics.NewGaugeFunc(name, help, []string{"store"}, func(emit func(float64, ...string)) {
+			openStores.Range(func(k, _ any) bool {
+				s := k.(*LSMStore)
+				emit(read(s), s.opts.DataDir)
+				return true
+			})
+		})
+	}
+	gauge("eventstore_memtable_items", "Keys held in the memtable.", func(s *LSMStore) float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return float64(s.mem.len())
+	})
+	gauge("eventstore_wal_bytes", "Size of the write-ahead log.", func(s *LSMStore) float64 {
+		st, err := os.Stat(s.wal.path)
+		if err != nil { return 0 }
+		return float64(st.Size())
+	})
+	gauge("eventstore_segments", "Number of segments.", func(s *LSMStore) float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return float64(len(s.manifest.Segments))
+	})
+	gauge("eventstore_segment_bytes", "Total size of segment data files.", func(s *LSMStore) float64 {
+		s.mu.RLock()
+		segs := append([]string(nil), s.manifest.Segments...)
+		s.mu.RUnlock()
+		var n int64
+		for _, seg := range segs {
+			if st, err := os.Stat(filepath.Join(s.opts.DataDir, "sst", seg)); err == nil { n += st.Size() }
+		}
+		return float64(n)
+	})
+	gauge("eventstore_outbox_depth", "Committed events not yet relayed to Kafka.", func(s *LSMStore) float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if !s.retainWAL { return 0 }
+		return float64(s.seq - s.published)
+	})
+	gauge("eventstore_last_seq", "Sequence number of the last event written.", func(s *LSMStore) float64 {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return float64(s.seq)
+	})
+}
+
+func observe(op string, start time.Time) { opDuration.With(op).Observe(time.Since(start).Seconds()) }
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Projection folds the events accepted by Filter into a JSON read model.
+// Fold receives the current state and returns the next one; it runs under
+// the store write lock, so it must be fast and must not call back into the store.
+// An event Fold fails on stops the projection before it: its checkpoint
+// stays put and LastError says why until a rebuild or restart gets past it.
+type Projection struct {
+	Name    string
+	Initial json.RawMessage
+	Filter  func(Event) bool // nil accepts every event
+	Fold    func(state json.RawMessage, e Event) (json.RawMessage, error)
+}
+
+// ProjectionState is the persisted view of a projection.
+type ProjectionState struct {
+	Name       string          `json:"name"`
+	Checkpoint uint64          `json:"checkpoint"` // seq of the last folded event
+	Events     int64           `json:"events"`
+	State      json.RawMessage `json:"state"`
+	LastError  string          `json:"last_error,omitempty"` // set while stopped at a failing event
+	Missing    uint64          `json:"missing_events,omitempty"` // never folded: gone from the store before catch-up read them
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+type projection struct {
+	def Projection
+	st  ProjectionState
+}
+
+var ErrUnknownProjection = errors.New("unknown projection")
+
+// RegisterProjection loads the persisted state of p (if any), catches it up
+// from the stored events and keeps it current on every subsequent Put.
+func (s *LSMStore) RegisterProjection(p Projection) error {
+	if p.Name == "" || strings.ContainsAny(p.Name, `/\.`) {
+		return fmt.Errorf("invalid projection name %q", p.Name)
+	}
+	if p.Fold == nil { return errors.New("projection fold required") }
+	if len(p.Initial) == 0 { p.Initial = json.RawMessage("null") }
+
+	pr := &projection{def: p, st: ProjectionState{Name: p.Name, State: p.Initial}}
+	b, err := os.ReadFile(s.projectionPath(p.Name))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &pr.st); err != nil {
+			return fmt.Errorf("projection %s: %w", p.Name, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if s.hasProjection(p.Name) { return fmt.Errorf("projection %s already registered", p.Name) }
+	return s.installProjection(pr, s.Changes(pr.st.Checkpoint), func(old *projection) error {
+		if old != nil { return fmt.Errorf("projection %s already registered", p.Name) }
+		return nil
+	})
+}
+
+func (s *LSMStore) hasProjection(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.projections[name]
+	return ok
+}
+
+// Projection returns a snapshot of the named projection.
+func (s *LSMStore) Projection(name string) (ProjectionState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pr, ok := s.projections[name]
+	if !ok { return ProjectionState{}, false }
+	return pr.st, true
+}
+
+// Projections lists the registered projection names.
+func (s *LSMStore) Projections() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, 0, len(s.projections))
+	for n := range s.projections { out = append(out, n) }
+	sort.Strings(out)
+	return out
+}
+
+// RebuildProjection folds every stored event into a fresh state for the
+// named projection and swaps it in. Past the WAL the segments keep only the
+// newest event per key, so events overwritten before a flush, compacted away
+// or expired cannot be folded again: the state counts them in Missing.
+func (s *LSMStore) RebuildProjection(name string) (ProjectionState, error) {
+	s.mu.RLock()
+	cur, ok := s.projections[name]
+	s.mu.RUnlock()
+	if !ok { return ProjectionState{}, ErrUnknownProjection }
+	pr := &projection{def: cur.def, st: ProjectionState{Name: name, State: cur.def.Initial}}
+	err := s.installProjection(pr, s.Changes(0), func(old *projection) error {
+		if old == nil { return ErrUnknownProjection }
+		return nil
+	})
+	return pr.st, err
+}
+
+// projectionBatch is how many events catch-up reads at a time.
+const projectionBatch = 1024
+
+// installProjection catches pr up from r, the stored events after its
+// checkpoint in seq order, and makes it live. Most of the history is folded
+// without the store lock; only the events committed meanwhile are folded
+// under the write lock, where check vets the projection pr replaces and
+// Puts take over.
+func (s *LSMStore) installProjection(pr *projection, r *ChangeReader, check func(old *projection) error) error {
+	pr.st.LastError = ""
+	if err := pr.catchUp(r, false); err != nil { return err }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := check(s.projections[pr.def.Name]); err != nil { return err }
+	if err := pr.catchUp(r, true); err != nil { return err }
+	if r.missed > 0 {
+		pr.st.Missing += r.missed
+		slog.Warn("projection caught up without events no longer stored", "projection", pr.def.Name, "missing", r.missed)
+	}
+	s.projections[pr.def.Name] = pr
+	return s.saveProjectionLocked(pr)
+}
+
+// catchUp folds what r returns until it is caught up or Fold fails; with
+// locked the caller holds s.mu. Only a read error is returned: a Fold
+// failure stops pr, as it does for Puts.
+func (pr *projection) catchUp(r *ChangeReader, locked bool) error {
+	for pr.st.LastError == "" {
+		evs, err := r.next(projectionBatch, locked)
+		if err != nil { return fmt.Errorf("projection %s: %w", pr.def.Name, err) }
+		if len(evs) == 0 { return nil }
+		for _, e := range evs {
+			if !pr.apply(e) { break }
+		}
+	}
+	return nil
+}
+
+func (s *LSMStore) applyProjectionsLocked(e Event) {
+	for _, pr := range s.projections {
+		if pr.st.LastError == "" { pr.apply(e) }
+	}
+}
+
+// apply folds e into pr and moves the checkpoint past it. If Fold fails, pr
+// is left as it was and reports the error.
+func (pr *projection) apply(e Event) bool {
+	if pr.def.Filter == nil || pr.def.Filter(e) {
+		next, err := pr.def.Fold(pr.st.State, e)
+		if err != nil {
+			pr.st.LastError = fmt.Sprintf("seq %d key %s: %v", e.Seq, e.Key, err)
+			slog.Warn("projection stopped", "projection", pr.def.Name, "seq", e.Seq, "key", e.Key, "err", err)
+			return false
+		}
+		pr.st.State = next
+		pr.st.Events++
+		pr.st.UpdatedAt = time.Now().UTC()
+	}
+	if e.Seq > pr.st.Checkpoint { pr.st.Checkpoint = e.Seq }
+	return true
+}
+
+func (s *LSMStore) saveProjectionsLocked() error {
+	for _, pr := range s.projections {
+		if err := s.saveProjectionLocked(pr); err != nil { return err }
+	}
+	return nil
+}
+
+func (s *LSMStore) saveProjectionLocked(pr *projection) error {
+	path := s.projectionPath(pr.def.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { return err }
+	b, _ := json.MarshalIndent(pr.st, "", "  ")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil { return err }
+	return os.Rename(tmp, path)
+}
+
+func (s *LSMStore) projectionPath(name string) string {
+	return filepath.Join(s.opts.DataDir, "projections", name+".json")
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// typeCounts counts events by their value's "type" and fails on "bad".
+func typeCounts() Projection {
+	return Projection{
+		Name:    "types",
+		Initial: json.RawMessage(`{}`),
+		Fold: func(state json.RawMessage, e Event) (json.RawMessage, error) {
+			var counts map[string]int
+			if err := json.Unmarshal(state, &counts); err != nil { return nil, err }
+			var v struct{ Type string }
+			json.Unmarshal(e.Value, &v)
+			if v.Type == "bad" { return nil, errors.New("bad event") }
+			counts[v.Type]++
+			return json.Marshal(counts)
+		},
+	}
+}
+
+func state(t *testing.T, s *LSMStore) ProjectionState {
+	t.Helper()
+	st, ok := s.Projection("types")
+	if !ok { t.Fatal("projection not registered") }
+	return st
+}
+
+// counts renders a typeCounts state independent of its JSON layout.
+func counts(st ProjectionState) string {
+	var m map[string]int
+	json.Unmarshal(st.State, &m)
+	return fmt.Sprint(m)
+}
+
+func TestProjectionFoldsPuts(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	if err := s.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	put(t, s, "k1", 1, `{"type":"a"}`)
+	put(t, s, "k2", 2, `{"type":"b"}`)
+	put(t, s, "k3", 3, `{"type":"a"}`)
+	if st := state(t, s); string(st.State) != `{"a":2,"b":1}` || st.Events != 3 || st.Checkpoint != 3 {
+		t.Fatalf("state %s events %d cp %d", st.State, st.Events, st.Checkpoint)
+	}
+	if err := s.RegisterProjection(typeCounts()); err == nil { t.Error("registered the same name twice") }
+	if _, err := s.RebuildProjection("nope"); !errors.Is(err, ErrUnknownProjection) { t.Errorf("rebuild unknown: %v", err) }
+}
+
+func TestProjectionPersistsAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, 2)
+	if err := s.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	put(t, s, "k1", 1, `{"type":"a"}`)
+	put(t, s, "k2", 2, `{"type":"a"}`) // flushes and checkpoints
+	put(t, s, "k3", 3, `{"type":"b"}`)
+	if err := s.Close(); err != nil { t.Fatal(err) }
+
+	s2 := openTestStore(t, dir, 2)
+	if err := s2.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	if st := state(t, s2); counts(st) != "map[a:2 b:1]" || st.Events != 3 || st.Checkpoint != 3 {
+		t.Fatalf("after restart: state %s events %d cp %d", st.State, st.Events, st.Checkpoint)
+	}
+	rebuilt, err := s2.RebuildProjection("types")
+	if err != nil { t.Fatal(err) }
+	if counts(rebuilt) != "map[a:2 b:1]" || rebuilt.Checkpoint != 3 { t.Fatalf("rebuilt %+v", rebuilt) }
+}
+
+func TestProjectionFilterAndFoldErrors(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	p := typeCounts()
+	p.Filter = func(e Event) bool { return e.Key != "skip" }
+	if err := s.RegisterProjection(p); err != nil { t.Fatal(err) }
+	put(t, s, "k1", 1, `{"type":"a"}`)
+	put(t, s, "skip", 2, `{"type":"a"}`)
+	put(t, s, "k2", 3, `{"type":"bad"}`)
+	st := state(t, s)
+	if string(st.State) != `{"a":1}` || st.Events != 1 || st.LastError == "" {
+		t.Fatalf("state %s events %d error %q", st.State, st.Events, st.LastError)
+	}
+}
+
+func TestProjectionRebuildFoldsOverwrittenEvents(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	if err := s.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	put(t, s, "k1", 1, `{"type":"a"}`)
+	put(t, s, "k1", 2, `{"type":"b"}`) // replaces k1 in the memtable
+	put(t, s, "k2", 3, `{"type":"a"}`)
+	live := state(t, s)
+
+	rebuilt, err := s.RebuildProjection("types")
+	if err != nil { t.Fatal(err) }
+	if string(rebuilt.State) != string(live.State) || rebuilt.Events != 3 || rebuilt.Checkpoint != 3 {
+		t.Fatalf("rebuilt %s (%d events, cp %d), live %s", rebuilt.State, rebuilt.Events, rebuilt.Checkpoint, live.State)
+	}
+}
+
+// Once a flush has dropped the WAL, an event overwritten before it is gone:
+// a rebuild cannot fold it and says so.
+func TestProjectionRebuildCountsMissingEvents(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	if err := s.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	put(t, s, "k1", 1, `{"type":"a"}`)
+	put(t, s, "k1", 2, `{"type":"b"}`)
+	put(t, s, "k2", 3, `{"type":"a"}`)
+	if err := s.Flush(context.Background()); err != nil { t.Fatal(err) }
+	put(t, s, "k3", 4, `{"type":"a"}`)
+	if st := state(t, s); st.Missing != 0 || st.Events != 4 { t.Fatalf("live: %+v", st) }
+
+	rebuilt, err := s.RebuildProjection("types")
+	if err != nil { t.Fatal(err) }
+	if rebuilt.Missing != 1 || rebuilt.Events != 3 || rebuilt.Checkpoint != 4 || counts(rebuilt) != "map[a:2 b:1]" {
+		t.Fatalf("rebuilt %s: %+v", counts(rebuilt), rebuilt)
+	}
+	if st := state(t, s); st.Missing != 1 { t.Fatalf("missing not kept: %+v", st) }
+
+	// with the WAL kept from the start nothing is lost
+	s2 := openTestStore(t, t.TempDir(), 1000)
+	if err := s2.RetainWAL(); err != nil { t.Fatal(err) }
+	if err := s2.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	put(t, s2, "k1", 1, `{"type":"a"}`)
+	put(t, s2, "k1", 2, `{"type":"b"}`)
+	if err := s2.Flush(context.Background()); err != nil { t.Fatal(err) }
+	if rebuilt, err := s2.RebuildProjection("types"); err != nil || rebuilt.Missing != 0 || rebuilt.Events != 2 {
+		t.Fatalf("rebuilt with the WAL kept: %+v, %v", rebuilt, err)
+	}
+}
+
+// A restart folds the WAL written after the last checkpoint, every event of it.
+func TestProjectionCatchUpAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, 1000)
+	if err := s.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	put(t, s, "k1", 1, `{"type":"a"}`)
+	if err := s.Flush(context.Background()); err != nil { t.Fatal(err) } // checkpoint 1
+	put(t, s, "k1", 2, `{"type":"b"}`)
+	put(t, s, "k1", 3, `{"type":"c"}`)
+	live := state(t, s)
+
+	// every append is flushed to wal.log, so a copy now is what a crash leaves
+	crashed := t.TempDir()
+	copyDir(t, dir, crashed)
+	s2 := openTestStore(t, crashed, 1000)
+	if err := s2.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	if got := state(t, s2); string(got.State) != string(live.State) || got.Checkpoint != 3 {
+		t.Fatalf("after restart %s cp %d, want %s cp 3", got.State, got.Checkpoint, live.State)
+	}
+}
+
+func TestProjectionStopsAtFoldError(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 1000)
+	if err := s.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	put(t, s, "k1", 1, `{"type":"a"}`)
+	put(t, s, "k2", 2, `{"type":"bad"}`)
+	put(t, s, "k3", 3, `{"type":"a"}`)
+
+	st := state(t, s)
+	if st.Checkpoint != 1 || st.LastError == "" || string(st.State) != `{"a":1}` {
+		t.Fatalf("after failing fold: %+v", st)
+	}
+	// a rebuild meets the same event and stops there again
+	st, err := s.RebuildProjection("types")
+	if err != nil { t.Fatal(err) }
+	if st.Checkpoint != 1 || st.LastError == "" { t.Fatalf("after rebuild: %+v", st) }
+}
+
+// Puts racing a rebuild land exactly once: in the history the rebuild reads
+// or in the tail it folds under the lock.
+func TestProjectionRebuildDuringWrites(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 50) // flush often
+	if err := s.RegisterProjection(typeCounts()); err != nil { t.Fatal(err) }
+	for i := 0; i < 500; i++ { put(t, s, fmt.Sprint("pre", i), int64(i+1), `{"type":"a"}`) }
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 500; i++ { put(t, s, fmt.Sprint("live", i), int64(i+1), `{"type":"a"}`) }
+	}()
+	for rebuilding := true; rebuilding; { // keep rebuilding for as long as the writer flushes
+		select {
+		case <-done: rebuilding = false
+		default:
+		}
+		if _, err := s.RebuildProjection("types"); err != nil { t.Fatal(err) }
+	}
+	wg.Wait()
+	if st := state(t, s); st.Events != 1000 || st.Checkpoint != 1000 || string(st.State) != `{"a":1000}` {
+		t.Fatalf("after concurrent rebuilds: events %d cp %d state %s", st.Events, st.Checkpoint, st.State)
+	}
+}
+
+func copyDir(t *testing.T, from, to string) {
+	t.Helper()
+	err := filepath.Walk(from, func(p string, fi os.FileInfo, err error) error {
+		if err != nil { return err }
+		rel, _ := filepath.Rel(from, p)
+		if fi.IsDir() { return os.MkdirAll(filepath.Join(to, rel), 0o755) }
+		b, err := os.ReadFile(p)
+		if err != nil { return err }
+		return os.WriteFile(filepath.Join(to, rel), b, 0o644)
+	})
+	if err != nil { t.Fatal(err) }
+}
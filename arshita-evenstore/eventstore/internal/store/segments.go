@@ -0,0 +1,73 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// seqRange is the span of seqs held by a segment; unsequenced events count
+// as seq 0.
+type seqRange struct {
+	Min uint64 `json:"min"`
+	Max uint64 `json:"max"`
+}
+
+func seqSpanOf(items []Event) seqRange {
+	if len(items) == 0 { return seqRange{} }
+	r := seqRange{Min: items[0].Seq, Max: items[0].Seq}
+	for _, e := range items[1:] {
+		if e.Seq < r.Min { r.Min = e.Seq }
+		if e.Seq > r.Max { r.Max = e.Seq }
+	}
+	return r
+}
+
+// segmentPins counts the readers of each segment working outside the store
+// lock. A segment retired by Compact stays on disk until the last of them
+// is done.
+type segmentPins struct {
+	mu      sync.Mutex
+	n       map[string]int
+	retired map[string]bool
+}
+
+// pinSegmentsLocked keeps segs on disk until release is called. Caller must
+// hold s.mu, so a compaction cannot retire them in between.
+func (s *LSMStore) pinSegmentsLocked(segs []string) (release func()) {
+	p := &s.pins
+	p.mu.Lock()
+	if p.n == nil { p.n = map[string]int{} }
+	for _, seg := range segs { p.n[seg]++ }
+	p.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for _, seg := range segs {
+				if p.n[seg]--; p.n[seg] > 0 { continue }
+				delete(p.n, seg)
+				if p.retired[seg] {
+					delete(p.retired, seg)
+					removeSegment(filepath.Join(s.opts.DataDir, "sst", seg))
+				}
+			}
+		})
+	}
+}
+
+// retireSegmentsLocked deletes segments that left the manifest, or marks
+// them for deletion once unpinned.
+func (s *LSMStore) retireSegmentsLocked(segs []string) {
+	p := &s.pins
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, seg := range segs {
+		if p.n[seg] > 0 {
+			if p.retired == nil { p.retired = map[string]bool{} }
+			p.retired[seg] = true
+			continue
+		}
+		removeSegment(filepath.Join(s.opts.DataDir, "sst", seg))
+	}
+}
@@ -28,19 +28,24 @@ func sstableWrite(path string, items []Event) error {
 
 	idx := index{Offsets: make(map[string]int64, len(items))}
 
+	// track offsets ourselves: the file position lags behind the buffered writer
+	off := int64(len(header))
 	for _, e := range items {
-		off, _ := f.Seek(0, os.SEEK_CUR)
 		idx.Offsets[e.Key] = off
 
-		line := fmt.Sprintf("%s\t%d\t%s\n", e.Key, e.TS, base64.StdEncoding.EncodeToString(e.Value))
+		line := fmt.Sprintf("%s\t%d\t%s\t%d\n", e.Key, e.TS, base64.StdEncoding.EncodeToString(e.Value), e.Seq)
 		if _, err := w.WriteString(line); err != nil { return err }
+		off += int64(len(line))
 	}
 	if err := w.Flush(); err != nil { return err }
 
-	// write index sidecar
-	ip := path + ".index.json"
+	return writeKeyIndex(path, idx)
+}
+
+// writeKeyIndex writes the key -> offset sidecar of a segment.
+func writeKeyIndex(path string, idx index) error {
 	b, _ := json.Marshal(idx)
-	return os.WriteFile(ip, b, 0o644)
+	return os.WriteFile(path+".index.json", b, 0o644)
 }
 
 func sstableGet(path, key string) (Event, bool, error) {
@@ -69,7 +74,36 @@ func sstableGet(path, key string) (Event, bool, error) {
 	return parseLine(strings.TrimRight(line, "\n"))
 }
 
-func sstableRangeTS(path string, from, to int64) ([]Event, error) {
+// sstableGetMany fetches several keys with a single index read and file open.
+func sstableGetMany(path string, keys []string) ([]Event, error) {
+	if len(keys) == 0 { return nil, nil }
+	b, err := os.ReadFile(path + ".index.json")
+	if err != nil { return nil, err }
+	var idx index
+	if err := json.Unmarshal(b, &idx); err != nil { return nil, err }
+
+	f, err := os.Open(path)
+	if err != nil { return nil, err }
+	defer f.Close()
+
+	out := make([]Event, 0, len(keys))
+	for _, k := range keys {
+		off, ok := idx.Offsets[k]
+		if !ok { continue }
+		if _, err := f.Seek(off, 0); err != nil { return nil, err }
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if err != nil { return nil, err }
+		ev, ok, err := parseLine(strings.TrimRight(line, "\n"))
+		if err != nil { return nil, err }
+		if ok { out = append(out, ev) }
+	}
+	return out, nil
+}
+
+// sstableRangeTS returns the segment's events in [from, to] accepted by keep
+// (nil accepts all), sorted by TS. Rejected events are dropped as the file
+// is read, so a selective filter holds little of the segment in memory.
+func sstableRangeTS(path string, from, to int64, keep func(Event) bool) ([]Event, error) {
 	f, err := os.Open(path)
 	if err != nil { return nil, err }
 	defer f.Close()
@@ -89,7 +123,7 @@ func sstableRangeTS(path string, from, to int64) ([]Event, error) {
 		if line == "" { break }
 		ev, ok, _ := tryParseLine(line)
 		if !ok { continue }
-		if ev.TS >= from && ev.TS <= to { out = append(out, ev) }
+		if ev.TS >= from && ev.TS <= to && (keep == nil || keep(ev)) { out = append(out, ev) }
 	}
 	sortByTS(out)
 	return out, nil
@@ -102,12 +136,17 @@ func parseLine(line string) (Event, bool, error) {
 }
 
 func tryParseLine(line string) (Event, bool, error) {
+	// key \t ts \t base64(value) [\t seq]; segments written before sequencing have no seq column
 	parts := strings.Split(line, "\t")
-	if len(parts) != 3 { return Event{}, false, nil }
+	if len(parts) != 3 && len(parts) != 4 { return Event{}, false, nil }
 	key := parts[0]
 	var ts int64
 	if _, err := fmt.Sscanf(parts[1], "%d", &ts); err != nil { return Event{}, false, err }
 	valBytes, err := base64.StdEncoding.DecodeString(parts[2])
 	if err != nil { return Event{}, false, err }
-	return Event{Key: key, TS: ts, Value: valBytes}, true, nil
+	var seq uint64
+	if len(parts) == 4 {
+		if _, err := fmt.Sscanf(parts[3], "%d", &seq); err != nil { return Event{}, false, err }
+	}
+	return Event{Key: key, TS: ts, Value: valBytes, Seq: seq}, true, nil
 }
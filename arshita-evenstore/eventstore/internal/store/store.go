@@ -4,29 +4,62 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"eventstore/internal/trace"
 )
 
 type Event struct {
 	Key   string
 	TS    int64
 	Value json.RawMessage
+	Seq   uint64 // assigned by the store on Put; 0 for data written before sequencing
+	// traceparent of the write, kept in the WAL (not in segments) so the
+	// Kafka relay can continue the writer's trace
+	Trace string `json:",omitempty"`
 }
 
+// bounds for "all of time" scans
+const (
+	minTS int64 = math.MinInt64
+	maxTS int64 = math.MaxInt64
+)
+
 type Options struct {
 	DataDir          string
 	MemtableMaxItems int
+	Retention        time.Duration // events with TS (ms) older than now-Retention are hidden and dropped on flush; 0 keeps everything
 }
 
 type LSMStore struct {
 	opts Options
 
-	mu       sync.RWMutex
-	mem      *memtable
-	wal      *wal
-	manifest *manifest
+	mu          sync.RWMutex
+	mem         *memtable
+	wal         *wal
+	manifest    *manifest
+	seq         uint64
+	projections map[string]*projection
+	indexes     []*secondaryIndex
+	subs        map[*Subscription]struct{}
+	readOnly    atomic.Bool
+	retention   atomic.Int64 // time.Duration; starts as opts.Retention
+	pins        segmentPins
+
+	// WAL retention for the relay (changes.go)
+	retainWAL bool
+	published uint64
+	archives  []walArchive
+	walGen    uint64
+	commits   chan struct{}
 }
 
 func NewLSMStore(opts Options) (*LSMStore, error) {
@@ -45,44 +78,143 @@ func NewLSMStore(opts Options) (*LSMStore, error) {
 
 	mem := newMemtable(opts.MemtableMaxItems)
 
-	s := &LSMStore{opts: opts, mem: mem, wal: wal, manifest: mf}
+	s := &LSMStore{opts: opts, mem: mem, wal: wal, manifest: mf, seq: mf.LastSeq, projections: map[string]*projection{}, commits: make(chan struct{}, 1)}
+	s.retention.Store(int64(opts.Retention))
+	if err := s.loadIndexes(); err != nil { return nil, err }
+	if err := s.backfillRanges(); err != nil { return nil, err }
 
 	// recover from WAL into memtable (best-effort)
-	if err := wal.Replay(func(e Event) { s.mem.upsert(e) }); err != nil {
+	recovered := 0
+	if err := wal.Replay(func(e Event) {
+		s.upsertLocked(e)
+		if e.Seq > s.seq { s.seq = e.Seq }
+		recovered++
+	}); err != nil {
 		return nil, err
 	}
+	if recovered > 0 { slog.Info("store recovered events from wal", "dir", opts.DataDir, "events", recovered) }
+	if err := s.loadRelayState(); err != nil { return nil, err }
 
+	openStores.Store(s, struct{}{})
 	return s, nil
 }
 
-func (s *LSMStore) Put(ctx context.Context, e Event) error {
-	if e.Key == "" || e.TS == 0 || len(e.Value) == 0 {
-		return errors.New("invalid event")
+// backfillRanges records TS and seq ranges for segments written before the
+// manifest kept them, so range pruning, newest-first replay and change
+// readers can use them.
+func (s *LSMStore) backfillRanges() error {
+	changed := false
+	for _, seg := range s.manifest.Segments {
+		_, hasTS := s.manifest.Ranges[seg]
+		_, hasSeqs := s.manifest.Seqs[seg]
+		if hasTS && hasSeqs { continue }
+		evs, err := sstableRangeTS(filepath.Join(s.opts.DataDir, "sst", seg), minTS, maxTS, nil)
+		if err != nil { return err }
+		if s.manifest.Ranges == nil { s.manifest.Ranges = map[string]tsRange{} }
+		if s.manifest.Seqs == nil { s.manifest.Seqs = map[string]seqRange{} }
+		if !hasTS { s.manifest.Ranges[seg] = spanOf(evs) }
+		s.manifest.Seqs[seg] = seqSpanOf(evs)
+		changed = true
+	}
+	if !changed { return nil }
+	return s.manifest.Save(filepath.Join(s.opts.DataDir, "manifest.json"))
+}
+
+var ErrInvalidEvent = errors.New("invalid event")
+
+func validEvent(e Event) bool { return e.Key != "" && e.TS != 0 && len(e.Value) > 0 }
+
+func (s *LSMStore) Put(ctx context.Context, e Event) (err error) {
+	if !validEvent(e) {
+		return ErrInvalidEvent
 	}
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+	defer observe("put", time.Now())
+	ctx, span := trace.Start(ctx, "store.Put", trace.KindInternal)
+	span.SetAttr("key", e.Key)
+	span.SetAttr("ts", e.TS)
+	defer func() { span.SetError(err); span.End() }()
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	e.Seq = s.seq + 1
+	e.Trace = span.Context().Traceparent()
 	if err := s.wal.Append(e); err != nil {
 		return err
 	}
+	s.seq = e.Seq
+	eventsWritten.Inc()
+	span.SetAttr("seq", e.Seq)
 
-	s.mem.upsert(e)
+	s.upsertLocked(e)
+	s.applyProjectionsLocked(e)
+	s.notifyLocked(e)
 
 	if s.mem.full() {
-		if err := s.flushLocked(); err != nil {
+		if err := s.flushLocked(ctx); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// PutBatch validates every event and writes the valid ones as one WAL
+// record. The returned slice holds a per-event error (nil when stored); the
+// second result reports a WAL or flush failure, as for Put.
+func (s *LSMStore) PutBatch(ctx context.Context, evs []Event) ([]error, error) {
+	errs := make([]error, len(evs))
+	valid := make([]Event, 0, len(evs))
+	for i, e := range evs {
+		if !validEvent(e) {
+			errs[i] = ErrInvalidEvent
+			continue
+		}
+		valid = append(valid, e)
+	}
+	if len(valid) == 0 { return errs, nil }
+	if s.ReadOnly() { return nil, ErrReadOnly }
+	defer observe("put_batch", time.Now())
+	ctx, span := trace.Start(ctx, "store.PutBatch", trace.KindInternal)
+	span.SetAttr("events", len(valid))
+	defer span.End()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tp := span.Context().Traceparent()
+	for i := range valid { valid[i].Seq, valid[i].Trace = s.seq+uint64(i)+1, tp }
+	if err := s.wal.AppendBatch(valid); err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	s.seq = valid[len(valid)-1].Seq
+	eventsWritten.Add(float64(len(valid)))
+
+	for _, e := range valid {
+		s.upsertLocked(e)
+		s.applyProjectionsLocked(e)
+		s.notifyLocked(e)
+	}
+
+	if s.mem.full() {
+		if err := s.flushLocked(ctx); err != nil {
+			span.SetError(err)
+			return errs, err
+		}
+	}
+	return errs, nil
+}
+
 func (s *LSMStore) Get(ctx context.Context, key string) (Event, bool, error) {
+	defer observe("get", time.Now())
 	s.mu.RLock()
 	ev, ok := s.mem.get(key)
 	s.mu.RUnlock()
 	if ok {
-		return ev, true, nil
+		return ev, !s.expired(ev), nil
 	}
 
 	// search SSTables (newest first)
@@ -94,35 +226,36 @@ func (s *LSMStore) Get(ctx context.Context, key string) (Event, bool, error) {
 		path := filepath.Join(s.opts.DataDir, "sst", files[i])
 		ev, ok, err := sstableGet(path, key)
 		if err != nil { return Event{}, false, err }
-		if ok { return ev, true, nil }
+		if ok { return ev, !s.expired(ev), nil }
 	}
 	return Event{}, false, nil
 }
 
-func (s *LSMStore) Replay(ctx context.Context, from, to int64) (<-chan Event, error) {
+// DataDir is where the store keeps its files; sibling components (schemas,
+// dead letters) persist next to it.
+func (s *LSMStore) DataDir() string { return s.opts.DataDir }
+
+func (s *LSMStore) Replay(ctx context.Context, from, to int64, opts ReplayOptions) (<-chan Event, error) {
+	if err := opts.Filter.compile(); err != nil { return nil, err }
+	if opts.After != nil && !opts.Desc && opts.After.TS > from { from = opts.After.TS }
 	out := make(chan Event, 128)
 
 	// Simple approach:
 	// 1) Collect eligible events from memtable and all sstables
-	// 2) Sort by TS
+	// 2) Sort by TS (then key, seq) and cut the requested page
 	go func() {
 		defer close(out)
-		var all []Event
 
+		start := time.Now()
 		s.mu.RLock()
-		all = append(all, s.mem.rangeByTS(from, to)...)
-		files := append([]string(nil), s.manifest.Segments...)
-		s.mu.RUnlock()
-
-		for _, f := range files {
-			path := filepath.Join(s.opts.DataDir, "sst", f)
-			evs, err := sstableRangeTS(path, from, to)
-			if err != nil { continue }
-			all = append(all, evs...)
+		var all []Event
+		if opts.Desc {
+			all = s.collectDescLocked(from, to, &opts)
+		} else {
+			all = opts.page(s.collectLocked(from, to, &opts.Filter))
 		}
-
-		// in-memory sort by TS (stable)
-		sortByTS(all)
+		s.mu.RUnlock()
+		observe("replay", start)
 
 		for _, e := range all {
 			select {
@@ -135,35 +268,167 @@ func (s *LSMStore) Replay(ctx context.Context, from, to int64) (<-chan Event, er
 	return out, nil
 }
 
+// SetRetention changes the retention period of a running store; 0 keeps
+// everything. It applies to reads at once and to data on the next flush.
+func (s *LSMStore) SetRetention(d time.Duration) { s.retention.Store(int64(d)) }
+
+// cutoff is the oldest TS still visible under the retention setting.
+func (s *LSMStore) cutoff() int64 {
+	r := time.Duration(s.retention.Load())
+	if r <= 0 { return minTS }
+	return time.Now().Add(-r).UnixMilli()
+}
+
+func (s *LSMStore) expired(e Event) bool { return e.TS < s.cutoff() }
+
+// upsertLocked applies e to the memtable and the memtable side of the
+// secondary indexes.
+func (s *LSMStore) upsertLocked(e Event) {
+	prev, hadPrev := s.mem.get(e.Key)
+	if s.mem.upsert(e) {
+		s.indexPutLocked(prev, hadPrev, e)
+	}
+}
+
+// collectLocked gathers every event in [from, to] accepted by f (nil accepts
+// all) from the memtable and all segments, sorted by TS. Caller must hold s.mu
+// (read or write).
+func (s *LSMStore) collectLocked(from, to int64, f *Filter) []Event {
+	if c := s.cutoff(); from < c { from = c }
+	if to < from { return nil }
+	var keep func(Event) bool
+	if f != nil && !f.empty() { keep = f.match }
+
+	var all []Event
+	for _, e := range s.mem.rangeByTS(from, to) {
+		if keep == nil || keep(e) { all = append(all, e) }
+	}
+	for _, seg := range s.manifest.Segments {
+		if !s.manifest.rangeOf(seg).overlaps(from, to) { continue }
+		path := filepath.Join(s.opts.DataDir, "sst", seg)
+		evs, err := sstableRangeTS(path, from, to, keep)
+		if err != nil { continue }
+		all = append(all, evs...)
+	}
+
+	// in-memory sort by TS (stable)
+	sortByTS(all)
+	return all
+}
+
+// collectDescLocked is collectLocked newest-first for opts. Segments are read
+// in order of decreasing MaxTS, and with a limit reading stops as soon as no
+// remaining segment can hold one of the newest opts.Limit events, so tail
+// queries cost about N rather than the whole window.
+func (s *LSMStore) collectDescLocked(from, to int64, opts *ReplayOptions) []Event {
+	if c := s.cutoff(); from < c { from = c }
+	if opts.After != nil && opts.After.TS < to { to = opts.After.TS }
+	if to < from { return nil }
+	var all []Event
+	keep := func(e Event) bool {
+		return opts.Filter.match(e) && (opts.After == nil || e.Cursor().less(*opts.After))
+	}
+	add := func(evs []Event) {
+		for _, e := range evs {
+			if keep(e) { all = append(all, e) }
+		}
+	}
+	// newest-first, keeping only the best Limit candidates
+	trim := func() {
+		sortByTSDesc(all)
+		if opts.Limit > 0 && len(all) > opts.Limit { all = all[:opts.Limit] }
+	}
+
+	add(s.mem.rangeByTS(from, to))
+	segs := make([]string, 0, len(s.manifest.Segments))
+	for _, seg := range s.manifest.Segments {
+		if s.manifest.rangeOf(seg).overlaps(from, to) { segs = append(segs, seg) }
+	}
+	sort.SliceStable(segs, func(i, j int) bool { return s.manifest.rangeOf(segs[i]).MaxTS > s.manifest.rangeOf(segs[j]).MaxTS })
+	for _, seg := range segs {
+		if opts.Limit > 0 && len(all) >= opts.Limit {
+			trim()
+			if all[len(all)-1].TS > s.manifest.rangeOf(seg).MaxTS { break }
+		}
+		evs, err := sstableRangeTS(filepath.Join(s.opts.DataDir, "sst", seg), from, to, keep)
+		if err != nil { continue }
+		all = append(all, evs...)
+	}
+	trim()
+	return all
+}
+
+// Check reports whether the store can accept writes, for health checks.
+func (s *LSMStore) Check() error {
+	if err := s.wal.Check(); err != nil { return fmt.Errorf("wal: %w", err) }
+	return nil
+}
+
 func (s *LSMStore) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	openStores.Delete(s)
+	for sub := range s.subs { sub.endLocked(nil) }
 	if s.mem.len() > 0 {
-		if err := s.flushLocked(); err != nil {
+		if err := s.flushLocked(context.Background()); err != nil {
 			return err
 		}
+	} else if err := s.saveProjectionsLocked(); err != nil {
+		return err
 	}
 	return s.wal.Close()
 }
 
-func (s *LSMStore) flushLocked() error {
-	// snapshot memtable
-	items := s.mem.snapshotSortedByKey()
-	if len(items) == 0 { return nil }
+func (s *LSMStore) flushLocked(ctx context.Context) (err error) {
+	if s.mem.len() == 0 { return nil }
+	start := time.Now()
+	_, span := trace.Start(ctx, "store.flush", trace.KindInternal)
+	defer func() { span.SetError(err); span.End() }()
 
-	// new segment filename
-	segName := s.manifest.nextName()
+	// snapshot memtable, dropping what is already past retention
+	items := s.mem.snapshotSortedByKey()
+	if c := s.cutoff(); c > minTS {
+		live := items[:0]
+		for _, e := range items {
+			if e.TS >= c { live = append(live, e) }
+		}
+		items = live
+	}
 
-	path := filepath.Join(s.opts.DataDir, "sst", segName)
-	if err := sstableWrite(path, items); err != nil {
-		return err
+	// new segment (skipped when everything expired)
+	var segName string
+	var segRange tsRange
+	var segSeqs seqRange
+	span.SetAttr("events", len(items))
+	if len(items) > 0 {
+		segRange, segSeqs = spanOf(items), seqSpanOf(items)
+		segName = s.manifest.nextName()
+		span.SetAttr("segment", segName)
+		path := filepath.Join(s.opts.DataDir, "sst", segName)
+		if err := sstableWrite(path, items); err != nil {
+			return err
+		}
+		if err := s.writeIndexSidecarsLocked(path, items); err != nil {
+			return err
+		}
 	}
 
-	// rotate wal (truncate) & clear mem
-	if err := s.wal.Rotate(); err != nil { return err }
+	// rotate wal (truncate, or archive for the relay) & clear mem
+	if err := s.rotateWALLocked(); err != nil { return err }
 	s.mem.clear()
+	s.resetIndexesLocked()
 
 	// update manifest
-	s.manifest.Add(segName)
-	return s.manifest.Save(filepath.Join(s.opts.DataDir, "manifest.json"))
+	if segName != "" { s.manifest.Add(segName, segRange, segSeqs) }
+	s.manifest.LastSeq = s.seq
+	if err := s.manifest.Save(filepath.Join(s.opts.DataDir, "manifest.json")); err != nil {
+		return err
+	}
+	flushes.Inc()
+	flushedEvents.Add(float64(len(items)))
+	flushDuration.Observe(time.Since(start).Seconds())
+	slog.DebugContext(ctx, "store flushed", "dir", s.opts.DataDir, "segment", segName, "events", len(items), "duration_ms", time.Since(start).Milliseconds())
+
+	// checkpoint projections together with the data they have folded
+	return s.saveProjectionsLocked()
 }
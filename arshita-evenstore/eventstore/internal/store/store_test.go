@@ -0,0 +1,19 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func openTestStore(t *testing.T, dir string, maxItems int) *LSMStore {
+	t.Helper()
+	s, err := NewLSMStore(Options{DataDir: dir, MemtableMaxItems: maxItems})
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func put(t *testing.T, s *LSMStore, key string, ts int64, value string) {
+	t.Helper()
+	if err := s.Put(context.Background(), Event{Key: key, TS: ts, Value: []byte(value)}); err != nil { t.Fatal(err) }
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrSlowConsumer ends a subscription whose buffer filled up.
+var ErrSlowConsumer = errors.New("subscriber too slow; resume from the last seq received")
+
+// Subscription delivers events committed after it was created, in seq order.
+// C is closed when the subscription ends; Err tells why.
+type Subscription struct {
+	C <-chan Event
+	// Start is the store seq at subscription time: every event with a larger
+	// seq arrives on C, everything up to it is history (Replay).
+	Start uint64
+
+	s      *LSMStore
+	ch     chan Event
+	filter Filter
+
+	once sync.Once
+	err  error
+}
+
+// Subscribe registers a live listener for events accepted by f. buffer bounds
+// how far the subscriber may lag before it is dropped with ErrSlowConsumer.
+func (s *LSMStore) Subscribe(f Filter, buffer int) (*Subscription, error) {
+	if err := f.compile(); err != nil { return nil, err }
+	if buffer <= 0 { buffer = 1024 }
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, s: s, ch: ch, filter: f}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.Start = s.seq
+	if s.subs == nil { s.subs = map[*Subscription]struct{}{} }
+	s.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Close unregisters the subscription.
+func (sub *Subscription) Close() {
+	sub.s.mu.Lock()
+	defer sub.s.mu.Unlock()
+	sub.endLocked(nil)
+}
+
+// Err is nil while the subscription runs or after Close, and
+// ErrSlowConsumer if it was dropped for lagging.
+func (sub *Subscription) Err() error {
+	sub.s.mu.RLock()
+	defer sub.s.mu.RUnlock()
+	return sub.err
+}
+
+func (sub *Subscription) endLocked(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		delete(sub.s.subs, sub)
+		close(sub.ch)
+	})
+}
+
+// notifyLocked fans a committed event out to the subscribers and wakes the
+// relay without ever blocking the write path.
+func (s *LSMStore) notifyLocked(e Event) {
+	select {
+	case s.commits <- struct{}{}:
+	default:
+	}
+	for sub := range s.subs {
+		if !sub.filter.match(e) { continue }
+		select {
+		case sub.ch <- e:
+		default:
+			sub.endLocked(ErrSlowConsumer)
+		}
+	}
+}
@@ -12,6 +12,7 @@ type wal struct {
 	f   *os.File
 	wr  *bufio.Writer
 	path string
+	failed error // last append error, cleared by the next successful append
 }
 
 func openWAL(path string) (*wal, error) {
@@ -24,31 +25,99 @@ func (w *wal) Append(e Event) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	b, _ := json.Marshal(e)
+	return w.writeLocked(b)
+}
+
+// AppendBatch writes the events as a single JSON-array record with one flush.
+func (w *wal) AppendBatch(evs []Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b, _ := json.Marshal(evs)
+	return w.writeLocked(b)
+}
+
+func (w *wal) writeLocked(b []byte) (err error) {
+	defer func() { w.failed = err }()
 	if _, err := w.wr.Write(b); err != nil { return err }
 	if err := w.wr.WriteByte('\n'); err != nil { return err }
 	return w.wr.Flush()
 }
 
+// Check reports whether the log can take writes: the file is still open and
+// the last append did not fail.
+func (w *wal) Check() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed != nil { return w.failed }
+	_, err := w.f.Stat()
+	return err
+}
+
 func (w *wal) Replay(emit func(Event)) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return ScanWAL(w.path, func(rec WALRecord) error {
+		for _, e := range rec.Events {
+			if e.Key != "" { emit(e) }
+		}
+		return nil // unreadable records are skipped
+	})
+}
+
+// WALRecord is one line of a write-ahead log: a single event, or a batch
+// written by PutBatch.
+type WALRecord struct {
+	Line   int
+	Batch  bool
+	Events []Event
+	Err    error // the line did not decode
+}
 
-	// open read-only
-	f, err := os.Open(w.path)
+// ScanWAL reads the log at path record by record without opening it for
+// writing, so it is safe on the data directory of a stopped store.
+func ScanWAL(path string, fn func(WALRecord) error) error {
+	f, err := os.Open(path)
 	if err != nil { return err }
 	defer f.Close()
 
 	sc := bufio.NewScanner(f)
 	sc.Buffer(make([]byte, 0, 1<<20), 1<<25)
-	for sc.Scan() {
-		var e Event
-		if err := json.Unmarshal(sc.Bytes(), &e); err == nil && e.Key != "" {
-			emit(e)
-		}
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 { continue }
+		rec := WALRecord{Line: n, Batch: line[0] == '['}
+		rec.Events, rec.Err = parseWALLine(line)
+		if err := fn(rec); err != nil { return err }
 	}
 	return sc.Err()
 }
 
+// parseWALLine decodes one log line: an event, or a JSON array of them.
+func parseWALLine(line []byte) ([]Event, error) {
+	if len(line) > 0 && line[0] == '[' {
+		var evs []Event
+		err := json.Unmarshal(line, &evs)
+		return evs, err
+	}
+	var e Event
+	if err := json.Unmarshal(line, &e); err != nil { return nil, err }
+	return []Event{e}, nil
+}
+
+// archive renames the log to path and starts a new, empty one.
+func (w *wal) archive(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.wr.Flush(); err != nil { return err }
+	if err := w.f.Close(); err != nil { return err }
+	renameErr := os.Rename(w.path, path)
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil { w.failed = err; return err }
+	w.f = f
+	w.wr.Reset(f)
+	return renameErr
+}
+
 func (w *wal) Rotate() error {
 	// truncate file
 	if err := w.wr.Flush(); err != nil { return err }
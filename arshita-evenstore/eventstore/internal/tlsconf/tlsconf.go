@@ -0,0 +1,120 @@
+// Package tlsconf builds the server TLS configuration and reloads the
+// certificate, key and client CA bundle when their files change.
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // optional; enables client certificate verification
+	RequireCert  bool   // with ClientCAFile: reject clients without a certificate
+}
+
+// Reloader serves the most recently loaded certificate and CA pool to every
+// new handshake. Existing connections keep what they negotiated.
+type Reloader struct {
+	opts Options
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+	mods map[string]time.Time
+}
+
+func New(opts Options) (*Reloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" { return nil, errors.New("tls: cert and key files required") }
+	r := &Reloader{opts: opts}
+	if err := r.Reload(); err != nil { return nil, err }
+	return r, nil
+}
+
+// Reload re-reads all files. On error the previous material stays in use.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil { return err }
+	var pool *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		b, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil { return err }
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) { return errors.New("tls: no certificates in " + r.opts.ClientCAFile) }
+	}
+	r.mu.Lock()
+	r.cert, r.pool, r.mods = &cert, pool, r.modTimes()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Reloader) modTimes() map[string]time.Time {
+	m := map[string]time.Time{}
+	for _, f := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if f == "" { continue }
+		if st, err := os.Stat(f); err == nil { m[f] = st.ModTime() }
+	}
+	return m
+}
+
+// Watch reloads whenever one of the files changes on disk.
+func (r *Reloader) Watch(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			now := r.modTimes()
+			r.mu.RLock()
+			changed := len(now) != len(r.mods)
+			for f, m := range now {
+				if !m.Equal(r.mods[f]) { changed = true }
+			}
+			r.mu.RUnlock()
+			if !changed { continue }
+			// cert and key are often replaced one after the other; a failed
+			// load is retried on the next tick
+			if err := r.Reload(); err != nil {
+				slog.Error("tls reload failed", "err", err)
+			} else {
+				slog.Info("tls certificates reloaded", "cert", r.opts.CertFile)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Config is the tls.Config for the HTTP server.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.pool != nil {
+				c.ClientCAs = r.pool
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+				if r.opts.RequireCert { c.ClientAuth = tls.RequireAndVerifyClientCert }
+			}
+			return c, nil
+		},
+	}
+}
@@ -0,0 +1,153 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eventstore/internal/mw"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// issue makes a certificate for cn, signed by ca or self-signed if ca is nil.
+func issue(t *testing.T, cn string, serial int64, ca *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil { t.Fatal(err) }
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil { t.Fatal(err) }
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil { t.Fatal(err) }
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
+
+func (c *testCert) pair(t *testing.T) tls.Certificate {
+	p, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	if err != nil { t.Fatal(err) }
+	return p
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0o600); err != nil { t.Fatal(err) }
+}
+
+// serve runs h over TLS with r's configuration and returns its address.
+func serve(t *testing.T, r *Reloader, h http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	srv := &http.Server{Handler: h}
+	go srv.Serve(tls.NewListener(ln, r.Config()))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func client(ca *testCert, certs ...tls.Certificate) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, Certificates: certs},
+		DisableKeepAlives: true, // every request is a new handshake
+	}}
+}
+
+// servedSerial is the serial of the certificate the server presents.
+func servedSerial(t *testing.T, c *http.Client, addr string) int64 {
+	t.Helper()
+	resp, err := c.Get("https://" + addr + "/")
+	if err != nil { t.Fatal(err) }
+	resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestReloadServesNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", 1, nil)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := issue(t, "server", 10, ca)
+	writeFile(t, certFile, first.pem)
+	writeFile(t, keyFile, first.keyPEM(t))
+	r, err := New(Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil { t.Fatal(err) }
+	addr := serve(t, r, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	c := client(ca)
+	if got := servedSerial(t, c, addr); got != 10 { t.Fatalf("serial %d, want 10", got) }
+
+	// a half-written rotation fails to load and keeps the old pair
+	second := issue(t, "server", 11, ca)
+	writeFile(t, certFile, second.pem)
+	if err := r.Reload(); err == nil { t.Fatal("Reload accepted a mismatched cert and key") }
+	if got := servedSerial(t, c, addr); got != 10 { t.Fatalf("serial %d after failed reload, want 10", got) }
+
+	writeFile(t, keyFile, second.keyPEM(t))
+	if err := r.Reload(); err != nil { t.Fatal(err) }
+	if got := servedSerial(t, c, addr); got != 11 { t.Fatalf("serial %d after reload, want 11", got) }
+}
+
+// Client certificates are verified against the CA bundle and mapped to
+// principals by subject.
+func TestMutualTLSPrincipal(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", 1, nil)
+	srvCert := issue(t, "server", 2, ca)
+	files := map[string][]byte{"cert.pem": srvCert.pem, "key.pem": srvCert.keyPEM(t), "ca.pem": ca.pem,
+		"keys.json": []byte(`{"keys":[{"name":"ingest","subject":"ingest-svc","scopes":["events:write"]}]}`)}
+	for name, b := range files { writeFile(t, filepath.Join(dir, name), b) }
+	r, err := New(Options{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), ClientCAFile: filepath.Join(dir, "ca.pem"), RequireCert: true})
+	if err != nil { t.Fatal(err) }
+	auth, err := mw.NewAuth(filepath.Join(dir, "keys.json"), nil, []mw.Rule{{Prefix: "/events", Scope: mw.ScopeWrite}})
+	if err != nil { t.Fatal(err) }
+	addr := serve(t, r, auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := mw.PrincipalFrom(r.Context())
+		w.Header().Set("X-Principal", p.Name+"/"+p.Via)
+	})))
+
+	if _, err := client(ca).Get("https://" + addr + "/events"); err == nil { t.Error("handshake without a client certificate succeeded") }
+	rogue := issue(t, "ingest-svc", 3, issue(t, "other-ca", 4, nil))
+	if _, err := client(ca, rogue.pair(t)).Get("https://" + addr + "/events"); err == nil { t.Error("certificate from another CA accepted") }
+
+	for cn, want := range map[string]int{"ingest-svc": 200, "unknown-svc": 401} {
+		resp, err := client(ca, issue(t, cn, 5, ca).pair(t)).Get("https://" + addr + "/events")
+		if err != nil { t.Fatalf("%s: %v", cn, err) }
+		resp.Body.Close()
+		if resp.StatusCode != want { t.Errorf("%s: %d, want %d", cn, resp.StatusCode, want) }
+		if want == 200 && resp.Header.Get("X-Principal") != "ingest/mtls" { t.Errorf("%s: principal %q", cn, resp.Header.Get("X-Principal")) }
+	}
+}
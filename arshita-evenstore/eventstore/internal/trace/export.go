@@ -0,0 +1,131 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// NewStdoutExporter writes one JSON object per span to w.
+func NewStdoutExporter(w io.Writer) Exporter { return &jsonExporter{w: w} }
+
+type jsonExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (e *jsonExporter) ExportSpans(spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, d := range spans {
+		rec := map[string]any{
+			"trace_id":    d.Context.TraceID.String(),
+			"span_id":     d.Context.SpanID.String(),
+			"name":        d.Name,
+			"kind":        kindNames[d.Kind],
+			"start":       d.Start,
+			"duration_ms": float64(d.End.Sub(d.Start).Microseconds()) / 1000,
+		}
+		if d.Parent.valid() { rec["parent_id"] = d.Parent.String() }
+		if len(d.Attrs) > 0 { rec["attributes"] = d.Attrs }
+		if d.Err != "" { rec["error"] = d.Err }
+		if err := enc.Encode(rec); err != nil { return err }
+	}
+	return nil
+}
+
+var kindNames = map[Kind]string{KindInternal: "internal", KindServer: "server", KindClient: "client", KindProducer: "producer", KindConsumer: "consumer"}
+
+// NewOTLPFileExporter appends spans to path in the OTLP/JSON encoding, one
+// ExportTraceServiceRequest per line, for a collector's file receiver or
+// offline loading.
+func NewOTLPFileExporter(path, service string) (Exporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil { return nil, err }
+	return &otlpFileExporter{f: f, service: service}, nil
+}
+
+type otlpFileExporter struct {
+	mu      sync.Mutex
+	f       *os.File
+	service string
+}
+
+type otlpKV struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string   `json:"traceId"`
+	SpanID            string   `json:"spanId"`
+	ParentSpanID      string   `json:"parentSpanId,omitempty"`
+	Name              string   `json:"name"`
+	Kind              Kind     `json:"kind"`
+	StartTimeUnixNano string   `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string   `json:"endTimeUnixNano"`
+	Attributes        []otlpKV `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"` // 2 = error
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func (e *otlpFileExporter) ExportSpans(spans []SpanData) error {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, d := range spans {
+		s := otlpSpan{
+			TraceID:           d.Context.TraceID.String(),
+			SpanID:            d.Context.SpanID.String(),
+			Name:              d.Name,
+			Kind:              d.Kind,
+			StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+		}
+		if d.Parent.valid() { s.ParentSpanID = d.Parent.String() }
+		for k, v := range d.Attrs { s.Attributes = append(s.Attributes, otlpKV{k, otlpValue(v)}) }
+		if d.Err != "" { s.Status.Code, s.Status.Message = 2, d.Err }
+		out = append(out, s)
+	}
+	req := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []otlpKV{{"service.name", otlpValue(e.service)}}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "eventstore/internal/trace"},
+				"spans": out,
+			}},
+		}},
+	}
+	b, err := json.Marshal(req)
+	if err != nil { return err }
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w := bufio.NewWriter(e.f)
+	w.Write(b)
+	w.WriteByte('\n')
+	return w.Flush()
+}
+
+// otlpValue wraps v in the OTLP AnyValue JSON shape.
+func otlpValue(v any) map[string]any {
+	switch x := v.(type) {
+	case string:
+		return map[string]any{"stringValue": x}
+	case bool:
+		return map[string]any{"boolValue": x}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(x)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(x, 10)}
+	case uint64:
+		return map[string]any{"intValue": strconv.FormatUint(x, 10)}
+	case float64:
+		return map[string]any{"doubleValue": x}
+	}
+	b, _ := json.Marshal(v)
+	return map[string]any{"stringValue": string(b)}
+}
@@ -0,0 +1,215 @@
+// Package trace is a small W3C Trace Context implementation: traceparent
+// propagation, spans, and pluggable exporters (stdout, OTLP-JSON file).
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+func (t TraceID) valid() bool    { return t != TraceID{} }
+func (s SpanID) valid() bool     { return s != SpanID{} }
+
+// SpanContext is what crosses process boundaries in a traceparent.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// ParseTraceparent reads a W3C traceparent header ("00-<trace>-<span>-<flags>").
+func ParseTraceparent(s string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" { return SpanContext{}, false }
+	if parts[0] == "00" && len(parts) != 4 { return SpanContext{}, false }
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 { return SpanContext{}, false }
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil { return SpanContext{}, false }
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil { return SpanContext{}, false }
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil { return SpanContext{}, false }
+	if !sc.TraceID.valid() || !sc.SpanID.valid() { return SpanContext{}, false }
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled { flags = "01" }
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+type ctxKey struct{}
+
+// ContextWith returns ctx carrying sc as the current (remote or local) parent.
+func ContextWith(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, ctxKey{}, sc)
+}
+
+// FromContext returns the current span context, if any.
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(ctxKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Kind follows the OTLP span kinds.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+// Span is one timed operation. A nil *Span is valid and does nothing.
+type Span struct {
+	data SpanData
+	once sync.Once
+}
+
+// SpanData is a finished span as handed to exporters.
+type SpanData struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID
+	Start, End time.Time
+	Attrs      map[string]any
+	Err        string
+}
+
+// Start begins a span as a child of the span context in ctx, or a new trace.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent, hasParent := FromContext(ctx)
+	sc := SpanContext{Sampled: true}
+	if hasParent {
+		sc.TraceID, sc.Sampled = parent.TraceID, parent.Sampled
+	} else {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+	s := &Span{data: SpanData{Name: name, Kind: kind, Context: sc, Start: time.Now()}}
+	if hasParent { s.data.Parent = parent.SpanID }
+	return ContextWith(ctx, sc), s
+}
+
+// Context is the span's own context, e.g. to inject into outgoing messages.
+func (s *Span) Context() SpanContext {
+	if s == nil { return SpanContext{} }
+	return s.data.Context
+}
+
+// SetAttr records an attribute (string, bool, int, int64, uint64, float64).
+// Not safe for concurrent use with End.
+func (s *Span) SetAttr(key string, v any) {
+	if s == nil { return }
+	if s.data.Attrs == nil { s.data.Attrs = map[string]any{} }
+	s.data.Attrs[key] = v
+}
+
+// SetError marks the span failed; nil is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil { return }
+	s.data.Err = err.Error()
+}
+
+// End finishes the span and queues it for export if it is sampled.
+func (s *Span) End() {
+	if s == nil { return }
+	s.once.Do(func() {
+		s.data.End = time.Now()
+		if p := current.Load(); p != nil && s.data.Context.Sampled { p.enqueue(s.data) }
+	})
+}
+
+// Exporter ships finished spans somewhere.
+type Exporter interface {
+	ExportSpans(spans []SpanData) error
+}
+
+var current atomic.Pointer[processor]
+
+// processor batches spans off the hot path; when its queue is full new
+// spans are dropped rather than slowing requests down.
+type processor struct {
+	exp     Exporter
+	ch      chan SpanData
+	done    chan struct{}
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex // guards ch against send after close
+	closed bool
+}
+
+// SetExporter starts exporting spans to e (nil turns export off). Spans
+// are still created and propagated without an exporter.
+func SetExporter(e Exporter) {
+	var p *processor
+	if e != nil {
+		p = &processor{exp: e, ch: make(chan SpanData, 4096), done: make(chan struct{})}
+		go p.run()
+	}
+	if old := current.Swap(p); old != nil { old.stop() }
+}
+
+// Shutdown flushes queued spans and stops exporting.
+func Shutdown() {
+	if p := current.Swap(nil); p != nil { p.stop() }
+}
+
+func (p *processor) enqueue(d SpanData) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed { return }
+	select {
+	case p.ch <- d:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+func (p *processor) run() {
+	defer close(p.done)
+	const maxBatch = 512
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 { return }
+		p.exp.ExportSpans(batch)
+		batch = nil
+	}
+	for {
+		select {
+		case d, ok := <-p.ch:
+			if !ok { flush(); return }
+			batch = append(batch, d)
+			if len(batch) >= maxBatch { flush() }
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+func (p *processor) stop() {
+	p.mu.Lock()
+	p.closed = true
+	close(p.ch)
+	p.mu.Unlock()
+	<-p.done
+}
@@ -0,0 +1,90 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type collector struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (c *collector) ExportSpans(spans []SpanData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(tp)
+	if !ok || !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" { t.Fatalf("%s: %+v %v", tp, sc, ok) }
+	if got := sc.Traceparent(); got != tp { t.Errorf("round trip: %s", got) }
+	if sc, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); !ok || sc.Sampled { t.Errorf("future version: %+v %v", sc, ok) }
+	for _, bad := range []string{
+		"",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-xbf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(bad); ok { t.Errorf("accepted %q", bad) }
+	}
+}
+
+func TestSpansExportedWithParents(t *testing.T) {
+	c := &collector{}
+	SetExporter(c)
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := Start(ContextWith(context.Background(), remote), "root", KindServer)
+	_, child := Start(ctx, "child", KindInternal)
+	child.SetAttr("key", "k")
+	child.End()
+	root.End()
+	root.End() // ends once
+	_, unsampled := Start(ContextWith(context.Background(), SpanContext{TraceID: remote.TraceID, SpanID: remote.SpanID}), "unsampled", KindInternal)
+	unsampled.End()
+	Shutdown()
+
+	if len(c.spans) != 2 { t.Fatalf("exported %d spans, want 2", len(c.spans)) }
+	ch, rt := c.spans[0], c.spans[1]
+	if rt.Context.TraceID != remote.TraceID || rt.Parent != remote.SpanID { t.Errorf("root %+v not under the remote parent", rt.Context) }
+	if ch.Context.TraceID != remote.TraceID || ch.Parent != rt.Context.SpanID || ch.Attrs["key"] != "k" { t.Errorf("child %+v", ch) }
+
+	_, fresh := Start(context.Background(), "new", KindInternal)
+	if fresh.Context().TraceID == remote.TraceID || !fresh.Context().Sampled { t.Errorf("no parent: %+v", fresh.Context()) }
+	var none *Span
+	none.SetAttr("a", 1)
+	none.End()
+}
+
+func TestOTLPFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	e, err := NewOTLPFileExporter(path, "eventstore")
+	if err != nil { t.Fatal(err) }
+	_, span := Start(context.Background(), "op", KindProducer)
+	span.SetAttr("n", 3)
+	span.SetError(os.ErrNotExist)
+	span.End()
+	if err := e.ExportSpans([]SpanData{span.data}); err != nil { t.Fatal(err) }
+
+	b, err := os.ReadFile(path)
+	if err != nil { t.Fatal(err) }
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct{ Spans []otlpSpan }
+		}
+	}
+	if err := json.Unmarshal(b, &req); err != nil { t.Fatal(err) }
+	got := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if got.TraceID != span.Context().TraceID.String() || got.Kind != KindProducer || got.Status.Code != 2 { t.Errorf("span %+v", got) }
+	if len(got.Attributes) != 1 || got.Attributes[0].Value["intValue"] != "3" { t.Errorf("attributes %+v", got.Attributes) }
+}
@@ -0,0 +1,218 @@
+// Package ws is a minimal RFC 6455 WebSocket server: handshake, framing,
+// fragmentation and control frames. No extensions or subprotocols.
+package ws
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Close codes used by the server.
+const (
+	CloseNormal      = 1000
+	CloseGoingAway   = 1001
+	CloseProtocol    = 1002
+	CloseUnsupported = 1003
+	CloseTooBig      = 1009
+	ClosePolicy      = 1008
+)
+
+const (
+	opCont   = 0x0
+	opText   = 0x1
+	opBinary = 0x2
+	opClose  = 0x8
+	opPing   = 0x9
+	opPong   = 0xA
+)
+
+var (
+	ErrClosed   = errors.New("websocket closed")
+	ErrTooBig   = errors.New("websocket message too big")
+	ErrProtocol = errors.New("websocket protocol error")
+)
+
+// Conn is a server side WebSocket connection. Reads must come from a single
+// goroutine; writes are safe for concurrent use.
+type Conn struct {
+	nc     net.Conn
+	br     *bufio.Reader
+	MaxMsg int64 // largest message accepted, default 1MB
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// On failure it has already written an HTTP error.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, ErrProtocol
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", 400)
+		return nil, ErrProtocol
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", 400)
+		return nil, ErrProtocol
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket unsupported", 500)
+		return nil, ErrProtocol
+	}
+	nc, rw, err := hj.Hijack()
+	if err != nil { return nil, err }
+
+	sum := sha1.Sum([]byte(key + guid))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	nc.SetDeadline(time.Time{})
+	if _, err := nc.Write([]byte(resp)); err != nil { nc.Close(); return nil, err }
+	return &Conn{nc: nc, br: rw.Reader, MaxMsg: 1 << 20}, nil
+}
+
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) { return true }
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text message, answering pings and
+// reassembling fragments on the way. A close frame from the peer is echoed
+// and reported as ErrClosed.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil { return nil, err }
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload, time.Now().Add(5*time.Second)); err != nil { return nil, err }
+			continue
+		case opPong:
+			continue
+		case opClose:
+			code := CloseNormal
+			if len(payload) >= 2 { code = int(binary.BigEndian.Uint16(payload)) }
+			c.Close(code, "")
+			return nil, ErrClosed
+		case opBinary:
+			c.Close(CloseUnsupported, "text frames only")
+			return nil, ErrProtocol
+		case opText:
+			if started { c.Close(CloseProtocol, ""); return nil, ErrProtocol }
+			started = true
+		case opCont:
+			if !started { c.Close(CloseProtocol, ""); return nil, ErrProtocol }
+		default:
+			c.Close(CloseProtocol, "")
+			return nil, ErrProtocol
+		}
+		if int64(len(msg)+len(payload)) > c.MaxMsg {
+			c.Close(CloseTooBig, "")
+			return nil, ErrTooBig
+		}
+		msg = append(msg, payload...)
+		if fin { return msg, nil }
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.br, h[:]); err != nil { return }
+	fin, op = h[0]&0x80 != 0, h[0]&0x0F
+	if h[0]&0x70 != 0 || h[1]&0x80 == 0 { // no extensions; clients must mask
+		c.Close(CloseProtocol, "")
+		return false, 0, nil, ErrProtocol
+	}
+	n := int64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil { return }
+		n = int64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil { return }
+		n = int64(binary.BigEndian.Uint64(b[:]))
+	}
+	if op >= opClose && (n > 125 || !fin) {
+		c.Close(CloseProtocol, "")
+		return false, 0, nil, ErrProtocol
+	}
+	if n < 0 || n > c.MaxMsg {
+		c.Close(CloseTooBig, "")
+		return false, 0, nil, ErrTooBig
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil { return }
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil { return }
+	for i := range payload { payload[i] ^= mask[i%4] }
+	return
+}
+
+// WriteText sends one text message; it fails if the peer does not drain it
+// within timeout.
+func (c *Conn) WriteText(b []byte, timeout time.Duration) error {
+	return c.writeFrame(opText, b, time.Now().Add(timeout))
+}
+
+// Ping sends a ping control frame.
+func (c *Conn) Ping(timeout time.Duration) error {
+	return c.writeFrame(opPing, nil, time.Now().Add(timeout))
+}
+
+func (c *Conn) writeFrame(op byte, payload []byte, deadline time.Time) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.nc.SetWriteDeadline(deadline)
+	if _, err := c.nc.Write(append(hdr, payload...)); err != nil { return err }
+	return nil
+}
+
+// Close sends a close frame with code and reason and drops the connection.
+// Calling it more than once is harmless.
+func (c *Conn) Close(code int, reason string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		b := binary.BigEndian.AppendUint16(nil, uint16(code))
+		if len(reason) > 123 { reason = reason[:123] }
+		c.writeFrame(opClose, append(b, reason...), time.Now().Add(time.Second))
+		err = c.nc.Close()
+	})
+	return err
+}
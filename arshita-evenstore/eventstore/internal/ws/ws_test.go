@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo serves one connection: every message is written back.
+func echo(maxMsg int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil { return }
+		c.MaxMsg = maxMsg
+		for {
+			b, err := c.ReadMessage()
+			if err != nil { return }
+			if err := c.WriteText(b, time.Second); err != nil { return }
+		}
+	}
+}
+
+type client struct {
+	nc net.Conn
+	br *bufio.Reader
+}
+
+func dial(t *testing.T, h http.Handler) *client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { nc.Close() })
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(nc, "GET / HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil { t.Fatal(err) }
+	if resp.StatusCode != http.StatusSwitchingProtocols { t.Fatalf("handshake: %s", resp.Status) }
+	// the RFC 6455 example key and accept value
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" { t.Fatalf("accept: %q", got) }
+	return &client{nc: nc, br: br}
+}
+
+func (c *client) write(t *testing.T, fin bool, op byte, payload []byte, masked bool) {
+	t.Helper()
+	b0 := op
+	if fin { b0 |= 0x80 }
+	hdr := []byte{b0, 0}
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	body := append([]byte(nil), payload...)
+	if masked {
+		hdr[1] |= 0x80
+		mask := []byte{1, 2, 3, 4}
+		hdr = append(hdr, mask...)
+		for i := range body { body[i] ^= mask[i%4] }
+	}
+	if _, err := c.nc.Write(append(hdr, body...)); err != nil { t.Fatal(err) }
+}
+
+func (c *client) read(t *testing.T) (op byte, payload []byte) {
+	t.Helper()
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil { t.Fatal(err) }
+	if h[1]&0x80 != 0 { t.Fatal("server frame is masked") }
+	n := int(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		io.ReadFull(c.br, b[:])
+		n = int(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		io.ReadFull(c.br, b[:])
+		n = int(binary.BigEndian.Uint64(b[:]))
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil { t.Fatal(err) }
+	return h[0] & 0x0F, payload
+}
+
+func (c *client) expectClose(t *testing.T, code int) {
+	t.Helper()
+	op, p := c.read(t)
+	if op != opClose || len(p) < 2 || int(binary.BigEndian.Uint16(p)) != code { t.Fatalf("want close %d, got op %d %q", code, op, p) }
+}
+
+func TestFragmentsAndControlFrames(t *testing.T) {
+	c := dial(t, echo(1<<20))
+	c.write(t, false, opText, []byte("hel"), true)
+	c.write(t, true, opPing, []byte("p"), true) // control frames may interleave
+	c.write(t, false, opCont, []byte("lo "), true)
+	c.write(t, true, opCont, []byte(strings.Repeat("x", 300)), true)
+
+	if op, p := c.read(t); op != opPong || string(p) != "p" { t.Fatalf("want pong, got op %d %q", op, p) }
+	if op, p := c.read(t); op != opText || string(p) != "hello "+strings.Repeat("x", 300) { t.Fatalf("echo: op %d %q", op, p) }
+
+	c.write(t, true, opClose, binary.BigEndian.AppendUint16(nil, CloseNormal), true)
+	c.expectClose(t, CloseNormal)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		send func(t *testing.T, c *client)
+		code int
+	}{
+		{"unmasked", func(t *testing.T, c *client) { c.write(t, true, opText, []byte("x"), false) }, CloseProtocol},
+		{"binary", func(t *testing.T, c *client) { c.write(t, true, opBinary, []byte("x"), true) }, CloseUnsupported},
+		{"continuation first", func(t *testing.T, c *client) { c.write(t, true, opCont, []byte("x"), true) }, CloseProtocol},
+		{"fragmented ping", func(t *testing.T, c *client) { c.write(t, false, opPing, nil, true) }, CloseProtocol},
+		{"too big", func(t *testing.T, c *client) { c.write(t, true, opText, make([]byte, 200), true) }, CloseTooBig},
+		{"too big in fragments", func(t *testing.T, c *client) {
+			c.write(t, false, opText, make([]byte, 80), true)
+			c.write(t, true, opCont, make([]byte, 80), true)
+		}, CloseTooBig},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := dial(t, echo(128))
+			tc.send(t, c)
+			c.expectClose(t, tc.code)
+		})
+	}
+}
+
+func TestUpgradeRejectsPlainRequests(t *testing.T) {
+	srv := httptest.NewServer(echo(1 << 20))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil { t.Fatal(err) }
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired { t.Fatalf("plain GET: %s", resp.Status) }
+}